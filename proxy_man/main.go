@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"proxy_man/mproxy"
@@ -21,6 +22,10 @@ func main() {
 
 	// 使用 LogCollector 包装原有 Logger
 	proxy.Logger = mproxy.NewLogCollector(proxy.Logger)
+	proxy.Resolver.Reload(cfg.DNS)
+	if err := proxy.Resolver.ReloadDial(cfg.Dial); err != nil {
+		log.Printf("警告: 全局拨号参数无效，使用默认值: %v", err)
+	}
 
 	// 初始化 MinIO
 	minioConfig := myminio.Config{
@@ -68,6 +73,40 @@ func main() {
 		log.Fatal("websocket server启动失败")
 	}
 
+	// SOCKS5 入站与 HTTP 代理共用同一套规则和连接面板
+	if cfg.SocksPort > 0 {
+		ln, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.SocksPort))
+		if err != nil {
+			log.Fatal("SOCKS5 监听失败", err)
+		}
+		log.Printf("SOCKS5 入站已启动: 127.0.0.1:%d", cfg.SocksPort)
+		go func() {
+			if err := proxy.ServeSocks5(ln); err != nil {
+				log.Printf("SOCKS5 服务错误: %v", err)
+			}
+		}()
+	}
+
+	// 混合端口：同一端口自动识别 HTTP / SOCKS4 / SOCKS5，与 Port 相同时替代 Port 上的 HTTP 服务
+	if cfg.MixedPort > 0 {
+		ln, err := net.Listen("tcp", fmt.Sprintf(":%d", cfg.MixedPort))
+		if err != nil {
+			log.Fatal("混合端口监听失败", err)
+		}
+		log.Printf("混合端口已启动: 127.0.0.1:%d", cfg.MixedPort)
+		if cfg.MixedPort == cfg.Port {
+			if err := proxy.ServeMixed(ln); err != nil {
+				log.Fatal("服务器错误", err)
+			}
+			return
+		}
+		go func() {
+			if err := proxy.ServeMixed(ln); err != nil {
+				log.Printf("混合端口服务错误: %v", err)
+			}
+		}()
+	}
+
 	s := http.Server{
 		Addr:    fmt.Sprintf(":%d", cfg.Port),
 		Handler: proxy,
@@ -9,10 +9,11 @@ type ConnectionInfo struct {
 	Method      string    `json:"method"`
 	URL         string    `json:"url"`
 	RemoteAddr  string    `json:"remote"`
-	Protocol    string    `json:"protocol"`  // HTTP / HTTPS-Tunnel / HTTPS-MITM
+	Protocol    string    `json:"protocol"`  // HTTP / HTTPS-Tunnel / HTTPS-MITM / SOCKS5 / SOCKS5-UDP / UDP
 	StartTime   time.Time `json:"startTime"`
 	Status      string    `json:"status"`    // "Active" 或 "Closed"
 	EndTime     time.Time `json:"endTime"`   // 连接关闭时间
+	Error       string    `json:"error,omitempty"` // 拨号或转发失败原因
 	PuploadRef  *int64	  `json:"-"` 
 	PdownloadRef *int64	  `json:"-"` 		// 用于读取父隧道实时值
 	UploadRef   *int64    `json:"-"`         // 用于读取子连接实时值，这是活动连接流量实时更新的关键
@@ -34,6 +35,17 @@ func (proxy *CoreHttpServer) MarkConnectionClosed(session int64) {
 	}
 }
 
+// MarkConnectionError 记录连接失败原因，供连接面板展示
+func (proxy *CoreHttpServer) MarkConnectionError(session int64, err error) {
+	if err == nil {
+		return
+	}
+	if value, ok := proxy.Connections.Load(session); ok {
+		info := value.(*ConnectionInfo)
+		info.Error = err.Error()
+	}
+}
+
 // CloseAndRemoveConnection 立即关闭并删除指定连接（手动关闭，跳过墓碑期）
 func (proxy *CoreHttpServer) CloseAndRemoveConnection(session int64) {
 	if value, ok := proxy.Connections.Load(session); ok {
@@ -32,6 +32,8 @@ type CoreHttpServer struct {
 	sess   int64          // 全局日志ID，每来一个请求都加1
 
 	Connections sync.Map // int64 (Session) -> *ConnectionInfo
+
+	Resolver *Resolver // 出站拨号与 IP 类规则使用的 DNS 解析器，由 DNSConfig 热更新
 }
 
 var Port = regexp.MustCompile(`:\d+$`)
@@ -124,5 +126,7 @@ func NewCoreHttpSever() *CoreHttpServer {
 			TLSClientConfig: tlsClientSkipVerify,
 		},
 	}
+	core_proxy.Resolver = NewResolver(core_proxy)
+	core_proxy.Transport.DialContext = core_proxy.Resolver.DialContext
 	return core_proxy
 }
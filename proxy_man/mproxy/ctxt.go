@@ -3,6 +3,7 @@ import(
 	"net/http"
 	"crypto/tls"
 	"net"
+	"net/netip"
 	"context"
 )
 
@@ -28,6 +29,11 @@ type Pcontext struct{
 	Dialer func(ctx context.Context, network string, addr string) (net.Conn, error)
 
 	exchangeCapture *ExchangeCapture // MITM Exchange 捕获状态
+
+	// 路由匹配期间的域名解析结果，IP 类规则共用，避免同一请求重复解析
+	resolved     bool
+	resolvedHost string
+	resolvedIPs  []netip.Addr
 }
 
 /*
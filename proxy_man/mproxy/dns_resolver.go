@@ -0,0 +1,511 @@
+package mproxy
+
+import (
+	"container/list"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	defaultDNSCacheSize = 4096
+	defaultDNSTimeout   = 5 * time.Second
+	dnsQueryLogSize     = 200             // 查询日志保留条数
+	dnsMinTTL           = 1 * time.Second // 上游返回 TTL=0 时至少缓存 1 秒，避免同一时刻重复查询
+)
+
+// 查询日志中的结果来源
+const (
+	DNSSourceHosts    = "hosts"
+	DNSSourceCache    = "cache"
+	DNSSourceUpstream = "upstream"
+	DNSSourceSystem   = "system"
+)
+
+// DNSQueryLog 单次域名解析的记录，供控制面查看
+type DNSQueryLog struct {
+	Time     time.Time `json:"time"`
+	Host     string    `json:"host"`
+	Source   string    `json:"source"`           // "hosts" | "cache" | "upstream" | "system"
+	Server   string    `json:"server,omitempty"` // 实际应答的上游
+	Answers  []string  `json:"answers,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Duration int64     `json:"duration"` // 耗时（毫秒）
+}
+
+// Resolver 内置 DNS 解析器。出站拨号（直连、二级代理服务器地址、socks5 本地解析）
+// 和 IP 类路由规则都经由它解析域名；未配置 DNS 时等同于系统解析器。
+// 配置通过 Reload 整体替换，替换时清空缓存
+type Resolver struct {
+	proxy  *CoreHttpServer
+	state  atomic.Pointer[resolverState] // nil 表示使用系统解析器
+	dialer atomic.Pointer[netDialer]     // 全局拨号参数编译结果，nil 表示使用默认参数
+
+	logMu   sync.Mutex
+	logs    []DNSQueryLog // 环形缓冲
+	logNext int
+
+	// Now 时间源，用于缓存过期判断，测试时可替换
+	Now func() time.Time
+	// TLSConfig DoT / DoH 上游使用的 TLS 配置，为 nil 时使用系统根证书
+	TLSConfig *tls.Config
+
+	// DNS 服务（入站）与 Fake-IP 地址池，由 reloadInbound 管理
+	reloadMu  sync.Mutex
+	fakeIP    atomic.Pointer[FakeIPPool]
+	fakeRange string // 当前地址池对应的配置，未变化时沿用地址池以保留映射
+	fakeStore string
+	server    *DNSServer
+}
+
+// resolverState 一份编译后的 DNS 配置
+type resolverState struct {
+	upstreams  []dnsUpstream
+	policy     *domainTrie     // 按域名后缀选择上游，节点 ids 为 policies 下标
+	policies   [][]dnsUpstream // NameserverPolicy 编译结果
+	hosts      map[string][]netip.Addr
+	cache      *dnsCache   // 为 nil 表示禁用缓存
+	fakeFilter *domainTrie // 不分配假地址的域名后缀
+	timeout    time.Duration
+	ipv6       bool
+}
+
+// NewResolver 创建解析器，初始使用系统解析器
+func NewResolver(proxy *CoreHttpServer) *Resolver {
+	return &Resolver{proxy: proxy, Now: time.Now}
+}
+
+// Reload 应用新的 DNS 配置，cfg 为 nil 时恢复系统解析器。无效的条目记录警告后跳过
+func (r *Resolver) Reload(cfg *DNSConfig) {
+	if cfg == nil {
+		r.state.Swap(nil).close()
+		r.proxy.Logger.Printf("INFO: DNS 使用系统解析器")
+		r.reloadInbound(nil)
+		return
+	}
+	st := &resolverState{
+		policy:     newDomainTrie(),
+		fakeFilter: newDomainTrie(),
+		hosts:      make(map[string][]netip.Addr, len(cfg.Hosts)),
+		timeout:    defaultDNSTimeout,
+		ipv6:       !cfg.DisableIPv6,
+	}
+	if cfg.Timeout > 0 {
+		st.timeout = time.Duration(cfg.Timeout) * time.Millisecond
+	}
+	switch {
+	case cfg.CacheSize > 0:
+		st.cache = newDNSCache(cfg.CacheSize)
+	case cfg.CacheSize == 0:
+		st.cache = newDNSCache(defaultDNSCacheSize)
+	}
+
+	st.upstreams = r.parseNameservers(cfg.Nameservers)
+	for domain, servers := range cfg.NameserverPolicy {
+		upstreams := r.parseNameservers(servers)
+		if len(upstreams) == 0 {
+			r.proxy.Logger.Printf("WARN: DNS 策略 %s 没有可用的上游，跳过", domain)
+			continue
+		}
+		st.policy.insert(normalizeDNSName(strings.TrimPrefix(domain, "+.")), len(st.policies))
+		st.policies = append(st.policies, upstreams)
+	}
+	for host, value := range cfg.Hosts {
+		var addrs []netip.Addr
+		for _, v := range strings.Split(value, ",") {
+			addr, err := netip.ParseAddr(strings.TrimSpace(v))
+			if err != nil {
+				r.proxy.Logger.Printf("WARN: DNS 静态解析 %s 的地址 %s 无效，跳过", host, v)
+				continue
+			}
+			addrs = append(addrs, addr.Unmap())
+		}
+		if len(addrs) > 0 {
+			st.hosts[normalizeDNSName(host)] = addrs
+		}
+	}
+
+	if cfg.FakeIP != nil {
+		for _, domain := range cfg.FakeIP.Filter {
+			st.fakeFilter.insert(normalizeDNSName(strings.TrimPrefix(domain, "+.")), 0)
+		}
+	}
+
+	r.state.Swap(st).close()
+	r.proxy.Logger.Printf("INFO: DNS 已加载，%d 个上游，%d 条策略，%d 条静态解析", len(st.upstreams), len(st.policies), len(st.hosts))
+	r.reloadInbound(cfg)
+}
+
+func (r *Resolver) parseNameservers(servers []string) []dnsUpstream {
+	upstreams := make([]dnsUpstream, 0, len(servers))
+	for _, s := range servers {
+		up, err := parseNameserver(s, r.TLSConfig)
+		if err != nil {
+			r.proxy.Logger.Printf("WARN: DNS 上游 %s 无效，跳过: %v", s, err)
+			continue
+		}
+		upstreams = append(upstreams, up)
+	}
+	return upstreams
+}
+
+// normalizeDNSName 统一小写并去掉末尾的点
+func normalizeDNSName(host string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(host), "."))
+}
+
+// close 释放旧配置中上游持有的连接（如 DoH 的连接池），st 为 nil 时无操作。
+// 正在进行的查询不受影响
+func (st *resolverState) close() {
+	if st == nil {
+		return
+	}
+	for _, ups := range append([][]dnsUpstream{st.upstreams}, st.policies...) {
+		for _, up := range ups {
+			if c, ok := up.(io.Closer); ok {
+				c.Close()
+			}
+		}
+	}
+}
+
+// upstreamsFor 返回域名应使用的上游：命中 NameserverPolicy 时取最长匹配的策略
+func (st *resolverState) upstreamsFor(host string) []dnsUpstream {
+	best := -1
+	st.policy.match(host, func(node *trieNode) bool {
+		best = node.ids[len(node.ids)-1]
+		return true
+	})
+	if best >= 0 {
+		return st.policies[best]
+	}
+	return st.upstreams
+}
+
+// LookupNetIP 解析域名，依次查询静态解析、缓存和上游；host 本身是 IP 时直接返回
+func (r *Resolver) LookupNetIP(c context.Context, host string) ([]netip.Addr, error) {
+	if addr, err := netip.ParseAddr(strings.Trim(host, "[]")); err == nil {
+		return []netip.Addr{addr.Unmap()}, nil
+	}
+	if r == nil {
+		return net.DefaultResolver.LookupNetIP(c, "ip", host)
+	}
+	host = normalizeDNSName(host)
+	start := time.Now()
+	entry := DNSQueryLog{Time: r.Now(), Host: host}
+	addrs, err := r.lookup(c, r.state.Load(), host, &entry)
+	entry.Duration = time.Since(start).Milliseconds()
+	for _, a := range addrs {
+		entry.Answers = append(entry.Answers, a.String())
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	r.appendLog(entry)
+	return addrs, err
+}
+
+func (r *Resolver) lookup(c context.Context, st *resolverState, host string, entry *DNSQueryLog) ([]netip.Addr, error) {
+	if st == nil {
+		entry.Source = DNSSourceSystem
+		return systemLookup(c, host)
+	}
+	if addrs, ok := st.hosts[host]; ok {
+		entry.Source = DNSSourceHosts
+		return append([]netip.Addr(nil), addrs...), nil
+	}
+	now := r.Now()
+	if addrs, ok := st.cache.get(host, now); ok {
+		entry.Source = DNSSourceCache
+		return addrs, nil
+	}
+
+	upstreams := st.upstreamsFor(host)
+	if len(upstreams) == 0 {
+		entry.Source = DNSSourceSystem
+		return systemLookup(c, host)
+	}
+	entry.Source = DNSSourceUpstream
+	addrs, ttl, server, err := r.query(c, st, upstreams, host)
+	entry.Server = server
+	if err != nil {
+		return nil, err
+	}
+	st.cache.put(host, addrs, now.Add(max(ttl, dnsMinTTL)))
+	return addrs, nil
+}
+
+func systemLookup(c context.Context, host string) ([]netip.Addr, error) {
+	addrs, err := net.DefaultResolver.LookupNetIP(c, "ip", host)
+	for i := range addrs {
+		addrs[i] = addrs[i].Unmap()
+	}
+	return addrs, err
+}
+
+// query 按顺序尝试上游，A 与 AAAA 并发查询。域名不存在是确定的结果，不再尝试后续上游
+func (r *Resolver) query(c context.Context, st *resolverState, upstreams []dnsUpstream, host string) ([]netip.Addr, time.Duration, string, error) {
+	types := []dnsmessage.Type{dnsmessage.TypeA}
+	if st.ipv6 {
+		types = append(types, dnsmessage.TypeAAAA)
+	}
+	var lastErr error
+	for _, up := range upstreams {
+		qc, cancel := context.WithTimeout(c, st.timeout)
+		results := make([]dnsAnswer, len(types))
+		var wg sync.WaitGroup
+		for i, qtype := range types {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results[i] = exchangeDNS(qc, up, host, qtype)
+			}()
+		}
+		wg.Wait()
+		cancel()
+
+		var addrs []netip.Addr
+		ttl := time.Duration(-1)
+		var failed, notFound int
+		for _, res := range results {
+			switch {
+			case res.err == nil:
+				addrs = append(addrs, res.addrs...)
+				if len(res.addrs) > 0 && (ttl < 0 || res.ttl < ttl) {
+					ttl = res.ttl
+				}
+			case isNotFound(res.err):
+				notFound++
+				lastErr = res.err
+			default:
+				failed++
+				lastErr = res.err
+			}
+		}
+		if len(addrs) > 0 {
+			return addrs, ttl, up.String(), nil
+		}
+		if failed == 0 {
+			// 所有查询都有应答但没有地址
+			if notFound == 0 {
+				lastErr = &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+			}
+			return nil, 0, up.String(), lastErr
+		}
+		r.proxy.Logger.Printf("WARN: DNS 上游 %s 查询 %s 失败: %v", up, host, lastErr)
+	}
+	return nil, 0, "", lastErr
+}
+
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// dnsAnswer 单个查询类型的结果
+type dnsAnswer struct {
+	addrs []netip.Addr
+	ttl   time.Duration
+	err   error
+}
+
+// exchangeDNS 构造查询报文发给上游并解析应答中的 A / AAAA 记录（CNAME 链由上游展开）
+func exchangeDNS(c context.Context, up dnsUpstream, host string, qtype dnsmessage.Type) dnsAnswer {
+	name, err := dnsmessage.NewName(host + ".")
+	if err != nil {
+		return dnsAnswer{err: fmt.Errorf("无效的域名 %s: %w", host, err)}
+	}
+	id := uint16(rand.Uint32())
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return dnsAnswer{err: err}
+	}
+	if err := b.Question(dnsmessage.Question{Name: name, Type: qtype, Class: dnsmessage.ClassINET}); err != nil {
+		return dnsAnswer{err: err}
+	}
+	query, err := b.Finish()
+	if err != nil {
+		return dnsAnswer{err: err}
+	}
+
+	resp, err := up.Exchange(c, query)
+	if err != nil {
+		return dnsAnswer{err: fmt.Errorf("%s: %w", up, err)}
+	}
+	return parseDNSAnswer(resp, id, host)
+}
+
+func parseDNSAnswer(resp []byte, id uint16, host string) dnsAnswer {
+	var p dnsmessage.Parser
+	h, err := p.Start(resp)
+	if err != nil {
+		return dnsAnswer{err: fmt.Errorf("无效的 DNS 应答: %w", err)}
+	}
+	if h.ID != id {
+		return dnsAnswer{err: fmt.Errorf("DNS 应答 ID 不匹配")}
+	}
+	switch h.RCode {
+	case dnsmessage.RCodeSuccess:
+	case dnsmessage.RCodeNameError:
+		return dnsAnswer{err: &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}}
+	default:
+		return dnsAnswer{err: fmt.Errorf("DNS 应答错误 %s", h.RCode)}
+	}
+	if err := p.SkipAllQuestions(); err != nil {
+		return dnsAnswer{err: err}
+	}
+
+	var ans dnsAnswer
+	for {
+		rh, err := p.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return dnsAnswer{err: err}
+		}
+		ttl := time.Duration(rh.TTL) * time.Second
+		switch rh.Type {
+		case dnsmessage.TypeA:
+			r, err := p.AResource()
+			if err != nil {
+				return dnsAnswer{err: err}
+			}
+			ans.addrs = append(ans.addrs, netip.AddrFrom4(r.A))
+		case dnsmessage.TypeAAAA:
+			r, err := p.AAAAResource()
+			if err != nil {
+				return dnsAnswer{err: err}
+			}
+			ans.addrs = append(ans.addrs, netip.AddrFrom16(r.AAAA).Unmap())
+		default:
+			if err := p.SkipAnswer(); err != nil {
+				return dnsAnswer{err: err}
+			}
+			continue
+		}
+		if len(ans.addrs) == 1 || ttl < ans.ttl {
+			ans.ttl = ttl
+		}
+	}
+	return ans
+}
+
+// ReloadDial 应用全局拨号参数（ServerConfig.Dial），与路由开关无关：
+// 未开启路由时的隧道、普通 HTTP 转发和 UDP 中继同样按该参数直连。参数无效时返回错误并保留原参数
+func (r *Resolver) ReloadDial(o DialOptions) error {
+	d, err := newNetDialer(r, o)
+	if err != nil {
+		return err
+	}
+	r.dialer.Store(d)
+	return nil
+}
+
+// DialContext 以全局拨号参数解析并建连（多个地址按 RFC 8305 竞速），签名兼容 http.Transport.DialContext。
+// 目标是 Fake-IP 假地址时先还原为域名
+func (r *Resolver) DialContext(c context.Context, network, addr string) (net.Conn, error) {
+	d := r.dialer.Load()
+	if d == nil {
+		d, _ = newNetDialer(r, DialOptions{})
+	}
+	return d.DialContext(c, network, addr)
+}
+
+// Dial 同 DialContext，供不带 context 的调用方使用
+func (r *Resolver) Dial(network, addr string) (net.Conn, error) {
+	return r.DialContext(context.Background(), network, addr)
+}
+
+// ======================== 查询日志 ========================
+
+func (r *Resolver) appendLog(entry DNSQueryLog) {
+	r.logMu.Lock()
+	defer r.logMu.Unlock()
+	if len(r.logs) < dnsQueryLogSize {
+		r.logs = append(r.logs, entry)
+		return
+	}
+	r.logs[r.logNext] = entry
+	r.logNext = (r.logNext + 1) % dnsQueryLogSize
+}
+
+// QueryLog 返回最近的解析记录，按时间先后排列
+func (r *Resolver) QueryLog() []DNSQueryLog {
+	r.logMu.Lock()
+	defer r.logMu.Unlock()
+	out := make([]DNSQueryLog, 0, len(r.logs))
+	out = append(out, r.logs[r.logNext:]...)
+	return append(out, r.logs[:r.logNext]...)
+}
+
+// ======================== 缓存 ========================
+
+// dnsCache 按域名缓存解析结果的 LRU，过期时间取应答中最小的 TTL
+type dnsCache struct {
+	mu      sync.Mutex
+	size    int
+	entries map[string]*list.Element
+	lru     *list.List // 队首为最近使用
+}
+
+type dnsCacheEntry struct {
+	host    string
+	addrs   []netip.Addr
+	expires time.Time
+}
+
+func newDNSCache(size int) *dnsCache {
+	return &dnsCache{size: size, entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+func (c *dnsCache) get(host string, now time.Time) ([]netip.Addr, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[host]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*dnsCacheEntry)
+	if !now.Before(e.expires) {
+		c.lru.Remove(el)
+		delete(c.entries, host)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return append([]netip.Addr(nil), e.addrs...), true
+}
+
+func (c *dnsCache) put(host string, addrs []netip.Addr, expires time.Time) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[host]; ok {
+		el.Value = &dnsCacheEntry{host: host, addrs: addrs, expires: expires}
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.entries[host] = c.lru.PushFront(&dnsCacheEntry{host: host, addrs: addrs, expires: expires})
+	if c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*dnsCacheEntry).host)
+	}
+}
@@ -0,0 +1,248 @@
+package mproxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsStub 本地 DNS 服务桩，按固定记录应答，记录收到的查询次数
+type dnsStub struct {
+	records map[string][]netip.Addr
+	queries atomic.Int32
+}
+
+func newDNSStub(records map[string]string) *dnsStub {
+	s := &dnsStub{records: make(map[string][]netip.Addr)}
+	for name, ips := range records {
+		for _, ip := range strings.Split(ips, ",") {
+			s.records[name] = append(s.records[name], netip.MustParseAddr(ip))
+		}
+	}
+	return s
+}
+
+// answer 构造应答：有记录时返回匹配类型的地址（TTL 60 秒），无记录时返回 NXDOMAIN
+func (s *dnsStub) answer(query []byte) []byte {
+	s.queries.Add(1)
+	var p dnsmessage.Parser
+	h, err := p.Start(query)
+	if err != nil {
+		return nil
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil
+	}
+	addrs, ok := s.records[strings.TrimSuffix(q.Name.String(), ".")]
+	rcode := dnsmessage.RCodeSuccess
+	if !ok {
+		rcode = dnsmessage.RCodeNameError
+	}
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: h.ID, Response: true, RecursionAvailable: true, RCode: rcode})
+	b.StartQuestions()
+	b.Question(q)
+	b.StartAnswers()
+	rh := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: 60}
+	for _, a := range addrs {
+		switch {
+		case a.Is4() && q.Type == dnsmessage.TypeA:
+			b.AResource(rh, dnsmessage.AResource{A: a.As4()})
+		case a.Is6() && q.Type == dnsmessage.TypeAAAA:
+			b.AAAAResource(rh, dnsmessage.AAAAResource{AAAA: a.As16()})
+		}
+	}
+	msg, _ := b.Finish()
+	return msg
+}
+
+// serveUDP 在本地 UDP 端口上提供服务，返回 "udp://地址"
+func (s *dnsStub) serveUDP(t *testing.T) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(s.answer(buf[:n]), addr)
+		}
+	}()
+	return "udp://" + pc.LocalAddr().String()
+}
+
+// serveDoH 启动 DoH 服务，返回查询地址和服务本身（用于取证书）
+func (s *dnsStub) serveDoH(t *testing.T) (string, *httptest.Server) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/dns-message")
+		w.Write(s.answer(query))
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL + "/dns-query", srv
+}
+
+// serveDoT 使用 certSrv 的证书启动 DoT 服务
+func (s *dnsStub) serveDoT(t *testing.T, certSrv *httptest.Server) string {
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: certSrv.TLS.Certificates})
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				var length [2]byte
+				if _, err := io.ReadFull(conn, length[:]); err != nil {
+					return
+				}
+				query := make([]byte, int(length[0])<<8|int(length[1]))
+				if _, err := io.ReadFull(conn, query); err != nil {
+					return
+				}
+				resp := s.answer(query)
+				conn.Write(append([]byte{byte(len(resp) >> 8), byte(len(resp))}, resp...))
+			}()
+		}
+	}()
+	return "tls://" + ln.Addr().String()
+}
+
+func TestResolver_UpstreamCacheAndHosts(t *testing.T) {
+	stub := newDNSStub(map[string]string{"www.example.com": "10.0.0.1,2001:db8::1"})
+	resolver := NewCoreHttpSever().Resolver
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	resolver.Now = func() time.Time { return now }
+	resolver.Reload(&DNSConfig{
+		Nameservers: []string{stub.serveUDP(t)},
+		Hosts:       map[string]string{"api.example.com": "192.0.2.9, 192.0.2.10"},
+	})
+
+	addrs, err := resolver.LookupNetIP(t.Context(), "WWW.example.com.")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []netip.Addr{netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("2001:db8::1")}, addrs)
+	assert.EqualValues(t, 2, stub.queries.Load(), "A 与 AAAA 各查询一次")
+
+	// TTL 内命中缓存
+	now = now.Add(59 * time.Second)
+	_, err = resolver.LookupNetIP(t.Context(), "www.example.com")
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, stub.queries.Load())
+
+	// TTL 过期后重新查询
+	now = now.Add(2 * time.Second)
+	_, err = resolver.LookupNetIP(t.Context(), "www.example.com")
+	require.NoError(t, err)
+	assert.EqualValues(t, 4, stub.queries.Load())
+
+	// 静态解析不经过上游
+	addrs, err = resolver.LookupNetIP(t.Context(), "api.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("192.0.2.9"), netip.MustParseAddr("192.0.2.10")}, addrs)
+	assert.EqualValues(t, 4, stub.queries.Load())
+
+	_, err = resolver.LookupNetIP(t.Context(), "missing.example.com")
+	var dnsErr *net.DNSError
+	require.ErrorAs(t, err, &dnsErr)
+	assert.True(t, dnsErr.IsNotFound)
+
+	var sources []string
+	for _, entry := range resolver.QueryLog() {
+		sources = append(sources, entry.Source)
+	}
+	assert.Equal(t, []string{DNSSourceUpstream, DNSSourceCache, DNSSourceUpstream, DNSSourceHosts, DNSSourceUpstream}, sources)
+	assert.NotEmpty(t, resolver.QueryLog()[4].Error)
+}
+
+func TestResolver_PolicyDoHAndDoT(t *testing.T) {
+	corp := newDNSStub(map[string]string{"git.corp.example": "10.9.9.9"})
+	public := newDNSStub(map[string]string{"git.corp.example": "203.0.113.1", "www.example.com": "203.0.113.2"})
+	dohURL, certSrv := corp.serveDoH(t)
+	dotAddr := public.serveDoT(t, certSrv)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(certSrv.Certificate())
+	resolver := NewCoreHttpSever().Resolver
+	resolver.TLSConfig = &tls.Config{RootCAs: pool}
+	resolver.Reload(&DNSConfig{
+		Nameservers:      []string{dotAddr},
+		NameserverPolicy: map[string][]string{"+.corp.example": {dohURL}},
+		DisableIPv6:      true,
+	})
+
+	addrs, err := resolver.LookupNetIP(t.Context(), "git.corp.example")
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("10.9.9.9")}, addrs)
+	assert.EqualValues(t, 1, corp.queries.Load(), "DisableIPv6 时只查询 A")
+
+	addrs, err = resolver.LookupNetIP(t.Context(), "www.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("203.0.113.2")}, addrs)
+	assert.EqualValues(t, 1, public.queries.Load())
+
+	log := resolver.QueryLog()
+	assert.Equal(t, dohURL, log[0].Server)
+	assert.Equal(t, dotAddr, log[1].Server)
+}
+
+func TestResolver_UsedByRulesAndDialers(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		if c, err := ln.Accept(); err == nil {
+			c.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	proxy := NewCoreHttpSever()
+	proxy.Resolver.Reload(&DNSConfig{Hosts: map[string]string{"backend.staging": "127.0.0.1"}})
+	router := NewRouter(proxy)
+	require.NoError(t, router.ReloadFromConfig(&ServerConfig{Routes: []RouteRule{
+		{Id: 1, Type: "IP-CIDR", Value: "127.0.0.0/8", Action: RejectTarget, Enable: true},
+	}}))
+
+	req, _ := http.NewRequest(http.MethodConnect, "http://backend.staging:443", nil)
+	target, _ := router.MatchRoute(req)
+	assert.Equal(t, RejectTarget, target, "IP 规则使用内置解析器的静态解析")
+
+	conn, err := router.Default.Dial("tcp", net.JoinHostPort("backend.staging", port))
+	require.NoError(t, err, "直连拨号使用内置解析器")
+	conn.Close()
+}
+
+func TestParseNameserver(t *testing.T) {
+	for in, want := range map[string]string{
+		"8.8.8.8":                      "udp://8.8.8.8:53",
+		"2001:4860:4860::8888":         "udp://[2001:4860:4860::8888]:53",
+		"1.1.1.1:5353":                 "udp://1.1.1.1:5353",
+		"tcp://9.9.9.9":                "tcp://9.9.9.9:53",
+		"tls://dns.google":             "tls://dns.google:853",
+		"https://dns.google/dns-query": "https://dns.google/dns-query",
+	} {
+		up, err := parseNameserver(in, nil)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, up.String())
+	}
+	_, err := parseNameserver("quic://dns.adguard.com", nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,311 @@
+package mproxy
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	fakeIPTTL      = 1  // 假地址应答的 TTL（秒），让客户端尽快重新查询，映射被复用时影响最小
+	dnsServerTTL   = 60 // 真实地址应答的 TTL（秒）
+	dnsServeTimout = 10 * time.Second
+)
+
+// DNSServer 内置 DNS 服务（入站），同时监听 UDP 和 TCP。
+// 启用 Fake-IP 时为 A 查询分配假地址，其余查询经由 Resolver 解析或转发给上游
+type DNSServer struct {
+	resolver *Resolver
+	listen   string
+	pc       net.PacketConn
+	ln       net.Listener
+	wg       sync.WaitGroup
+}
+
+// startDNSServer 在 listen 地址上启动 DNS 服务
+func startDNSServer(resolver *Resolver, listen string) (*DNSServer, error) {
+	pc, err := net.ListenPacket("udp", listen)
+	if err != nil {
+		return nil, err
+	}
+	// TCP 使用与 UDP 相同的端口（listen 端口为 0 时取 UDP 实际分配的端口）
+	ln, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		pc.Close()
+		return nil, err
+	}
+	s := &DNSServer{resolver: resolver, listen: listen, pc: pc, ln: ln}
+	s.wg.Add(2)
+	go s.serveUDP()
+	go s.serveTCP()
+	return s, nil
+}
+
+// Addr 返回实际监听的地址
+func (s *DNSServer) Addr() string { return s.pc.LocalAddr().String() }
+
+// Close 停止服务
+func (s *DNSServer) Close() error {
+	err := errors.Join(s.pc.Close(), s.ln.Close())
+	s.wg.Wait()
+	return err
+}
+
+func (s *DNSServer) serveUDP() {
+	defer s.wg.Done()
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := s.pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		query := append([]byte(nil), buf[:n]...)
+		go func() {
+			if resp := s.answer(query); resp != nil {
+				s.pc.WriteTo(resp, addr)
+			}
+		}()
+	}
+}
+
+func (s *DNSServer) serveTCP() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			for {
+				conn.SetDeadline(time.Now().Add(dnsServeTimout))
+				var length [2]byte
+				if _, err := io.ReadFull(conn, length[:]); err != nil {
+					return
+				}
+				query := make([]byte, binary.BigEndian.Uint16(length[:]))
+				if _, err := io.ReadFull(conn, query); err != nil {
+					return
+				}
+				resp := s.answer(query)
+				if resp == nil {
+					return
+				}
+				out := binary.BigEndian.AppendUint16(nil, uint16(len(resp)))
+				if _, err := conn.Write(append(out, resp...)); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// answer 处理一个查询报文，无法解析的报文返回 nil（直接丢弃）
+func (s *DNSServer) answer(query []byte) []byte {
+	var p dnsmessage.Parser
+	h, err := p.Start(query)
+	if err != nil || h.Response {
+		return nil
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil
+	}
+	name := normalizeDNSName(q.Name.String())
+	c, cancel := context.WithTimeout(context.Background(), dnsServeTimout)
+	defer cancel()
+
+	r := s.resolver
+	if q.Class != dnsmessage.ClassINET || (q.Type != dnsmessage.TypeA && q.Type != dnsmessage.TypeAAAA) {
+		// 其他类型原样转发给上游
+		resp, err := r.forward(c, name, query)
+		if err != nil {
+			return buildDNSResponse(h, q, dnsmessage.RCodeServerFailure, nil, 0)
+		}
+		return resp
+	}
+
+	if pool := r.FakeIP(); pool != nil && r.useFakeIP(name) {
+		var addrs []netip.Addr
+		if fake := pool.Allocate(name); fake.Is4() == (q.Type == dnsmessage.TypeA) {
+			addrs = append(addrs, fake)
+		}
+		return buildDNSResponse(h, q, dnsmessage.RCodeSuccess, addrs, fakeIPTTL)
+	}
+
+	addrs, err := r.LookupNetIP(c, name)
+	switch {
+	case isNotFound(err):
+		return buildDNSResponse(h, q, dnsmessage.RCodeNameError, nil, 0)
+	case err != nil:
+		return buildDNSResponse(h, q, dnsmessage.RCodeServerFailure, nil, 0)
+	}
+	return buildDNSResponse(h, q, dnsmessage.RCodeSuccess, addrs, dnsServerTTL)
+}
+
+// buildDNSResponse 构造应答报文，只写入与查询类型匹配的地址
+func buildDNSResponse(h dnsmessage.Header, q dnsmessage.Question, rcode dnsmessage.RCode, addrs []netip.Addr, ttl uint32) []byte {
+	b := dnsmessage.NewBuilder(nil, dnsmessage.Header{
+		ID:                 h.ID,
+		Response:           true,
+		RecursionDesired:   h.RecursionDesired,
+		RecursionAvailable: true,
+		RCode:              rcode,
+	})
+	b.EnableCompression()
+	b.StartQuestions()
+	b.Question(q)
+	b.StartAnswers()
+	rh := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: ttl}
+	for _, a := range addrs {
+		switch {
+		case a.Is4() && q.Type == dnsmessage.TypeA:
+			b.AResource(rh, dnsmessage.AResource{A: a.As4()})
+		case a.Is6() && q.Type == dnsmessage.TypeAAAA:
+			b.AAAAResource(rh, dnsmessage.AAAAResource{AAAA: a.As16()})
+		}
+	}
+	msg, _ := b.Finish()
+	return msg
+}
+
+// ======================== Resolver 侧的 Fake-IP 支持 ========================
+
+// FakeIP 返回当前的 Fake-IP 地址池，未启用时为 nil
+func (r *Resolver) FakeIP() *FakeIPPool {
+	if r == nil {
+		return nil
+	}
+	return r.fakeIP.Load()
+}
+
+// ServerAddr 返回内置 DNS 服务实际监听的地址，未启动时为空
+func (r *Resolver) ServerAddr() string {
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+	if r.server == nil {
+		return ""
+	}
+	return r.server.Addr()
+}
+
+// useFakeIP 静态解析、Filter 中的域名和单标签名称（如 localhost、局域网主机名）返回真实地址
+func (r *Resolver) useFakeIP(name string) bool {
+	if !strings.Contains(name, ".") {
+		return false
+	}
+	st := r.state.Load()
+	if st == nil {
+		return true
+	}
+	if _, ok := st.hosts[name]; ok {
+		return false
+	}
+	return !st.fakeFilter.contains(name)
+}
+
+// forward 将原始查询报文转发给域名对应的上游
+func (r *Resolver) forward(c context.Context, name string, query []byte) ([]byte, error) {
+	st := r.state.Load()
+	if st == nil {
+		return nil, errors.New("未配置上游")
+	}
+	var lastErr error = errors.New("未配置上游")
+	for _, up := range st.upstreamsFor(name) {
+		qc, cancel := context.WithTimeout(c, st.timeout)
+		resp, err := up.Exchange(qc, query)
+		cancel()
+		if err == nil {
+			return resp, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// RestoreFakeIP 将 "假地址:端口" 还原为 "域名:端口"，第二个返回值表示是否发生了还原
+func (r *Resolver) RestoreFakeIP(hostport string) (string, bool) {
+	return r.FakeIP().restore(hostport)
+}
+
+// restoreFakeIPRequest 请求目标是假地址时返回目标替换为原始域名的副本，否则返回 nil。
+// 路由匹配和 MITM 转发都使用还原后的请求，使域名规则和 SNI 保持正确
+func (r *Resolver) restoreFakeIPRequest(req *http.Request) *http.Request {
+	pool := r.FakeIP()
+	if pool == nil {
+		return nil
+	}
+	urlHost, ok1 := pool.restore(req.URL.Host)
+	host, ok2 := pool.restore(req.Host)
+	if !ok1 && !ok2 {
+		return nil
+	}
+	clone := *req
+	u := *req.URL
+	u.Host = urlHost
+	clone.URL, clone.Host = &u, host
+	return &clone
+}
+
+// reloadInbound 按配置启停 Fake-IP 地址池和 DNS 服务，配置未变化时沿用已有实例
+func (r *Resolver) reloadInbound(cfg *DNSConfig) {
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+
+	var fakeCfg *FakeIPConfig
+	var listen string
+	if cfg != nil {
+		fakeCfg, listen = cfg.FakeIP, cfg.Listen
+	}
+
+	old := r.fakeIP.Load()
+	switch {
+	case fakeCfg == nil:
+		r.fakeIP.Store(nil)
+	case old != nil && r.fakeRange == fakeCfg.Range && r.fakeStore == fakeCfg.Store:
+	default:
+		pool, err := NewFakeIPPool(fakeCfg.Range, fakeCfg.Store)
+		if err != nil {
+			r.proxy.Logger.Printf("WARN: Fake-IP 地址池创建失败，DNS 服务将返回真实地址: %v", err)
+		}
+		r.fakeIP.Store(pool)
+	}
+	if cur := r.fakeIP.Load(); old != nil && old != cur {
+		old.Close()
+	}
+	r.fakeRange, r.fakeStore = "", ""
+	if fakeCfg != nil {
+		r.fakeRange, r.fakeStore = fakeCfg.Range, fakeCfg.Store
+	}
+
+	if r.server != nil && r.server.listen == listen {
+		return
+	}
+	if r.server != nil {
+		r.server.Close()
+		r.server = nil
+	}
+	if listen == "" {
+		return
+	}
+	server, err := startDNSServer(r, listen)
+	if err != nil {
+		r.proxy.Logger.Printf("WARN: DNS 服务启动失败: %v", err)
+		return
+	}
+	r.server = server
+	mode := "真实地址"
+	if pool := r.fakeIP.Load(); pool != nil {
+		mode = "Fake-IP " + pool.Range().String()
+	}
+	r.proxy.Logger.Printf("INFO: DNS 服务已启动: %s（%s）", server.Addr(), mode)
+}
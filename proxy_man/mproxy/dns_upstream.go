@@ -0,0 +1,197 @@
+package mproxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"time"
+)
+
+// dnsUpstream DNS 上游服务器，发送一个查询报文并返回应答报文
+type dnsUpstream interface {
+	Exchange(c context.Context, query []byte) ([]byte, error)
+	String() string
+}
+
+// parseNameserver 解析上游地址："8.8.8.8"、"udp://8.8.8.8:53"、"tcp://..."、"tls://..."、"https://..."
+func parseNameserver(s string, tlsConfig *tls.Config) (dnsUpstream, error) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return &udpUpstream{addr: net.JoinHostPort(addr.String(), "53")}, nil
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		// 省略 scheme 的 "host:port" 视为 UDP
+		if _, _, splitErr := net.SplitHostPort(s); splitErr == nil {
+			return &udpUpstream{addr: s}, nil
+		}
+		return nil, fmt.Errorf("无效的地址 %s", s)
+	}
+	switch u.Scheme {
+	case "udp":
+		return &udpUpstream{addr: hostWithDefaultPort(u, "53")}, nil
+	case "tcp":
+		return &tcpUpstream{addr: hostWithDefaultPort(u, "53")}, nil
+	case "tls":
+		cfg := &tls.Config{}
+		if tlsConfig != nil {
+			cfg = tlsConfig.Clone()
+		}
+		if cfg.ServerName == "" {
+			cfg.ServerName = u.Hostname()
+		}
+		return &tlsUpstream{addr: hostWithDefaultPort(u, "853"), config: cfg}, nil
+	case "https":
+		tr := &http.Transport{
+			TLSClientConfig:     tlsConfig,
+			ForceAttemptHTTP2:   true,
+			MaxIdleConnsPerHost: 4,
+			IdleConnTimeout:     90 * time.Second,
+		}
+		return &httpsUpstream{url: u.String(), client: &http.Client{Transport: tr}}, nil
+	default:
+		return nil, fmt.Errorf("不支持的协议 %s", u.Scheme)
+	}
+}
+
+func hostWithDefaultPort(u *url.URL, port string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// udpUpstream 普通 UDP 上游，应答被截断时改用 TCP 重新查询
+type udpUpstream struct {
+	addr string
+}
+
+func (u *udpUpstream) String() string { return "udp://" + u.addr }
+
+func (u *udpUpstream) Exchange(c context.Context, query []byte) ([]byte, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(c, "udp", u.addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	setConnDeadline(c, conn)
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		// 丢弃 ID 不匹配的迟到应答
+		if n < 12 || buf[0] != query[0] || buf[1] != query[1] {
+			continue
+		}
+		if buf[2]&0x02 != 0 { // TC 位
+			return (&tcpUpstream{addr: u.addr}).Exchange(c, query)
+		}
+		return buf[:n], nil
+	}
+}
+
+// tcpUpstream TCP 上游，报文带 2 字节长度前缀
+type tcpUpstream struct {
+	addr string
+}
+
+func (u *tcpUpstream) String() string { return "tcp://" + u.addr }
+
+func (u *tcpUpstream) Exchange(c context.Context, query []byte) ([]byte, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(c, "tcp", u.addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return streamExchange(c, conn, query)
+}
+
+// tlsUpstream DNS over TLS（RFC 7858）
+type tlsUpstream struct {
+	addr   string
+	config *tls.Config
+}
+
+func (u *tlsUpstream) String() string { return "tls://" + u.addr }
+
+func (u *tlsUpstream) Exchange(c context.Context, query []byte) ([]byte, error) {
+	d := tls.Dialer{Config: u.config}
+	conn, err := d.DialContext(c, "tcp", u.addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return streamExchange(c, conn, query)
+}
+
+// streamExchange 在流式连接上发送一个带长度前缀的查询并读取应答
+func streamExchange(c context.Context, conn net.Conn, query []byte) ([]byte, error) {
+	setConnDeadline(c, conn)
+	msg := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(msg, uint16(len(query)))
+	copy(msg[2:], query)
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func setConnDeadline(c context.Context, conn net.Conn) {
+	if deadline, ok := c.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+}
+
+// httpsUpstream DNS over HTTPS（RFC 8484），POST application/dns-message。
+// DoH 地址中的域名由系统解析器解析
+type httpsUpstream struct {
+	url    string
+	client *http.Client
+}
+
+func (u *httpsUpstream) String() string { return u.url }
+
+// Close 释放连接池中的空闲连接，配置替换后由 Resolver 调用
+func (u *httpsUpstream) Close() error {
+	u.client.CloseIdleConnections()
+	return nil
+}
+
+func (u *httpsUpstream) Exchange(c context.Context, query []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(c, http.MethodPost, u.url, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 65535))
+}
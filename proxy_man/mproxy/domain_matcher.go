@@ -0,0 +1,291 @@
+package mproxy
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// ======================== 域名后缀树 ========================
+
+// domainTrie 按标签反转存储的后缀树：mail.google.com 依次存为 com → google → mail。
+// 查找时从域名末尾逐个标签向下走，耗时只与域名层级有关，与后缀数量无关
+type domainTrie struct {
+	root trieNode
+}
+
+type trieNode struct {
+	children map[string]*trieNode
+	end      bool  // 有后缀在此结束
+	ids      []int // 在此结束的后缀所属的规则下标（升序、去重）
+}
+
+func newDomainTrie() *domainTrie {
+	return &domainTrie{}
+}
+
+// insert 插入一个后缀（调用方负责转小写）
+func (t *domainTrie) insert(suffix string, id int) {
+	node := &t.root
+	for end := len(suffix); ; {
+		i := strings.LastIndexByte(suffix[:end], '.')
+		label := suffix[i+1 : end]
+		if node.children == nil {
+			node.children = make(map[string]*trieNode)
+		}
+		child, ok := node.children[label]
+		if !ok {
+			child = &trieNode{}
+			node.children[label] = child
+		}
+		node = child
+		if i < 0 {
+			break
+		}
+		end = i
+	}
+	node.end = true
+	if n := len(node.ids); n == 0 || node.ids[n-1] != id {
+		node.ids = append(node.ids, id)
+	}
+}
+
+// match 与 host == suffix || HasSuffix(host, "."+suffix) 等价，对每个命中的后缀节点调用 visit，
+// visit 返回 false 时停止
+func (t *domainTrie) match(host string, visit func(node *trieNode) bool) {
+	node := &t.root
+	for end := len(host); ; {
+		i := strings.LastIndexByte(host[:end], '.')
+		child, ok := node.children[host[i+1:end]]
+		if !ok {
+			return
+		}
+		node = child
+		if node.end && !visit(node) {
+			return
+		}
+		if i < 0 {
+			return
+		}
+		end = i
+	}
+}
+
+// contains 判断 host 是否命中任一后缀
+func (t *domainTrie) contains(host string) bool {
+	found := false
+	t.match(host, func(*trieNode) bool {
+		found = true
+		return false
+	})
+	return found
+}
+
+// ======================== Aho-Corasick 关键字匹配 ========================
+
+// keywordMatcher Aho-Corasick 自动机，一次扫描域名即可找出包含的所有关键字，
+// 耗时只与域名长度有关，与关键字数量无关
+type keywordMatcher struct {
+	edges  map[uint64]int32 // (状态<<8 | 字节) → 下一状态，根状态的转移单独存放
+	root   [256]int32
+	fail   []int32
+	report []int32 // 沿失败链最近的有输出的状态（含自身），0 表示没有
+	out    [][]int // 在该状态结束的关键字所属的规则下标
+}
+
+// newKeywordMatcher 构建自动机，keywords[i] 属于规则 ids[i]（调用方负责转小写）
+func newKeywordMatcher(keywords []string, ids []int) *keywordMatcher {
+	m := &keywordMatcher{
+		edges: make(map[uint64]int32),
+		fail:  []int32{0},
+		out:   [][]int{nil},
+	}
+	children := [][]byte{nil} // 仅构建期使用：每个状态的出边字节
+	for k, kw := range keywords {
+		if kw == "" {
+			continue
+		}
+		var s int32
+		for i := 0; i < len(kw); i++ {
+			next := m.next(s, kw[i])
+			if next == 0 {
+				next = int32(len(m.fail))
+				m.fail = append(m.fail, 0)
+				m.out = append(m.out, nil)
+				children = append(children, nil)
+				m.setNext(s, kw[i], next)
+				children[s] = append(children[s], kw[i])
+			}
+			s = next
+		}
+		if n := len(m.out[s]); n == 0 || m.out[s][n-1] != ids[k] {
+			m.out[s] = append(m.out[s], ids[k])
+		}
+	}
+
+	// 按 BFS 顺序计算失败指针，保证父状态先于子状态完成
+	m.report = make([]int32, len(m.fail))
+	queue := make([]int32, 0, len(m.fail))
+	for _, b := range children[0] {
+		queue = append(queue, m.root[b])
+	}
+	for len(queue) > 0 {
+		u := queue[0]
+		queue = queue[1:]
+		if len(m.out[u]) > 0 {
+			m.report[u] = u
+		} else {
+			m.report[u] = m.report[m.fail[u]]
+		}
+		for _, b := range children[u] {
+			v := m.next(u, b)
+			f := m.fail[u]
+			for f != 0 && m.next(f, b) == 0 {
+				f = m.fail[f]
+			}
+			m.fail[v] = m.next(f, b)
+			queue = append(queue, v)
+		}
+	}
+	return m
+}
+
+func (m *keywordMatcher) next(s int32, b byte) int32 {
+	if s == 0 {
+		return m.root[b]
+	}
+	return m.edges[uint64(s)<<8|uint64(b)]
+}
+
+func (m *keywordMatcher) setNext(s int32, b byte, next int32) {
+	if s == 0 {
+		m.root[b] = next
+		return
+	}
+	m.edges[uint64(s)<<8|uint64(b)] = next
+}
+
+// match 扫描 text，对每个出现的关键字所属的规则下标调用 visit，visit 返回 false 时停止
+func (m *keywordMatcher) match(text string, visit func(ids []int) bool) {
+	if m == nil || len(m.fail) == 1 {
+		return
+	}
+	var s int32
+	for i := 0; i < len(text); i++ {
+		b := text[i]
+		for s != 0 && m.next(s, b) == 0 {
+			s = m.fail[s]
+		}
+		s = m.next(s, b)
+		for o := m.report[s]; o != 0; o = m.report[m.fail[o]] {
+			if !visit(m.out[o]) {
+				return
+			}
+		}
+	}
+}
+
+// contains 判断 text 是否包含任一关键字
+func (m *keywordMatcher) contains(text string) bool {
+	found := false
+	m.match(text, func([]int) bool {
+		found = true
+		return false
+	})
+	return found
+}
+
+// isLiteralKeyword 不含正则元字符的纯 ASCII 关键字可以交给 Aho-Corasick 匹配
+func isLiteralKeyword(p string) bool {
+	for i := 0; i < len(p); i++ {
+		if p[i] >= 0x80 {
+			return false
+		}
+	}
+	return regexp.QuoteMeta(p) == p
+}
+
+// ======================== 路由级域名索引 ========================
+
+// domainIndex 将所有纯域名规则（DomainSuffix，以及值全为字面量的 DomainKeyword）
+// 合并编译为一棵后缀树和一个关键字自动机。匹配时一次查找得到全部命中的规则下标，
+// 按下标顺序直接跳到候选规则，中间未命中的域名规则不再逐条评估，规则顺序语义不变
+type domainIndex struct {
+	suffix     *domainTrie
+	keywords   *keywordMatcher
+	indexed    []bool // 规则是否由索引代为判断
+	nextOpaque []int  // 下标 i 之后（含）第一条需要逐条评估的规则
+}
+
+// buildDomainIndex 按规则顺序构建索引，routes 与编译后的规则一一对应
+func buildDomainIndex(routes []RouteRule) *domainIndex {
+	idx := &domainIndex{
+		suffix:     newDomainTrie(),
+		indexed:    make([]bool, len(routes)),
+		nextOpaque: make([]int, len(routes)+1),
+	}
+	var keywords []string
+	var keywordIds []int
+	for i, route := range routes {
+		values := splitRuleValues(route)
+		switch route.Type {
+		case "DomainSuffix":
+			for _, v := range values {
+				idx.suffix.insert(strings.ToLower(v), i)
+			}
+			idx.indexed[i] = true
+		case "DomainKeyword":
+			if !slices.ContainsFunc(values, func(v string) bool { return !isLiteralKeyword(v) }) {
+				for _, v := range values {
+					keywords = append(keywords, strings.ToLower(v))
+					keywordIds = append(keywordIds, i)
+				}
+				idx.indexed[i] = true
+			}
+		}
+	}
+	idx.keywords = newKeywordMatcher(keywords, keywordIds)
+
+	idx.nextOpaque[len(routes)] = len(routes)
+	for i := len(routes) - 1; i >= 0; i-- {
+		if idx.indexed[i] {
+			idx.nextOpaque[i] = idx.nextOpaque[i+1]
+		} else {
+			idx.nextOpaque[i] = i
+		}
+	}
+	return idx
+}
+
+// covers 规则 i 是否由索引代为判断；AddRule 追加的规则不在索引范围内
+func (idx *domainIndex) covers(i int) bool {
+	return idx != nil && i < len(idx.indexed) && idx.indexed[i]
+}
+
+// candidates 返回 host 命中的所有索引规则下标（升序、去重）
+func (idx *domainIndex) candidates(host string) []int {
+	var ids []int
+	idx.suffix.match(host, func(node *trieNode) bool {
+		ids = append(ids, node.ids...)
+		return true
+	})
+	idx.keywords.match(host, func(out []int) bool {
+		ids = append(ids, out...)
+		return true
+	})
+	if len(ids) > 1 {
+		slices.Sort(ids)
+		ids = slices.Compact(ids)
+	}
+	return ids
+}
+
+// skip 当前规则 i 由索引判定未命中时，返回下一条需要处理的规则下标：
+// 下一个候选规则和下一条需要逐条评估的规则中靠前的一个
+func (idx *domainIndex) skip(i int, cands []int) int {
+	next := idx.nextOpaque[i]
+	if pos, _ := slices.BinarySearch(cands, i); pos < len(cands) && cands[pos] < next {
+		next = cands[pos]
+	}
+	return next
+}
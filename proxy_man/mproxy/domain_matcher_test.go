@@ -0,0 +1,153 @@
+package mproxy
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDomainTrie_MatchesLinearSemantics(t *testing.T) {
+	suffixes := []string{"google.com", "co.uk", "com.cn", "a.b.c", "localhost"}
+	trie := newDomainTrie()
+	for _, s := range suffixes {
+		trie.insert(s, 0)
+	}
+	for _, host := range []string{
+		"google.com", "mail.google.com", "notgoogle.com", "google.com.evil",
+		"bbc.co.uk", "co.uk", "uk", "x.a.b.c", "b.c", "localhost", "", "com",
+	} {
+		want := false
+		for _, s := range suffixes {
+			if host == s || strings.HasSuffix(host, "."+s) {
+				want = true
+			}
+		}
+		assert.Equal(t, want, trie.contains(host), host)
+	}
+}
+
+func TestKeywordMatcher_MatchesContains(t *testing.T) {
+	keywords := []string{"he", "she", "his", "hers", "ads", "tracker", "a"}
+	rng := rand.New(rand.NewSource(1))
+	for k := range keywords {
+		m := newKeywordMatcher(keywords[:k+1], make([]int, k+1))
+		for range 500 {
+			b := make([]byte, rng.Intn(12))
+			for i := range b {
+				b[i] = "ahesrdtkc."[rng.Intn(10)]
+			}
+			text := string(b)
+			want := false
+			for _, kw := range keywords[:k+1] {
+				want = want || strings.Contains(text, kw)
+			}
+			require.Equal(t, want, m.contains(text), "%q %v", text, keywords[:k+1])
+		}
+	}
+
+	// 输出沿失败链传递："ushers" 同时包含 she / he / hers
+	m := newKeywordMatcher([]string{"he", "she", "hers"}, []int{1, 2, 3})
+	var got []int
+	m.match("ushers", func(ids []int) bool {
+		got = append(got, ids...)
+		return true
+	})
+	assert.ElementsMatch(t, []int{1, 2, 3}, got)
+}
+
+func TestRouter_DomainIndexKeepsRuleOrder(t *testing.T) {
+	router := NewRouter(NewCoreHttpSever())
+	require.NoError(t, router.ReloadFromConfig(&ServerConfig{
+		ProxyNodes: []ProxyNode{{Name: "node", URL: "http://127.0.0.1:1"}},
+		Routes: []RouteRule{
+			{Id: 1, Type: "DomainKeyword", Value: "ads", Action: RejectTarget, Enable: true},
+			{Id: 2, Type: "DST-PORT", Value: "8443", Action: "Direct", Enable: true},
+			{Id: 3, Type: "DomainSuffix", Value: "google.com", Action: "node", Enable: true},
+			{Id: 4, Type: "DomainKeyword", Value: `^cdn\d+\.`, Action: "Direct", Enable: true}, // 正则，不进索引
+			{Id: 5, Type: "DomainSuffix", Value: "cdn1.example.com,Example.COM", Action: RejectDropTarget, Enable: true},
+		},
+	}))
+	require.Equal(t, []bool{true, false, true, false, true}, router.index.indexed)
+
+	for host, want := range map[string]string{
+		"ads.google.com:443":   RejectTarget, // 关键字规则在前
+		"www.google.com:8443":  "Direct",     // 端口规则插在中间
+		"mail.google.com:443":  "node",
+		"cdn1.example.com:443": "Direct", // 正则规则先于后缀规则
+		"www.example.com:443":  RejectDropTarget,
+		"other.org:443":        "Direct",
+	} {
+		req, _ := http.NewRequest(http.MethodConnect, "http://"+host, nil)
+		target, _ := router.MatchRoute(req)
+		assert.Equal(t, want, target, host)
+
+		// 与不走索引的逐条评估结果一致
+		assert.Equal(t, router.Explain(req).Target, target, host)
+	}
+}
+
+// buildDomainRouter 构建 n 条域名规则（后缀与关键字各半），规则之间均匀穿插 5 条端口规则。
+// 端口规则无法进索引，需要逐条评估，数量固定以便比较域名规则增长带来的开销
+func buildDomainRouter(b *testing.B, n int) *Router {
+	routes := make([]RouteRule, 0, n+5)
+	for i := 0; i < n; i++ {
+		route := RouteRule{Id: len(routes) + 1, Type: "DomainSuffix", Value: fmt.Sprintf("site%d.example%d.com", i, i%97), Action: "Direct", Enable: true}
+		if i%2 == 1 {
+			route.Type, route.Value = "DomainKeyword", fmt.Sprintf("kw%dx", i)
+		}
+		routes = append(routes, route)
+		if i%(n/5) == n/5-1 {
+			routes = append(routes, RouteRule{Id: len(routes) + 1, Type: "DST-PORT", Value: "1", Action: "Direct", Enable: true})
+		}
+	}
+	proxy := NewCoreHttpSever()
+	proxy.Logger = log.New(io.Discard, "", 0)
+	router := NewRouter(proxy)
+	require.NoError(b, router.ReloadFromConfig(&ServerConfig{Routes: routes}))
+	return router
+}
+
+// BenchmarkRouter_MatchRoute 未命中任何规则是最坏情况，单次耗时应随规则数量基本持平
+func BenchmarkRouter_MatchRoute(b *testing.B) {
+	for _, n := range []int{100, 1000, 10000, 50000} {
+		router := buildDomainRouter(b, n)
+		req, _ := http.NewRequest(http.MethodConnect, "http://www.unmatched-host.example.org:443", nil)
+		b.Run(fmt.Sprintf("rules=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				router.MatchRoute(req)
+			}
+		})
+	}
+}
+
+// BenchmarkDomainRules_SingleRule 单条规则中包含大量值（如广告列表整体写在一条规则里）
+func BenchmarkDomainRules_SingleRule(b *testing.B) {
+	req, _ := http.NewRequest(http.MethodConnect, "http://www.unmatched-host.example.org:443", nil)
+	for _, n := range []int{100, 10000, 100000} {
+		suffixes := make([]string, n)
+		keywords := make([]string, n)
+		for i := range suffixes {
+			suffixes[i] = fmt.Sprintf("site%d.example.com", i)
+			keywords[i] = fmt.Sprintf("kw%dx", i)
+		}
+		suffix, keyword := DomainSuffixRule(suffixes...), DomainKeywordRule(keywords...)
+		b.Run(fmt.Sprintf("suffix/values=%d", n), func(b *testing.B) {
+			for b.Loop() {
+				suffix(req, nil)
+			}
+		})
+		b.Run(fmt.Sprintf("keyword/values=%d", n), func(b *testing.B) {
+			for b.Loop() {
+				keyword(req, nil)
+			}
+		})
+	}
+}
@@ -0,0 +1,235 @@
+package mproxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+)
+
+const defaultFakeIPRange = "198.18.0.0/15"
+
+// fakeIPFlushInterval 映射变化后写入持久化文件的间隔
+var fakeIPFlushInterval = 5 * time.Second
+
+// FakeIPPool Fake-IP 地址池，为域名分配假地址并维护双向映射。
+// 地址用尽后按分配顺序循环复用最早的地址
+type FakeIPPool struct {
+	mu       sync.Mutex
+	prefix   netip.Prefix
+	first    netip.Addr // 可分配的第一个地址（跳过网络地址）
+	last     netip.Addr // 可分配的最后一个地址（IPv4 跳过广播地址）
+	next     netip.Addr // 下一个待分配的地址
+	byDomain map[string]netip.Addr
+	byIP     map[netip.Addr]string
+
+	store     string
+	dirty     bool
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// fakeIPStoreFile 持久化文件格式
+type fakeIPStoreFile struct {
+	Range    string            `json:"range"`
+	Next     string            `json:"next"`
+	Mappings map[string]string `json:"mappings"` // IP -> 域名
+}
+
+// NewFakeIPPool 创建地址池，store 非空时从文件恢复映射并定期写回
+func NewFakeIPPool(cidr, store string) (*FakeIPPool, error) {
+	if cidr == "" {
+		cidr = defaultFakeIPRange
+	}
+	prefix, err := netip.ParsePrefix(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("无效的 Fake-IP 地址池 %s: %w", cidr, err)
+	}
+	prefix = prefix.Masked()
+	first, last := prefix.Addr().Next(), lastAddr(prefix)
+	if prefix.Addr().Is4() {
+		last = last.Prev()
+	}
+	if !first.IsValid() || !last.IsValid() || last.Less(first) || prefix.Addr().BitLen()-prefix.Bits() < 2 {
+		return nil, fmt.Errorf("Fake-IP 地址池 %s 太小", cidr)
+	}
+	p := &FakeIPPool{
+		prefix:   prefix,
+		first:    first,
+		last:     last,
+		next:     first,
+		byDomain: make(map[string]netip.Addr),
+		byIP:     make(map[netip.Addr]string),
+		store:    store,
+		stop:     make(chan struct{}),
+	}
+	if store != "" {
+		if err := p.load(); err != nil {
+			return nil, err
+		}
+		go p.flushLoop()
+	}
+	return p, nil
+}
+
+// lastAddr 返回前缀范围内的最后一个地址
+func lastAddr(p netip.Prefix) netip.Addr {
+	b := p.Addr().AsSlice()
+	for i := p.Bits(); i < len(b)*8; i++ {
+		b[i/8] |= 0x80 >> (i % 8)
+	}
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+// Range 返回地址池的网段
+func (p *FakeIPPool) Range() netip.Prefix { return p.prefix }
+
+// Contains 判断地址是否属于地址池
+func (p *FakeIPPool) Contains(addr netip.Addr) bool {
+	return p != nil && p.prefix.Contains(addr.Unmap())
+}
+
+// Lookup 根据假地址找回域名
+func (p *FakeIPPool) Lookup(addr netip.Addr) (string, bool) {
+	if !p.Contains(addr) {
+		return "", false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	domain, ok := p.byIP[addr.Unmap()]
+	return domain, ok
+}
+
+// Allocate 返回域名对应的假地址，已有映射时直接复用
+func (p *FakeIPPool) Allocate(domain string) netip.Addr {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if addr, ok := p.byDomain[domain]; ok {
+		return addr
+	}
+	addr := p.next
+	if old, ok := p.byIP[addr]; ok {
+		delete(p.byDomain, old) // 地址池已用完一轮，复用最早分配的地址
+	}
+	p.byIP[addr] = domain
+	p.byDomain[domain] = addr
+	if p.next = addr.Next(); p.last.Less(p.next) {
+		p.next = p.first
+	}
+	p.dirty = true
+	return addr
+}
+
+// Len 返回当前映射数量
+func (p *FakeIPPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.byIP)
+}
+
+// load 从持久化文件恢复映射，文件不存在时忽略；地址池网段变化时丢弃旧映射
+func (p *FakeIPPool) load() error {
+	data, err := os.ReadFile(p.store)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("读取 Fake-IP 映射文件失败: %w", err)
+	}
+	var f fakeIPStoreFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return fmt.Errorf("解析 Fake-IP 映射文件失败: %w", err)
+	}
+	if f.Range != p.prefix.String() {
+		return nil
+	}
+	for ip, domain := range f.Mappings {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil || addr.Less(p.first) || p.last.Less(addr) {
+			continue
+		}
+		p.byIP[addr] = domain
+		p.byDomain[domain] = addr
+	}
+	if next, err := netip.ParseAddr(f.Next); err == nil && !next.Less(p.first) && !p.last.Less(next) {
+		p.next = next
+	}
+	return nil
+}
+
+// save 写入持久化文件，使用临时文件原子重命名以防损坏
+func (p *FakeIPPool) save() error {
+	p.mu.Lock()
+	if !p.dirty {
+		p.mu.Unlock()
+		return nil
+	}
+	f := fakeIPStoreFile{Range: p.prefix.String(), Next: p.next.String(), Mappings: make(map[string]string, len(p.byIP))}
+	for addr, domain := range p.byIP {
+		f.Mappings[addr.String()] = domain
+	}
+	p.dirty = false
+	p.mu.Unlock()
+
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpFile := p.store + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, p.store)
+}
+
+func (p *FakeIPPool) flushLoop() {
+	ticker := time.NewTicker(fakeIPFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.save()
+		}
+	}
+}
+
+// Close 停止定期写入，并把未保存的映射写回文件
+func (p *FakeIPPool) Close() error {
+	var err error
+	p.closeOnce.Do(func() {
+		close(p.stop)
+		if p.store != "" {
+			err = p.save()
+		}
+	})
+	return err
+}
+
+// restore 将 "假地址:端口" 还原为 "域名:端口"，不是假地址或没有映射时原样返回
+func (p *FakeIPPool) restore(hostport string) (string, bool) {
+	if p == nil {
+		return hostport, false
+	}
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host, port = hostport, ""
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return hostport, false
+	}
+	domain, ok := p.Lookup(addr)
+	if !ok {
+		return hostport, false
+	}
+	if port == "" {
+		return domain, true
+	}
+	return net.JoinHostPort(domain, port), true
+}
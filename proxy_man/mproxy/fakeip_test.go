@@ -0,0 +1,127 @@
+package mproxy
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestFakeIPPool_AllocateAndWrap(t *testing.T) {
+	pool, err := NewFakeIPPool("198.18.0.0/29", "")
+	require.NoError(t, err)
+
+	a := pool.Allocate("a.example")
+	assert.Equal(t, netip.MustParseAddr("198.18.0.1"), a, "跳过网络地址")
+	assert.Equal(t, a, pool.Allocate("a.example"), "同一域名复用映射")
+
+	// 可用地址为 .1 - .6，第 7 个域名复用最早的 .1
+	for _, d := range []string{"b", "c", "d", "e", "f"} {
+		pool.Allocate(d + ".example")
+	}
+	g := pool.Allocate("g.example")
+	assert.Equal(t, a, g)
+	domain, ok := pool.Lookup(g)
+	assert.True(t, ok)
+	assert.Equal(t, "g.example", domain)
+	assert.Equal(t, 6, pool.Len())
+
+	restored, ok := pool.restore("198.18.0.2:443")
+	assert.True(t, ok)
+	assert.Equal(t, "b.example:443", restored)
+	_, ok = pool.restore("10.0.0.1:443")
+	assert.False(t, ok)
+
+	_, err = NewFakeIPPool("198.18.0.0/31", "")
+	assert.Error(t, err)
+}
+
+func TestFakeIPPool_Persist(t *testing.T) {
+	store := filepath.Join(t.TempDir(), "fakeip.json")
+	pool, err := NewFakeIPPool("", store)
+	require.NoError(t, err)
+	addr := pool.Allocate("www.example.com")
+	require.NoError(t, pool.Close())
+
+	pool, err = NewFakeIPPool("", store)
+	require.NoError(t, err)
+	defer pool.Close()
+	domain, ok := pool.Lookup(addr)
+	assert.True(t, ok, "重启后恢复映射")
+	assert.Equal(t, "www.example.com", domain)
+	assert.NotEqual(t, addr, pool.Allocate("next.example.com"), "分配位置一并恢复")
+
+	// 网段变化时丢弃旧映射
+	other, err := NewFakeIPPool("198.18.0.0/16", store)
+	require.NoError(t, err)
+	defer other.Close()
+	assert.Zero(t, other.Len())
+}
+
+// queryDNS 向 server 发送一个 A 查询，返回应答中的地址
+func queryDNS(t *testing.T, server, host string) dnsAnswer {
+	return exchangeDNS(t.Context(), &udpUpstream{addr: server}, host, dnsmessage.TypeA)
+}
+
+func TestDNSServer_FakeIPRouting(t *testing.T) {
+	stub := newDNSStub(map[string]string{"lan.example.com": "192.168.1.10", "www.example.com": "203.0.113.5"})
+	proxy := NewCoreHttpSever()
+	proxy.Resolver.Reload(&DNSConfig{
+		Nameservers: []string{stub.serveUDP(t)},
+		Hosts:       map[string]string{"nas.home.example": "192.168.1.2"},
+		Listen:      "127.0.0.1:0",
+		FakeIP:      &FakeIPConfig{Filter: []string{"+.lan.example.com"}},
+	})
+	t.Cleanup(func() { proxy.Resolver.Reload(nil) })
+	server := proxy.Resolver.ServerAddr()
+	require.NotEmpty(t, server)
+
+	ans := queryDNS(t, server, "www.example.com")
+	require.NoError(t, ans.err)
+	require.Len(t, ans.addrs, 1)
+	fake := ans.addrs[0]
+	assert.True(t, netip.MustParsePrefix(defaultFakeIPRange).Contains(fake))
+	assert.Zero(t, stub.queries.Load(), "分配假地址不查询上游")
+
+	// TCP 查询得到相同的映射
+	tcp := exchangeDNS(t.Context(), &tcpUpstream{addr: server}, "www.example.com", dnsmessage.TypeA)
+	require.NoError(t, tcp.err)
+	assert.Equal(t, []netip.Addr{fake}, tcp.addrs)
+
+	// Filter 与静态解析返回真实地址，上游不存在的域名返回 NXDOMAIN
+	ans = queryDNS(t, server, "lan.example.com")
+	require.NoError(t, ans.err)
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("192.168.1.10")}, ans.addrs)
+	ans = queryDNS(t, server, "nas.home.example")
+	require.NoError(t, ans.err)
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("192.168.1.2")}, ans.addrs)
+	ans = queryDNS(t, server, "missing.lan.example.com")
+	assert.True(t, isNotFound(ans.err))
+
+	// 连接到假地址时按原始域名匹配路由
+	router := NewRouter(proxy)
+	require.NoError(t, router.ReloadFromConfig(&ServerConfig{Routes: []RouteRule{
+		{Id: 1, Type: "DomainSuffix", Value: "example.com", Action: RejectTarget, Enable: true},
+	}}))
+	hostport := net.JoinHostPort(fake.String(), "443")
+	req, _ := http.NewRequest(http.MethodConnect, "http://"+hostport, nil)
+	target, _ := router.MatchRoute(req)
+	assert.Equal(t, RejectTarget, target)
+	assert.Equal(t, hostport, req.URL.Host, "原请求不被修改")
+
+	restored, ok := proxy.Resolver.RestoreFakeIP(hostport)
+	assert.True(t, ok)
+	assert.Equal(t, "www.example.com:443", restored)
+
+	// 关闭 Fake-IP 后同一监听地址返回真实地址
+	proxy.Resolver.Reload(&DNSConfig{Nameservers: []string{stub.serveUDP(t)}, Listen: "127.0.0.1:0"})
+	ans = queryDNS(t, proxy.Resolver.ServerAddr(), "www.example.com")
+	require.NoError(t, ans.err)
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("203.0.113.5")}, ans.addrs)
+	assert.Nil(t, proxy.Resolver.FakeIP())
+}
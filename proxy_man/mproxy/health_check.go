@@ -0,0 +1,284 @@
+package mproxy
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHealthInterval = 60 * time.Second
+	defaultHealthTimeout  = 5 * time.Second
+)
+
+// NodeHealth 单个出站节点的健康检查结果
+type NodeHealth struct {
+	Name        string    `json:"name"`
+	Alive       bool      `json:"alive"`
+	Latency     int64     `json:"latency"` // 最近一次成功探测的延迟（毫秒）
+	LastError   string    `json:"lastError,omitempty"`
+	LastCheck   time.Time `json:"lastCheck"`
+	Success     int64     `json:"success"`
+	Total       int64     `json:"total"`
+	SuccessRate float64   `json:"successRate"` // 0~1
+	Failures    int       `json:"failures"`    // 连续失败次数
+}
+
+// HealthChecker 后台周期性探测所有代理节点，记录延迟、成功率和最近错误，
+// 连续失败达到阈值的节点被标记为不可用，供代理组和路由规则避开
+type HealthChecker struct {
+	proxy *CoreHttpServer
+
+	mu      sync.RWMutex
+	cfg     HealthCheckConfig
+	dialers map[string]OutboundDialer
+	stats   map[string]*NodeHealth
+
+	stop    chan struct{}
+	trigger chan chan struct{}
+}
+
+// NewHealthChecker 创建健康检查器，调用 Reload 后才会开始探测
+func NewHealthChecker(proxy *CoreHttpServer) *HealthChecker {
+	return &HealthChecker{
+		proxy:   proxy,
+		dialers: make(map[string]OutboundDialer),
+		stats:   make(map[string]*NodeHealth),
+	}
+}
+
+// Reload 热更新探测配置和节点列表。同名节点的历史统计被保留，关闭时停止后台探测
+func (h *HealthChecker) Reload(cfg HealthCheckConfig, dialers map[string]OutboundDialer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.cfg = cfg
+	h.dialers = dialers
+	stats := make(map[string]*NodeHealth, len(dialers))
+	for name := range dialers {
+		if old, ok := h.stats[name]; ok {
+			stats[name] = old
+		} else {
+			stats[name] = &NodeHealth{Name: name, Alive: true}
+		}
+	}
+	h.stats = stats
+
+	// 重启后台循环使新的间隔立即生效
+	if h.stop != nil {
+		close(h.stop)
+		h.stop = nil
+	}
+	if cfg.Enable && len(dialers) > 0 {
+		h.stop = make(chan struct{})
+		h.trigger = make(chan chan struct{})
+		go h.loop(h.stop, h.trigger, cfg.interval())
+	}
+}
+
+// Enabled 健康检查是否在运行
+func (h *HealthChecker) Enabled() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.stop != nil
+}
+
+// CheckNow 立即执行一轮探测并等待完成，未启用时直接返回
+func (h *HealthChecker) CheckNow() {
+	h.mu.RLock()
+	trigger, running := h.trigger, h.stop != nil
+	h.mu.RUnlock()
+	if !running {
+		return
+	}
+	done := make(chan struct{})
+	select {
+	case trigger <- done:
+		<-done
+	case <-time.After(2*h.cfgSnapshot().timeout() + time.Second):
+	}
+}
+
+// IsHealthy 节点是否可用。未被检查或未启用检查的节点视为可用
+func (h *HealthChecker) IsHealthy(name string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.stop == nil {
+		return true
+	}
+	if st, ok := h.stats[name]; ok {
+		return st.Alive
+	}
+	return true
+}
+
+// Get 返回单个节点的检查结果
+func (h *HealthChecker) Get(name string) (NodeHealth, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.stop == nil {
+		return NodeHealth{}, false
+	}
+	st, ok := h.stats[name]
+	if !ok || st.Total == 0 {
+		return NodeHealth{}, false
+	}
+	return *st, true
+}
+
+// Snapshot 返回所有节点检查结果的副本，按名称排序
+func (h *HealthChecker) Snapshot() []NodeHealth {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	list := make([]NodeHealth, 0, len(h.stats))
+	for _, st := range h.stats {
+		list = append(list, *st)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// SkipUnhealthy 路由匹配时是否跳过目标不可用的规则
+func (h *HealthChecker) SkipUnhealthy() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.stop != nil && h.cfg.SkipUnhealthy
+}
+
+func (h *HealthChecker) cfgSnapshot() HealthCheckConfig {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.cfg
+}
+
+func (h *HealthChecker) loop(stop chan struct{}, trigger chan chan struct{}, interval time.Duration) {
+	h.checkAll()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			h.checkAll()
+		case done := <-trigger:
+			h.checkAll()
+			close(done)
+		}
+	}
+}
+
+// checkAll 并发探测所有节点
+func (h *HealthChecker) checkAll() {
+	h.mu.RLock()
+	cfg := h.cfg
+	dialers := make(map[string]OutboundDialer, len(h.dialers))
+	for name, d := range h.dialers {
+		dialers[name] = d
+	}
+	h.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for name, d := range dialers {
+		wg.Add(1)
+		go func(name string, d OutboundDialer) {
+			defer wg.Done()
+			delay, err := probeDialer(d, cfg)
+			h.record(name, delay, err, cfg.maxFailures())
+		}(name, d)
+	}
+	wg.Wait()
+}
+
+// probeDialer 配置了 TCP 目标时只测建连耗时，否则通过节点请求探测 URL
+func probeDialer(d OutboundDialer, cfg HealthCheckConfig) (time.Duration, error) {
+	if cfg.Target != "" {
+		start := time.Now()
+		conn, err := dialWithTimeout(d, "tcp", cfg.Target, cfg.timeout())
+		if err != nil {
+			return 0, err
+		}
+		conn.Close()
+		return time.Since(start), nil
+	}
+	url := cfg.URL
+	if url == "" {
+		url = defaultGroupTestURL
+	}
+	return URLTest(d, url, cfg.timeout())
+}
+
+// dialWithTimeout 为不支持 context 的 OutboundDialer.Dial 加上超时，超时后晚到的连接会被关闭
+func dialWithTimeout(d OutboundDialer, network, addr string, timeout time.Duration) (net.Conn, error) {
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		c, err := d.Dial(network, addr)
+		ch <- result{c, err}
+	}()
+	select {
+	case r := <-ch:
+		return r.conn, r.err
+	case <-time.After(timeout):
+		go func() {
+			if r := <-ch; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, fmt.Errorf("拨号 %s 超时 (%s)", addr, timeout)
+	}
+}
+
+func (h *HealthChecker) record(name string, delay time.Duration, err error, maxFailures int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	st, ok := h.stats[name]
+	if !ok {
+		return // 探测期间节点已被热重载移除
+	}
+	st.Total++
+	st.LastCheck = time.Now()
+	if err != nil {
+		st.Failures++
+		st.LastError = err.Error()
+		if st.Alive && st.Failures >= maxFailures {
+			st.Alive = false
+			h.proxy.Logger.Printf("WARN: [健康检查] 节点 %s 不可用: %v", name, err)
+		}
+	} else {
+		st.Success++
+		st.Failures = 0
+		st.Latency = delay.Milliseconds()
+		if !st.Alive {
+			h.proxy.Logger.Printf("INFO: [健康检查] 节点 %s 恢复可用 (%dms)", name, st.Latency)
+		}
+		st.Alive = true
+	}
+	st.SuccessRate = float64(st.Success) / float64(st.Total)
+}
+
+func (c HealthCheckConfig) interval() time.Duration {
+	if c.Interval <= 0 {
+		return defaultHealthInterval
+	}
+	return time.Duration(c.Interval) * time.Second
+}
+
+func (c HealthCheckConfig) timeout() time.Duration {
+	if c.Timeout <= 0 {
+		return defaultHealthTimeout
+	}
+	return time.Duration(c.Timeout) * time.Millisecond
+}
+
+func (c HealthCheckConfig) maxFailures() int {
+	if c.MaxFailures <= 0 {
+		return 1
+	}
+	return c.MaxFailures
+}
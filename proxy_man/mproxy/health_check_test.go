@@ -0,0 +1,63 @@
+package mproxy
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthChecker_TCPProbe(t *testing.T) {
+	stubs := newStubMembers(t, "up", "down")
+	stubs[1].down.Store(true)
+
+	h := NewHealthChecker(NewCoreHttpSever())
+	h.Reload(HealthCheckConfig{Enable: true, Target: "example.com:443", Interval: 3600, SkipUnhealthy: true},
+		map[string]OutboundDialer{"up": stubs[0], "down": stubs[1]})
+	defer h.Reload(HealthCheckConfig{}, nil)
+	h.CheckNow()
+
+	up, ok := h.Get("up")
+	require.True(t, ok)
+	assert.True(t, up.Alive)
+	assert.Equal(t, 1.0, up.SuccessRate)
+	assert.Empty(t, up.LastError)
+
+	down, ok := h.Get("down")
+	require.True(t, ok)
+	assert.False(t, down.Alive)
+	assert.Contains(t, down.LastError, "down is down")
+	assert.GreaterOrEqual(t, down.Total, int64(2)) // 启动时一轮 + CheckNow 一轮
+	assert.Zero(t, down.SuccessRate)
+
+	assert.True(t, h.IsHealthy("up"))
+	assert.False(t, h.IsHealthy("down"))
+	assert.True(t, h.IsHealthy("unknown"))
+	assert.True(t, h.SkipUnhealthy())
+	assert.Len(t, h.Snapshot(), 2)
+
+	// 关闭后所有节点视为可用
+	h.Reload(HealthCheckConfig{}, map[string]OutboundDialer{"down": stubs[1]})
+	assert.True(t, h.IsHealthy("down"))
+	assert.False(t, h.SkipUnhealthy())
+}
+
+func TestHealthChecker_MaxFailures(t *testing.T) {
+	stubs := newStubMembers(t, "flaky")
+	h := NewHealthChecker(NewCoreHttpSever())
+	h.Reload(HealthCheckConfig{Enable: true, Target: "example.com:443", Interval: 3600, MaxFailures: 3},
+		map[string]OutboundDialer{"flaky": stubs[0]})
+	defer h.Reload(HealthCheckConfig{}, nil)
+	h.CheckNow()
+
+	stubs[0].down.Store(true)
+	h.CheckNow()
+	h.CheckNow()
+	assert.True(t, h.IsHealthy("flaky"), "连续失败未达到阈值")
+	h.CheckNow()
+	assert.False(t, h.IsHealthy("flaky"))
+
+	stubs[0].down.Store(false)
+	h.CheckNow()
+	assert.True(t, h.IsHealthy("flaky"))
+}
@@ -2,6 +2,7 @@ package mproxy
 
 import (
 	"context"
+	"errors"
 	"io"
 	"net/http"
 	"net/url"
@@ -64,6 +65,7 @@ func (proxy *CoreHttpServer) MyHttpHandle(w http.ResponseWriter, r *http.Request
 
 	if err != nil {
 		ctxt.Error = err
+		proxy.MarkConnectionError(ctxt.Session, err)
 	}
 	if resp != nil {
 		// Body是顶层接口，底层是body结构体。
@@ -124,6 +126,18 @@ func (proxy *CoreHttpServer) MyHttpHandle(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	var rejectErr *RejectError
+	if resp == nil && errors.As(ctxt.Error, &rejectErr) && rejectErr.Drop {
+		// Reject-Drop：不写任何响应，接管连接后直接关闭
+		if hj, ok := w.(http.Hijacker); ok {
+			if conn, _, err := hj.Hijack(); err == nil {
+				conn.Close()
+				return
+			}
+		}
+		panic(http.ErrAbortHandler)
+	}
+
 	if resp == nil {
 		var errorString string
 		if ctxt.Error != nil {
@@ -220,7 +234,7 @@ func (proxy *CoreHttpServer) myHttpHandleWithEngine(w http.ResponseWriter, r *ht
 	// ========== 定义统一的请求处理函数（消除首个请求与后续请求的代码重复） ==========
 	processRequest := func(req *http.Request) bool {
 		// 每次请求内部创建 context，并在函数结束回收，避免 defer 堆积在外部循环中
-		requestContext, finishRequest := context.WithCancel(req.Context())
+		requestContext, finishRequest := context.WithCancel(withListenerAddr(req.Context(), r))
 		req = req.WithContext(requestContext)
 		defer finishRequest()
 
@@ -282,7 +296,12 @@ func (proxy *CoreHttpServer) myHttpHandleWithEngine(w http.ResponseWriter, r *ht
 			}()
 			if err != nil {
 				ctxt.SetCaptureError(err)
+				proxy.MarkConnectionError(ctxt.Session, err)
 				ctxt.WarnP("RoundTrip 失败: %v", err)
+				var rejectErr *RejectError
+				if errors.As(err, &rejectErr) && rejectErr.Drop {
+					return false // Reject-Drop：不写响应，由外层关闭连接
+				}
 				httpErrorNoClose(clientConn, ctxt, err)
 				return false
 			}
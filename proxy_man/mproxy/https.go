@@ -7,7 +7,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net"
 	"net/http"
 	"net/url"
@@ -136,7 +135,7 @@ func (proxy *CoreHttpServer) dial(ctx *Pcontext, network, addr string) (c net.Co
 	}
 	// 作为最底层的 TCP 拨号，无需再重复打印复杂的路由逻辑
 	// 避免与上层 Router 的日志混淆
-	return net.Dial(network, addr)
+	return proxy.Resolver.DialContext(ctx.Req.Context(), network, addr)
 }
 
 func (proxy *CoreHttpServer) connectDial(ctx *Pcontext, network, addr string) (c net.Conn, err error) {
@@ -163,6 +162,41 @@ func (proxy *CoreHttpServer) connectDial(ctx *Pcontext, network, addr string) (c
 	return proxy.ConnectDial(network, addr)
 }
 
+// ProxyAuthError 二级代理返回 407，说明未携带凭据或凭据错误
+type ProxyAuthError struct {
+	Proxy     string // 二级代理地址（不含凭据）
+	Challenge string // Proxy-Authenticate 头部
+	Body      string
+}
+
+func (e *ProxyAuthError) Error() string {
+	msg := "二级代理认证失败 " + e.Proxy + ": 407 Proxy Authentication Required"
+	if e.Challenge != "" {
+		msg += " (" + e.Challenge + ")"
+	}
+	return msg
+}
+
+// TargetDialError 二级代理已连通并完成握手，但拒绝或无法连接目标地址。
+// 说明问题出在目标而非节点本身，代理组不会因此把成员标记为不可用
+type TargetDialError struct {
+	Err error
+}
+
+func (e *TargetDialError) Error() string { return e.Err.Error() }
+
+func (e *TargetDialError) Unwrap() error { return e.Err }
+
+// isTargetStatus CONNECT 响应码是否表明问题出在目标：403/404 为目标被拒绝或不存在，
+// 502/504 为连接目标失败或超时。其余 5xx 多为节点自身故障，应让代理组切换成员
+func isTargetStatus(code int) bool {
+	switch code {
+	case http.StatusForbidden, http.StatusNotFound, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
 func DialerFromEnv(proxy *CoreHttpServer) func(network, addr string) (net.Conn, error) {
 	httpsProxy := os.Getenv("HTTPS_PROXY")
 	if httpsProxy == "" {
@@ -181,61 +215,111 @@ func (proxy *CoreHttpServer) NewConnectDialToProxy(httpsProxy string) func(netwo
 func (proxy *CoreHttpServer) NewConnectDialToProxyWithHandler(
 	httpsProxy string,
 	connectReqHandler func(req *http.Request),
+) func(network, addr string) (net.Conn, error) {
+	return proxy.NewConnectDialToProxyWithTLS(httpsProxy, nil, connectReqHandler)
+}
+
+// NewConnectDialToProxyWithTLS 与 NewConnectDialToProxyWithHandler 相同，额外支持 https:// 二级加密代理。
+// tlsConfig 仅用于 proxy→二级代理 这一跳，为 nil 时以代理主机名作为 SNI 并校验证书
+func (proxy *CoreHttpServer) NewConnectDialToProxyWithTLS(
+	httpsProxy string,
+	tlsConfig *tls.Config,
+	connectReqHandler func(req *http.Request),
+) func(network, addr string) (net.Conn, error) {
+	return proxy.newConnectDialToProxy(httpsProxy, tlsConfig, connectReqHandler, nil)
+}
+
+// newConnectDialToProxy 创建经由 HTTP/HTTPS 二级代理建隧道的拨号函数。
+// dial 非 nil 时到二级代理的 TCP 连接由 dial 建立（链式代理或带拨号参数的直连），否则使用 proxy.dial
+func (proxy *CoreHttpServer) newConnectDialToProxy(
+	httpsProxy string,
+	tlsConfig *tls.Config,
+	connectReqHandler func(req *http.Request),
+	dial dialContextFunc,
 ) func(network, addr string) (net.Conn, error) {
 	u, err := url.Parse(httpsProxy)
 	if err != nil {
 		return nil
 	}
-	// 二级普通代理。我们暂时不考虑二级加密代理
-	if u.Scheme == "" || u.Scheme == "http" {
+	var isTLS bool
+	switch u.Scheme {
+	case "", "http":
 		if !strings.ContainsRune(u.Host, ':') {
 			u.Host += ":80"
 		}
-		return func(network, addr string) (net.Conn, error) {
-			connectReq := &http.Request{
-				Method: http.MethodConnect,
-				URL:    &url.URL{Opaque: addr},
-				Host:   addr,
-				Header: make(http.Header),
-			}
-			if connectReqHandler != nil {
-				// 可通过connectReqHandler注入自定义header等进行认证，也可修改二级代理地址
-				// 二级代理基本不需要使用，因为通过proxy.dial和proxy.ConnectDialWithReq
-				// 已经完全满足灵活的二级代理，这里只考虑需要header认证时使用
-				connectReqHandler(connectReq)
+	case "https":
+		// 二级加密代理，先完成 TLS 握手再发送 CONNECT
+		isTLS = true
+		if !strings.ContainsRune(u.Host, ':') {
+			u.Host += ":443"
+		}
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		} else {
+			tlsConfig = tlsConfig.Clone()
+		}
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = u.Hostname()
+		}
+	default:
+		proxy.Logger.Printf("WARN: 只支持 http/https 二级代理，检查代理 URL 的 scheme: %s", u.Scheme)
+		return nil
+	}
+	return func(network, addr string) (net.Conn, error) {
+		connectReq := &http.Request{
+			Method: http.MethodConnect,
+			URL:    &url.URL{Opaque: addr},
+			Host:   addr,
+			Header: make(http.Header),
+		}
+		if connectReqHandler != nil {
+			// 可通过connectReqHandler注入自定义header等进行认证，也可修改二级代理地址
+			// 二级代理基本不需要使用，因为通过proxy.dial和proxy.ConnectDialWithReq
+			// 已经完全满足灵活的二级代理，这里只考虑需要header认证时使用
+			connectReqHandler(connectReq)
+		}
+		// 建立tcp连接
+		c, err := proxy.dial(&Pcontext{Req: &http.Request{}, Dialer: dial}, network, u.Host)
+		if err != nil {
+			return nil, err
+		}
+		if isTLS {
+			tlsConn := tls.Client(c, tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				_ = c.Close()
+				return nil, fmt.Errorf("二级加密代理 TLS 握手失败 %s: %w", u.Host, err)
 			}
-			// 建立tcp连接
-			c, err := proxy.dial(&Pcontext{Req: &http.Request{}}, network, u.Host)
+			c = tlsConn
+		}
+		// 发起connect请求
+		_ = connectReq.Write(c)
+		// Read response.
+		// Okay to use and discard buffered reader here, because
+		// TLS server will not speak until spoken to.
+		br := bufio.NewReader(c)
+		resp, err := http.ReadResponse(br, connectReq)
+		if err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			body, err := io.ReadAll(io.LimitReader(resp.Body, _errorRespMaxLength))
 			if err != nil {
 				return nil, err
 			}
-			// 发起connect请求
-			_ = connectReq.Write(c)
-			// Read response.
-			// Okay to use and discard buffered reader here, because
-			// TLS server will not speak until spoken to.
-			br := bufio.NewReader(c)
-			resp, err := http.ReadResponse(br, connectReq)
-			if err != nil {
-				_ = c.Close()
-				return nil, err
+			_ = c.Close()
+			if resp.StatusCode == http.StatusProxyAuthRequired {
+				return nil, &ProxyAuthError{Proxy: u.Host, Challenge: resp.Header.Get("Proxy-Authenticate"), Body: string(body)}
 			}
-			defer resp.Body.Close()
-			if resp.StatusCode != http.StatusOK {
-				resp, err := io.ReadAll(io.LimitReader(resp.Body, _errorRespMaxLength))
-				if err != nil {
-					return nil, err
-				}
-				_ = c.Close()
-				return nil, errors.New("proxy refused connection" + string(resp))
+			err = fmt.Errorf("proxy refused connection: %s: %s", resp.Status, body)
+			if isTargetStatus(resp.StatusCode) {
+				return nil, &TargetDialError{Err: err}
 			}
-			// 普通隧道
-			log.Println("二级隧道建立*******************************")
-			return c, nil
+			return nil, err
 		}
+		return c, nil
 	}
-	log.Println("Warn: 只支持二级普通代理，检查是否误用二级加密代理")
-	return nil
 }
 
 func httpError(w io.WriteCloser, ctx *Pcontext, err error) {
@@ -303,15 +387,50 @@ func copyOrWarn(ctx *Pcontext, dst io.Writer, src io.Reader) error {
 	return err
 }
 
-func (proxy *CoreHttpServer) MyHttpsHandle(w http.ResponseWriter, r *http.Request) {
-	// 统计connect的session号，是最基础的tcp连接，所有数据都通过该隧道
-	topctx := &Pcontext{
-		core_proxy:     proxy,
-		Req:            r,
-		TrafficCounter: &TrafficCounter{},
-		Session:        atomic.AddInt64(&proxy.sess, 1),
+// connectReplier 入站协议在 CONNECT 会话各阶段的应答方式。HTTP 代理回复状态行，SOCKS 回复应答包，
+// 策略选择、路由、隧道/MITM 与流量统计由 handleConnect 统一处理
+type connectReplier interface {
+	protocol() string                                   // 顶层隧道连接记录的协议标签
+	established(conn net.Conn, mitm bool) error         // 目标已连通或进入 MITM
+	dialFailed(conn net.Conn, ctx *Pcontext, err error) // 拨号失败，写回错误并关闭连接
+	rejected(conn net.Conn, err *RejectError)           // 命中拒绝规则
+	refused(conn net.Conn, ctx *Pcontext)               // httpsHandlers 选择了 ConnectReject
+}
+
+// httpConnectReplier HTTP CONNECT 的应答
+type httpConnectReplier struct{}
+
+func (httpConnectReplier) protocol() string { return "TUNNEL" }
+
+func (httpConnectReplier) established(conn net.Conn, mitm bool) error {
+	if mitm {
+		_, err := conn.Write([]byte("HTTP/1.0 200 OK\r\n\r\n"))
+		return err
+	}
+	_, err := conn.Write([]byte("HTTP/1.0 200 Connection established\r\n\r\n"))
+	return err
+}
+
+func (httpConnectReplier) dialFailed(conn net.Conn, ctx *Pcontext, err error) {
+	httpError(conn, ctx, err) // 如果出错手动关闭客户端连接
+}
+
+func (httpConnectReplier) rejected(conn net.Conn, err *RejectError) {
+	// 命中拒绝规则：回复状态码或直接断开，不建立任何上游连接
+	if !err.Drop {
+		_ = writeRejectResponse(conn, err)
+	}
+}
+
+func (httpConnectReplier) refused(conn net.Conn, ctx *Pcontext) {
+	if ctx.Resp != nil {
+		if err := ctx.Resp.Write(conn); err != nil {
+			ctx.WarnP("Cannot write response that reject http CONNECT: %v", err)
+		}
 	}
+}
 
+func (proxy *CoreHttpServer) MyHttpsHandle(w http.ResponseWriter, r *http.Request) {
 	// 创建hijack
 	hijk, ok := w.(http.Hijacker)
 	if !ok {
@@ -323,6 +442,18 @@ func (proxy *CoreHttpServer) MyHttpsHandle(w http.ResponseWriter, r *http.Reques
 	if err != nil {
 		panic("hijack connection fail" + err.Error())
 	}
+	proxy.handleConnect(connFromClinet, r, httpConnectReplier{})
+}
+
+// handleConnect 处理一个已接管的 CONNECT 会话，r 为 CONNECT 请求（SOCKS 入站为构造的等价请求）
+func (proxy *CoreHttpServer) handleConnect(connFromClinet net.Conn, r *http.Request, reply connectReplier) {
+	// 统计connect的session号，是最基础的tcp连接，所有数据都通过该隧道
+	topctx := &Pcontext{
+		core_proxy:     proxy,
+		Req:            r,
+		TrafficCounter: &TrafficCounter{},
+		Session:        atomic.AddInt64(&proxy.sess, 1),
+	}
 
 	// 创建顶层隧道连接记录
 	tunnelSession := topctx.Session
@@ -333,7 +464,7 @@ func (proxy *CoreHttpServer) MyHttpsHandle(w http.ResponseWriter, r *http.Reques
 		Method:      "CONNECT",
 		URL:         r.URL.Host,
 		RemoteAddr:  r.RemoteAddr,
-		Protocol:    "TUNNEL",
+		Protocol:    reply.protocol(),
 		StartTime:   time.Now(),
 		Status:      "Active",
 		UploadRef:   &topctx.TrafficCounter.req_sum,
@@ -370,15 +501,25 @@ func (proxy *CoreHttpServer) MyHttpsHandle(w http.ResponseWriter, r *http.Reques
 		}
 		connRemoteSite, err := proxy.connectDial(topctx, "tcp", host)
 
+		var rejectErr *RejectError
+		if errors.As(err, &rejectErr) {
+			topctx.Log_P("规则拒绝 CONNECT %s -> %s", host, rejectErr.Target)
+			proxy.MarkConnectionError(tunnelSession, err)
+			reply.rejected(connFromClinet, rejectErr)
+			_ = connFromClinet.Close()
+			proxy.MarkConnectionClosed(tunnelSession)
+			return
+		}
 		if err != nil {
 			topctx.WarnP("拨号获取套接字错误Error dialing to %s: %s", host, err.Error())
-			httpError(connFromClinet, topctx, err) // 如果出错手动关闭客户端连接
+			proxy.MarkConnectionError(tunnelSession, err)
+			reply.dialFailed(connFromClinet, topctx, err)
 			proxy.MarkConnectionClosed(tunnelSession)
 			return
 		}
 		topctx.Log_P("Accepting CONNECT to %s", host)
 
-		_, err = connFromClinet.Write([]byte("HTTP/1.0 200 Connection established\r\n\r\n"))
+		err = reply.established(connFromClinet, false)
 		if err != nil {
 			topctx.WarnP("200 Connection fail established")
 			proxy.MarkConnectionClosed(tunnelSession)
@@ -469,7 +610,7 @@ func (proxy *CoreHttpServer) MyHttpsHandle(w http.ResponseWriter, r *http.Reques
 	// 	strategy.Hijack(r, connFromClinet, ctxt)
 	// 统一 MITM 分支：首字节嗅探自动区分 HTTP/HTTPS
 	case ConnectHTTPMitm, ConnectMitm:
-		_, _ = connFromClinet.Write([]byte("HTTP/1.0 200 OK\r\n\r\n"))
+		_ = reply.established(connFromClinet, true)
 		topctx.Log_P("MITM 模式启动, 协议自动嗅探")
 
 		defer proxy.MarkConnectionClosed(tunnelSession)
@@ -542,7 +683,7 @@ func (proxy *CoreHttpServer) MyHttpsHandle(w http.ResponseWriter, r *http.Reques
 			}
 
 			requestOk := func(req *http.Request) bool {
-				requestContext, finishRequest := context.WithCancel(req.Context())
+				requestContext, finishRequest := context.WithCancel(withListenerAddr(req.Context(), r))
 				req = req.WithContext(requestContext)
 				defer finishRequest()
 
@@ -588,6 +729,7 @@ func (proxy *CoreHttpServer) MyHttpsHandle(w http.ResponseWriter, r *http.Reques
 					}()
 					if err != nil {
 						ctxt.SetCaptureError(err)
+						proxy.MarkConnectionError(ctxt.Session, err)
 						ctxt.WarnP("Cannot read response from mitm'd server %v", err)
 						return false
 					}
@@ -695,11 +837,7 @@ func (proxy *CoreHttpServer) MyHttpsHandle(w http.ResponseWriter, r *http.Reques
 		}
 		topctx.Log_P("Connect Tunnel Normal Exiting on Client EOF")
 	case ConnectReject:
-		if topctx.Resp != nil {
-			if err := topctx.Resp.Write(connFromClinet); err != nil {
-				topctx.WarnP("Cannot write response that reject http CONNECT: %v", err)
-			}
-		}
+		reply.refused(connFromClinet, topctx)
 		_ = connFromClinet.Close()
 		proxy.MarkConnectionClosed(tunnelSession)
 	}
@@ -0,0 +1,102 @@
+package mproxy
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ServeMixed 在同一端口上按首字节分发：0x05 为 SOCKS5，0x04 为 SOCKS4/4a，其余交给 ServeHTTP 作为 HTTP 代理。
+// 阻塞直到 ln 关闭
+func (proxy *CoreHttpServer) ServeMixed(ln net.Listener) error {
+	httpLn := newConnListener(ln.Addr())
+	srv := &http.Server{Handler: proxy}
+	go srv.Serve(httpLn)
+	defer srv.Close()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go proxy.dispatchMixed(conn, httpLn)
+	}
+}
+
+// dispatchMixed 嗅探首字节后把连接（连同已读字节）交给对应的入站
+func (proxy *CoreHttpServer) dispatchMixed(conn net.Conn, httpLn *connListener) {
+	_ = conn.SetReadDeadline(time.Now().Add(socks5HandshakeTimeout))
+	br := bufio.NewReader(conn)
+	peek, err := br.Peek(1)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	var sniffed net.Conn = &readBufferedConn{Conn: conn, r: br}
+	// 保留 TCP 半关闭能力，隧道仍走 copyAndClose
+	if half, ok := conn.(halfClosable); ok {
+		sniffed = &readBufferedHalfConn{readBufferedConn: sniffed.(*readBufferedConn), half: half}
+	}
+	switch peek[0] {
+	case socks5Version:
+		proxy.serveSocks5Conn(sniffed)
+	case socks4Version:
+		proxy.serveSocks4Conn(sniffed)
+	default:
+		httpLn.push(sniffed)
+	}
+}
+
+// readBufferedHalfConn 支持半关闭的 readBufferedConn
+type readBufferedHalfConn struct {
+	*readBufferedConn
+	half halfClosable
+}
+
+func (c *readBufferedHalfConn) CloseWrite() error { return c.half.CloseWrite() }
+func (c *readBufferedHalfConn) CloseRead() error  { return c.half.CloseRead() }
+
+// connListener 把已接受的连接交给 http.Server，使混合端口上的 HTTP 流量走同一个 ServeHTTP
+type connListener struct {
+	addr  net.Addr
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+func newConnListener(addr net.Addr) *connListener {
+	return &connListener{addr: addr, conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+// push 投递连接，监听器已关闭时直接关闭连接
+func (l *connListener) push(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		_ = conn.Close()
+	}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr { return l.addr }
@@ -0,0 +1,94 @@
+package mproxy
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// socks4Dial 发送 SOCKS4 CONNECT，host 不是 IP 时使用 4a 扩展，返回连接和应答码
+func socks4Dial(t *testing.T, proxyAddr, target string) (net.Conn, byte) {
+	conn, err := net.Dial("tcp", proxyAddr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	host, portStr, _ := net.SplitHostPort(target)
+	port, _ := strconv.Atoi(portStr)
+
+	req := binary.BigEndian.AppendUint16([]byte{socks4Version, socks4CmdConnect}, uint16(port))
+	if ip := net.ParseIP(host).To4(); ip != nil {
+		req = append(append(req, ip...), "user\x00"...)
+	} else {
+		req = append(append(req, 0, 0, 0, 1), "user\x00"+host+"\x00"...)
+	}
+	_, err = conn.Write(req)
+	require.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	reply := make([]byte, 8)
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+	conn.SetReadDeadline(time.Time{})
+	return conn, reply[1]
+}
+
+func TestMixedInbound_Dispatch(t *testing.T) {
+	echo := startEchoServer(t)
+	web := startHTTPServer(t)
+	addr, _ := startInbound(t, rejectTestConfig(), (*CoreHttpServer).ServeMixed)
+
+	// HTTP 代理
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(&url.URL{Scheme: "http", Host: addr})}}
+	resp, err := client.Get("http://" + web + "/")
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "ok", string(body))
+
+	// HTTP CONNECT
+	conn, r := rawConnect(t, addr, echo)
+	resp, err = http.ReadResponse(r, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assertEcho(t, conn)
+
+	// SOCKS5
+	conn, err = socksDial(t, "socks5h://"+addr, echo)
+	require.NoError(t, err)
+	assertEcho(t, conn)
+	conn.Close()
+
+	// SOCKS4 与 SOCKS4a
+	_, port, _ := net.SplitHostPort(echo)
+	for _, target := range []string{echo, net.JoinHostPort("localhost", port)} {
+		conn, rep := socks4Dial(t, addr, target)
+		require.EqualValues(t, socks4ReplyGranted, rep, target)
+		assertEcho(t, conn)
+	}
+
+	// 规则对所有协议一致生效
+	_, rep := socks4Dial(t, addr, "tracker.ads.test:443")
+	assert.EqualValues(t, socks4ReplyRejected, rep)
+	_, err = socksDial(t, "socks5h://"+addr, "tracker.ads.test:443")
+	assert.ErrorContains(t, err, "connection not allowed by ruleset")
+}
+
+func TestMixedInbound_SocksAuth(t *testing.T) {
+	echo := startEchoServer(t)
+	addr, _ := startInbound(t, &ServerConfig{SocksUsername: "alice", SocksPassword: "s3cret"}, (*CoreHttpServer).ServeMixed)
+
+	// SOCKS4 无法认证，启用认证后拒绝
+	_, rep := socks4Dial(t, addr, echo)
+	assert.EqualValues(t, socks4ReplyRejected, rep)
+
+	conn, err := socksDial(t, "socks5h://alice:s3cret@"+addr, echo)
+	require.NoError(t, err)
+	assertEcho(t, conn)
+	conn.Close()
+}
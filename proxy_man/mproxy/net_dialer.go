@@ -0,0 +1,282 @@
+package mproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	defaultConnectTimeout      = 10 * time.Second
+	defaultMaxIdleConns        = 300
+	defaultMaxIdleConnsPerHost = 10
+	defaultIdleConnTimeout     = 90 * time.Second
+
+	// happyEyeballsDelay RFC 8305 建议的 Connection Attempt Delay：
+	// 上一个地址在该时间内未连上时并行尝试下一个地址
+	happyEyeballsDelay = 250 * time.Millisecond
+)
+
+// IPPreference 可选值
+const (
+	IPPreferDual = "dual"
+	IPPreferIPv4 = "prefer-ipv4"
+	IPPreferIPv6 = "prefer-ipv6"
+	IPv4Only     = "ipv4-only"
+	IPv6Only     = "ipv6-only"
+)
+
+// merge 用 override 中的非零字段覆盖 o，override 为 nil 时原样返回
+func (o DialOptions) merge(override *DialOptions) DialOptions {
+	if override == nil {
+		return o
+	}
+	if override.BindAddress != "" {
+		o.BindAddress = override.BindAddress
+	}
+	if override.ConnectTimeout != 0 {
+		o.ConnectTimeout = override.ConnectTimeout
+	}
+	if override.KeepAlive != 0 {
+		o.KeepAlive = override.KeepAlive
+	}
+	if override.IPPreference != "" {
+		o.IPPreference = override.IPPreference
+	}
+	if override.MaxIdleConns != 0 {
+		o.MaxIdleConns = override.MaxIdleConns
+	}
+	if override.MaxIdleConnsPerHost != 0 {
+		o.MaxIdleConnsPerHost = override.MaxIdleConnsPerHost
+	}
+	if override.IdleConnTimeout != 0 {
+		o.IdleConnTimeout = override.IdleConnTimeout
+	}
+	return o
+}
+
+// applyTransport 将连接池参数写入 Transport
+func (o DialOptions) applyTransport(tr *http.Transport) {
+	tr.MaxIdleConns = defaultMaxIdleConns
+	if o.MaxIdleConns > 0 {
+		tr.MaxIdleConns = o.MaxIdleConns
+	}
+	tr.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	if o.MaxIdleConnsPerHost > 0 {
+		tr.MaxIdleConnsPerHost = o.MaxIdleConnsPerHost
+	}
+	tr.IdleConnTimeout = defaultIdleConnTimeout
+	if o.IdleConnTimeout > 0 {
+		tr.IdleConnTimeout = time.Duration(o.IdleConnTimeout) * time.Second
+	}
+}
+
+// netDialer 按 DialOptions 建立 TCP 连接：域名经由 Resolver 解析，
+// 多个地址按 RFC 8305 交替地址族竞速，可绑定本地地址或网卡
+type netDialer struct {
+	resolver  *Resolver
+	timeout   time.Duration
+	keepAlive time.Duration
+	pref      string
+	bindIP    netip.Addr // BindAddress 为 IP 时有效
+	bindIface string     // BindAddress 为网卡名时有效
+}
+
+// newNetDialer 校验并编译拨号参数，resolver 为 nil 时使用系统解析器
+func newNetDialer(resolver *Resolver, o DialOptions) (*netDialer, error) {
+	d := &netDialer{resolver: resolver, timeout: defaultConnectTimeout, pref: IPPreferDual}
+	if o.ConnectTimeout > 0 {
+		d.timeout = time.Duration(o.ConnectTimeout) * time.Millisecond
+	}
+	switch {
+	case o.KeepAlive > 0:
+		d.keepAlive = time.Duration(o.KeepAlive) * time.Second
+	case o.KeepAlive < 0:
+		d.keepAlive = -1
+	}
+	switch o.IPPreference {
+	case "":
+	case IPPreferDual, IPPreferIPv4, IPPreferIPv6, IPv4Only, IPv6Only:
+		d.pref = o.IPPreference
+	default:
+		return nil, fmt.Errorf("无效的 IPPreference: %s", o.IPPreference)
+	}
+	if o.BindAddress != "" {
+		if ip, err := netip.ParseAddr(o.BindAddress); err == nil {
+			d.bindIP = ip.Unmap()
+		} else if _, err := net.InterfaceByName(o.BindAddress); err == nil {
+			d.bindIface = o.BindAddress
+		} else {
+			return nil, fmt.Errorf("BindAddress %s 既不是 IP 也不是本机网卡", o.BindAddress)
+		}
+	}
+	return d, nil
+}
+
+// DialContext 签名兼容 http.Transport.DialContext 与 Pcontext.Dialer。目标是 Fake-IP 假地址时先还原为域名
+func (d *netDialer) DialContext(c context.Context, network, addr string) (net.Conn, error) {
+	addr, _ = d.resolver.RestoreFakeIP(addr)
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	c, cancel := context.WithTimeout(c, d.timeout)
+	defer cancel()
+
+	ips, err := d.resolver.LookupNetIP(c, host)
+	if err != nil {
+		return nil, err
+	}
+	locals, err := d.localAddrs()
+	if err != nil {
+		return nil, err
+	}
+	ips = d.sortAddrs(network, ips, locals)
+	if len(ips) == 0 {
+		return nil, &net.DNSError{Err: "no suitable address", Name: host}
+	}
+	return d.race(c, network, ips, port, locals)
+}
+
+// localAddrs 返回可用的本地源地址，未绑定时为 nil
+func (d *netDialer) localAddrs() ([]netip.Addr, error) {
+	if d.bindIP.IsValid() {
+		return []netip.Addr{d.bindIP}, nil
+	}
+	if d.bindIface == "" {
+		return nil, nil
+	}
+	// 每次拨号重新读取网卡地址，适应 DHCP 等导致的地址变化
+	iface, err := net.InterfaceByName(d.bindIface)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var locals []netip.Addr
+	for _, a := range addrs {
+		if prefix, err := netip.ParsePrefix(a.String()); err == nil && !prefix.Addr().IsLinkLocalUnicast() {
+			locals = append(locals, prefix.Addr().Unmap())
+		}
+	}
+	if len(locals) == 0 {
+		return nil, fmt.Errorf("网卡 %s 没有可用地址", d.bindIface)
+	}
+	return locals, nil
+}
+
+// sortAddrs 过滤掉不可用的地址族，并按 RFC 8305 §4 交替排列，偏好的地址族在前
+func (d *netDialer) sortAddrs(network string, ips []netip.Addr, locals []netip.Addr) []netip.Addr {
+	allow4 := !strings.HasSuffix(network, "6") && d.pref != IPv6Only
+	allow6 := !strings.HasSuffix(network, "4") && d.pref != IPv4Only
+	if locals != nil {
+		allow4 = allow4 && slices.ContainsFunc(locals, netip.Addr.Is4)
+		allow6 = allow6 && slices.ContainsFunc(locals, netip.Addr.Is6)
+	}
+	var v4, v6 []netip.Addr
+	for _, ip := range ips {
+		switch {
+		case ip.Is4() && allow4:
+			v4 = append(v4, ip)
+		case ip.Is6() && allow6:
+			v6 = append(v6, ip)
+		}
+	}
+	first, second := v6, v4
+	if d.pref == IPPreferIPv4 {
+		first, second = v4, v6
+	}
+	out := make([]netip.Addr, 0, len(v4)+len(v6))
+	for i := 0; i < max(len(first), len(second)); i++ {
+		if i < len(first) {
+			out = append(out, first[i])
+		}
+		if i < len(second) {
+			out = append(out, second[i])
+		}
+	}
+	return out
+}
+
+// race 依次发起连接，上一个尝试失败或 happyEyeballsDelay 内未完成时启动下一个，返回最先成功的连接
+func (d *netDialer) race(c context.Context, network string, ips []netip.Addr, port string, locals []netip.Addr) (net.Conn, error) {
+	c, cancel := context.WithCancel(c)
+	defer cancel()
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, len(ips))
+	next, pending := 0, 0
+	start := func() {
+		ip := ips[next]
+		next++
+		pending++
+		go func() {
+			conn, err := d.dialOne(c, network, ip, port, locals)
+			results <- result{conn, err}
+		}()
+	}
+
+	start()
+	timer := time.NewTimer(happyEyeballsDelay)
+	defer timer.Stop()
+	var errs []error
+	for {
+		select {
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				// 落败的尝试在 cancel 后陆续返回，已建立的连接需要关闭
+				go func(pending int) {
+					for ; pending > 0; pending-- {
+						if r := <-results; r.conn != nil {
+							r.conn.Close()
+						}
+					}
+				}(pending)
+				return res.conn, nil
+			}
+			errs = append(errs, res.err)
+			if next < len(ips) {
+				start()
+				timer.Reset(happyEyeballsDelay)
+			} else if pending == 0 {
+				if len(errs) == 1 {
+					return nil, errs[0]
+				}
+				return nil, errors.Join(errs...)
+			}
+		case <-timer.C:
+			if next < len(ips) {
+				start()
+				timer.Reset(happyEyeballsDelay)
+			}
+		}
+	}
+}
+
+// dialOne 连接单个地址，绑定了本地地址时选择与目标同一地址族的源地址
+func (d *netDialer) dialOne(c context.Context, network string, ip netip.Addr, port string, locals []netip.Addr) (net.Conn, error) {
+	dialer := net.Dialer{KeepAlive: d.keepAlive}
+	for _, local := range locals {
+		if local.Is4() == ip.Is4() {
+			if strings.HasPrefix(network, "udp") {
+				dialer.LocalAddr = &net.UDPAddr{IP: local.AsSlice()}
+			} else {
+				dialer.LocalAddr = &net.TCPAddr{IP: local.AsSlice()}
+			}
+			break
+		}
+	}
+	return dialer.DialContext(c, network, net.JoinHostPort(ip.String(), port))
+}
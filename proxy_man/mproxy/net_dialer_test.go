@@ -0,0 +1,138 @@
+package mproxy
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parseAddrs(ips ...string) []netip.Addr {
+	out := make([]netip.Addr, 0, len(ips))
+	for _, ip := range ips {
+		out = append(out, netip.MustParseAddr(ip))
+	}
+	return out
+}
+
+func TestNetDialer_SortAddrs(t *testing.T) {
+	ips := parseAddrs("10.0.0.1", "10.0.0.2", "10.0.0.3", "2001:db8::1", "2001:db8::2")
+	for pref, want := range map[string][]string{
+		"":           {"2001:db8::1", "10.0.0.1", "2001:db8::2", "10.0.0.2", "10.0.0.3"},
+		IPPreferIPv4: {"10.0.0.1", "2001:db8::1", "10.0.0.2", "2001:db8::2", "10.0.0.3"},
+		IPv4Only:     {"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+		IPv6Only:     {"2001:db8::1", "2001:db8::2"},
+	} {
+		d, err := newNetDialer(nil, DialOptions{IPPreference: pref})
+		require.NoError(t, err)
+		assert.Equal(t, parseAddrs(want...), d.sortAddrs("tcp", ips, nil), pref)
+	}
+
+	d, err := newNetDialer(nil, DialOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, parseAddrs("10.0.0.1", "10.0.0.2", "10.0.0.3"), d.sortAddrs("tcp4", ips, nil))
+	assert.Equal(t, parseAddrs("10.0.0.1", "10.0.0.2", "10.0.0.3"), d.sortAddrs("tcp", ips, parseAddrs("192.168.1.2")), "只绑定了 IPv4 源地址")
+
+	_, err = newNetDialer(nil, DialOptions{IPPreference: "ipv5"})
+	assert.Error(t, err)
+	_, err = newNetDialer(nil, DialOptions{BindAddress: "no-such-iface0"})
+	assert.Error(t, err)
+}
+
+func TestNetDialer_FallbackAndBind(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	remotes := make(chan string, 4)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			remotes <- c.RemoteAddr().(*net.TCPAddr).IP.String()
+			c.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	proxy := NewCoreHttpSever()
+	proxy.Resolver.Reload(&DNSConfig{Hosts: map[string]string{"dual.test": "::1, 127.0.0.1"}})
+
+	// IPv6 地址优先但无人监听，回退到 IPv4
+	d, err := newNetDialer(proxy.Resolver, DialOptions{})
+	require.NoError(t, err)
+	conn, err := d.DialContext(t.Context(), "tcp", net.JoinHostPort("dual.test", port))
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, "127.0.0.1", <-remotes)
+
+	d, err = newNetDialer(proxy.Resolver, DialOptions{IPPreference: IPv6Only})
+	require.NoError(t, err)
+	_, err = d.DialContext(t.Context(), "tcp", net.JoinHostPort("dual.test", port))
+	assert.Error(t, err)
+
+	// 绑定源地址，同时排除了与之不同地址族的 ::1
+	d, err = newNetDialer(proxy.Resolver, DialOptions{BindAddress: "127.0.0.2"})
+	require.NoError(t, err)
+	conn, err = d.DialContext(t.Context(), "tcp", net.JoinHostPort("dual.test", port))
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, "127.0.0.2", <-remotes)
+}
+
+func TestResolver_ReloadDial(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	remotes := make(chan string, 2)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			remotes <- c.RemoteAddr().(*net.TCPAddr).IP.String()
+			c.Close()
+		}
+	}()
+
+	// 未开启路由时的直连同样使用全局拨号参数
+	proxy := NewCoreHttpSever()
+	require.NoError(t, proxy.Resolver.ReloadDial(DialOptions{BindAddress: "127.0.0.2"}))
+	conn, err := proxy.dial(&Pcontext{Req: &http.Request{}}, "tcp", ln.Addr().String())
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, "127.0.0.2", <-remotes)
+
+	// 无效参数被拒绝，保留原参数
+	assert.Error(t, proxy.Resolver.ReloadDial(DialOptions{IPPreference: "ipv5"}))
+	conn, err = proxy.Transport.DialContext(t.Context(), "tcp", ln.Addr().String())
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, "127.0.0.2", <-remotes)
+}
+
+func TestRouter_DialOptions(t *testing.T) {
+	router := NewRouter(NewCoreHttpSever())
+	require.NoError(t, router.ReloadFromConfig(&ServerConfig{
+		Dial: DialOptions{MaxIdleConnsPerHost: 4, IdleConnTimeout: 30},
+		ProxyNodes: []ProxyNode{
+			{Name: "a", URL: "http://127.0.0.1:1"},
+			{Name: "b", URL: "socks5://127.0.0.1:1", Dial: &DialOptions{MaxIdleConnsPerHost: 2}},
+			{Name: "bad", URL: "http://127.0.0.1:1", Dial: &DialOptions{IPPreference: "ipv5"}},
+		},
+		ProxyGroups: []ProxyGroup{{Name: "g", Type: "fallback", Proxies: []string{"a", "b"}}},
+	}))
+
+	assert.Equal(t, 4, router.Default.GetTransport().MaxIdleConnsPerHost)
+	assert.Equal(t, 4, router.Dialers["a"].GetTransport().MaxIdleConnsPerHost)
+	assert.Equal(t, 4, router.Dialers["g"].GetTransport().MaxIdleConnsPerHost)
+	assert.Equal(t, 2, router.Dialers["b"].GetTransport().MaxIdleConnsPerHost, "节点覆盖全局参数")
+	assert.Equal(t, 300, router.Dialers["b"].GetTransport().MaxIdleConns)
+	assert.Equal(t, "30s", router.Dialers["b"].GetTransport().IdleConnTimeout.String(), "未覆盖的字段沿用全局参数")
+	assert.NotContains(t, router.Dialers, "bad")
+}
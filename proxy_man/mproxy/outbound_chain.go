@@ -0,0 +1,223 @@
+package mproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// ChainDialError 链式代理建连失败，Chain 按连接顺序列出整条链路（第一项为最先连接的节点）
+type ChainDialError struct {
+	Chain []string
+	Hop   string // 失败的节点
+	Err   error
+}
+
+func (e *ChainDialError) Error() string {
+	return fmt.Sprintf("链式代理 %s 在节点 %s 失败: %v", strings.Join(e.Chain, " -> "), e.Hop, e.Err)
+}
+
+func (e *ChainDialError) Unwrap() error { return e.Err }
+
+// viaDialError 标记错误发生在前一跳，由 chainDialer 归并为 ChainDialError
+type viaDialError struct {
+	hop string
+	err error
+}
+
+func (e *viaDialError) Error() string { return e.hop + ": " + e.err.Error() }
+
+func (e *viaDialError) Unwrap() error { return e.err }
+
+// viaHop 链式代理的前一跳。ReloadFromConfig 构建完全部拨号器后才绑定，
+// 因此 Via 可以引用之后声明的节点或代理组；绑定前发起的拨号（如代理组测速）等待绑定完成
+type viaHop struct {
+	name   string
+	dialer OutboundDialer // 为 nil 表示前一跳创建失败
+	ready  chan struct{}
+}
+
+func (v *viaHop) bind(dialer OutboundDialer) {
+	v.dialer = dialer
+	close(v.ready)
+}
+
+// DialContext 经由前一跳建立到代理服务器的连接，签名兼容 Pcontext.Dialer
+func (v *viaHop) DialContext(c context.Context, network, addr string) (net.Conn, error) {
+	select {
+	case <-v.ready:
+	case <-c.Done():
+		return nil, c.Err()
+	}
+	if v.dialer == nil {
+		return nil, &viaDialError{hop: v.name, err: errors.New("节点不可用")}
+	}
+	conn, err := v.dialer.Dial(network, addr)
+	if err != nil {
+		return nil, &viaDialError{hop: v.name, err: err}
+	}
+	return conn, nil
+}
+
+// chainDialer 设置了 Via 的节点，建连失败时返回列出整条链路的 ChainDialError
+type chainDialer struct {
+	inner     OutboundDialer
+	chain     []string
+	transport *http.Transport
+}
+
+func newChainDialer(inner OutboundDialer, chain []string, opts DialOptions) *chainDialer {
+	d := &chainDialer{inner: inner, chain: chain}
+	tr := createBaseTransport(opts)
+	tr.DialContext = func(c context.Context, network, addr string) (net.Conn, error) {
+		return d.Dial(network, addr)
+	}
+	d.transport = tr
+	return d
+}
+
+func (d *chainDialer) Dial(network, addr string) (net.Conn, error) {
+	conn, err := d.inner.Dial(network, addr)
+	if err == nil {
+		return conn, nil
+	}
+	// 前一跳失败时取最深处的失败节点，否则是本节点的握手失败
+	hop := d.inner.Name()
+	var viaErr *viaDialError
+	if errors.As(err, &viaErr) {
+		hop, err = viaErr.hop, viaErr.err
+		var chainErr *ChainDialError
+		if errors.As(err, &chainErr) {
+			hop, err = chainErr.Hop, chainErr.Err
+		}
+	}
+	return nil, &ChainDialError{Chain: d.chain, Hop: hop, Err: err}
+}
+
+func (d *chainDialer) Name() string { return d.inner.Name() }
+
+// Close 释放内部拨号器持有的资源（如 SSH 连接）
+func (d *chainDialer) Close() error {
+	if c, ok := d.inner.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func (d *chainDialer) GetTransport() *http.Transport { return d.transport }
+
+// Chain 返回按连接顺序排列的链路
+func (d *chainDialer) Chain() []string { return slices.Clone(d.chain) }
+
+// chainPlan 一次重载中的 Via 关系，负责环路检测、计算链路和绑定前一跳
+type chainPlan struct {
+	vias  map[string]string   // 节点 -> Via
+	edges map[string][]string // 节点 -> Via，代理组 -> 成员
+	names map[string]bool     // 可作为 Via 的名称：节点、代理组和 Direct
+	hops  []*viaHop
+	base  DialOptions // 全局拨号参数，节点的 Dial 在此基础上覆盖
+}
+
+func newChainPlan(cfg *ServerConfig, base DialOptions) *chainPlan {
+	p := &chainPlan{
+		base:  base,
+		vias:  make(map[string]string),
+		edges: make(map[string][]string),
+		names: map[string]bool{"Direct": true},
+	}
+	for _, node := range cfg.ProxyNodes {
+		p.names[node.Name] = true
+		if node.Via != "" && node.Via != "Direct" {
+			p.vias[node.Name] = node.Via
+			p.edges[node.Name] = []string{node.Via}
+		}
+	}
+	for _, group := range cfg.ProxyGroups {
+		p.names[group.Name] = true
+		p.edges[group.Name] = append(p.edges[group.Name], group.Proxies...)
+	}
+	return p
+}
+
+// loop 沿 Via 和代理组成员查找从 start 出发可达的环，返回环上的名称（首尾相同），无环时返回 nil。
+// 代理组的任一成员都可能被选中，因此经过代理组回到自身同样视为环路
+func (p *chainPlan) loop(start string) []string {
+	var path []string
+	onPath := make(map[string]bool)
+	done := make(map[string]bool)
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		if onPath[name] {
+			i := slices.Index(path, name)
+			return append(slices.Clone(path[i:]), name)
+		}
+		if done[name] {
+			return nil
+		}
+		onPath[name] = true
+		path = append(path, name)
+		for _, next := range p.edges[name] {
+			if loop := visit(next); loop != nil {
+				return loop
+			}
+		}
+		path = path[:len(path)-1]
+		onPath[name] = false
+		done[name] = true
+		return nil
+	}
+	return visit(start)
+}
+
+// chain 沿 Via 计算节点的链路（按连接顺序），遇到代理组或未设置 Via 的节点为止。调用前需确认无环
+func (p *chainPlan) chain(name string) []string {
+	chain := []string{name}
+	for via := p.vias[name]; via != ""; via = p.vias[via] {
+		chain = append(chain, via)
+	}
+	slices.Reverse(chain)
+	return chain
+}
+
+// newDialer 创建节点拨号器，设置了 Via 时包装为 chainDialer，前一跳留待 bind 绑定；
+// 否则按节点的拨号参数直接连接代理服务器
+func (p *chainPlan) newDialer(proxy *CoreHttpServer, node ProxyNode) (OutboundDialer, error) {
+	opts := p.base.merge(node.Dial)
+	via, ok := p.vias[node.Name]
+	if !ok {
+		nd, err := newNetDialer(proxy.Resolver, opts)
+		if err != nil {
+			return nil, err
+		}
+		return newOutboundDialer(proxy, node, nd.DialContext, opts)
+	}
+	if !p.names[via] {
+		return nil, fmt.Errorf("Via '%s' 不存在", via)
+	}
+	if loop := p.loop(node.Name); loop != nil {
+		return nil, fmt.Errorf("链式代理存在环路: %s", strings.Join(loop, " -> "))
+	}
+	hop := &viaHop{name: via, ready: make(chan struct{})}
+	dialer, err := newOutboundDialer(proxy, node, hop.DialContext, opts)
+	if err != nil {
+		return nil, err
+	}
+	p.hops = append(p.hops, hop)
+	return newChainDialer(dialer, p.chain(node.Name), opts), nil
+}
+
+// bind 在全部拨号器构建完成后绑定前一跳，前一跳创建失败时记录警告，经由它的拨号将返回错误
+func (p *chainPlan) bind(proxy *CoreHttpServer, dialers map[string]OutboundDialer) {
+	for _, hop := range p.hops {
+		dialer, ok := dialers[hop.name]
+		if !ok {
+			proxy.Logger.Printf("WARN: 链式代理的前一跳 %s 不可用", hop.name)
+		}
+		hop.bind(dialer)
+	}
+}
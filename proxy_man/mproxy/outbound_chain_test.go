@@ -0,0 +1,118 @@
+package mproxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startRecordingConnectProxy 启动 CONNECT 代理，收到的目标地址写入 targets
+func startRecordingConnectProxy(t *testing.T, targets chan<- string) string {
+	srv := httptest.NewServer(connectProxyHandler(t, func(r *http.Request) int {
+		targets <- r.Host
+		return 0
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestChainDialer_MultiHop(t *testing.T) {
+	entryTargets := make(chan string, 4)
+	jumpTargets := make(chan string, 4)
+	exitTargets := make(chan string, 4)
+	entry := startRecordingConnectProxy(t, entryTargets)
+	jump := startRecordingConnectProxy(t, jumpTargets)
+	exit := startFakeSocks5(t, "", "", exitTargets)
+
+	router := NewRouter(NewCoreHttpSever())
+	require.NoError(t, router.ReloadFromConfig(&ServerConfig{
+		ProxyNodes: []ProxyNode{
+			// 出口节点先于前一跳声明，Via 在全部节点构建后绑定
+			{Name: "exit", URL: "socks5h://" + exit, Via: "jump"},
+			{Name: "jump", URL: jump, Via: "entry"},
+			{Name: "entry", URL: entry},
+		},
+	}))
+
+	d := router.Dialers["exit"]
+	require.NotNil(t, d)
+	assert.Equal(t, []string{"entry", "jump", "exit"}, d.(*chainDialer).Chain())
+
+	conn, err := d.Dial("tcp", "example.com:443")
+	require.NoError(t, err)
+	defer conn.Close()
+	assertEcho(t, conn)
+
+	// 入口代理连接跳板，跳板连接出口，出口收到真实目标
+	jumpHost, exitHost := jump[len("http://"):], exit
+	assert.Equal(t, jumpHost, <-entryTargets)
+	assert.Equal(t, exitHost, <-jumpTargets)
+	assert.Equal(t, "example.com:443", <-exitTargets)
+}
+
+func TestChainDialer_HopFailure(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	dead := ln.Addr().String()
+	ln.Close()
+
+	rejecting := httptest.NewServer(connectProxyHandler(t, func(r *http.Request) int { return http.StatusForbidden }))
+	defer rejecting.Close()
+
+	router := NewRouter(NewCoreHttpSever())
+	require.NoError(t, router.ReloadFromConfig(&ServerConfig{
+		ProxyNodes: []ProxyNode{
+			{Name: "dead", URL: "http://" + dead},
+			{Name: "jump", URL: "http://127.0.0.1:1", Via: "dead"},
+			{Name: "exit", URL: "socks5h://127.0.0.1:1", Via: "jump"},
+			{Name: "strict", URL: rejecting.URL},
+			{Name: "behind", URL: "socks5h://127.0.0.1:1", Via: "strict"},
+		},
+	}))
+
+	// 第一跳连不上：链路完整列出，失败节点为 dead
+	_, err = router.Dialers["exit"].Dial("tcp", "example.com:443")
+	var chainErr *ChainDialError
+	require.ErrorAs(t, err, &chainErr)
+	assert.Equal(t, []string{"dead", "jump", "exit"}, chainErr.Chain)
+	assert.Equal(t, "dead", chainErr.Hop)
+	assert.ErrorContains(t, err, "dead -> jump -> exit")
+
+	// 前一跳拒绝 CONNECT
+	_, err = router.Dialers["behind"].Dial("tcp", "example.com:443")
+	require.ErrorAs(t, err, &chainErr)
+	assert.Equal(t, []string{"strict", "behind"}, chainErr.Chain)
+	assert.Equal(t, "strict", chainErr.Hop)
+}
+
+func TestChainDialer_LoopDetection(t *testing.T) {
+	router := NewRouter(NewCoreHttpSever())
+	require.NoError(t, router.ReloadFromConfig(&ServerConfig{
+		ProxyNodes: []ProxyNode{
+			{Name: "a", URL: "http://127.0.0.1:1", Via: "b"},
+			{Name: "b", URL: "http://127.0.0.1:2", Via: "a"},
+			{Name: "c", URL: "http://127.0.0.1:3", Via: "group"},
+			{Name: "d", URL: "http://127.0.0.1:4"},
+			{Name: "e", URL: "http://127.0.0.1:5", Via: "missing"},
+			{Name: "f", URL: "http://127.0.0.1:6", Via: "d"},
+		},
+		ProxyGroups: []ProxyGroup{
+			{Name: "group", Type: "fallback", Proxies: []string{"d", "c"}},
+		},
+	}))
+
+	for _, name := range []string{"a", "b", "c", "e"} {
+		assert.NotContains(t, router.Dialers, name, name)
+	}
+	assert.Contains(t, router.Dialers, "f")
+	assert.Contains(t, router.Dialers, "group", "组内的环路成员被跳过，其余成员保留")
+
+	plan := newChainPlan(&ServerConfig{ProxyNodes: []ProxyNode{
+		{Name: "x", Via: "y"}, {Name: "y", Via: "z"}, {Name: "z", Via: "y"},
+	}}, DialOptions{})
+	assert.Equal(t, []string{"y", "z", "y"}, plan.loop("x"))
+}
@@ -0,0 +1,373 @@
+package mproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 代理组类型
+const (
+	GroupFallback    = "fallback"     // 按顺序选择第一个可用成员
+	GroupURLTest     = "url-test"     // 选择测速延迟最低的成员
+	GroupLoadBalance = "load-balance" // 在可用成员间负载均衡
+)
+
+// load-balance 策略
+const (
+	StrategyRoundRobin        = "round-robin"
+	StrategyConsistentHashing = "consistent-hashing" // 同一目标主机始终落在同一成员上（粘性会话）
+)
+
+const (
+	defaultGroupTestURL  = "http://www.gstatic.com/generate_204"
+	defaultGroupInterval = 300 * time.Second
+	groupTestTimeout     = 5 * time.Second
+)
+
+// URLTest 通过指定拨号器请求 testURL，返回从拨号到收到响应头的耗时
+// 每次测速都使用新连接，避免连接池复用导致延迟失真
+func URLTest(dialer OutboundDialer, testURL string, timeout time.Duration) (time.Duration, error) {
+	tr := &http.Transport{
+		DialContext: func(c context.Context, network, addr string) (net.Conn, error) {
+			return dialer.Dial(network, addr)
+		},
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		DisableKeepAlives: true,
+	}
+	defer tr.CloseIdleConnections()
+	client := &http.Client{
+		Transport: tr,
+		Timeout:   timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	start := time.Now()
+	resp, err := client.Get(testURL)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return time.Since(start), nil
+}
+
+// groupMember 代理组成员及其运行时状态
+type groupMember struct {
+	dialer  OutboundDialer
+	alive   atomic.Bool
+	latency atomic.Int64 // 最近一次测速延迟（毫秒），0 表示尚未测速
+}
+
+// ProxyGroupDialer 代理组拨号器，包装多个成员拨号器并按组类型选择出站
+type ProxyGroupDialer struct {
+	proxy     *CoreHttpServer
+	health    *HealthChecker // 可为 nil；启用健康检查时优先采用其结果
+	name      string
+	groupType string
+	strategy  string
+	members   []*groupMember
+	testURL   string
+	interval  time.Duration
+	tolerance time.Duration
+
+	rr        atomic.Uint64 // round-robin 计数器
+	selected  atomic.Int32  // url-test 当前选中的成员下标
+	transport *http.Transport
+
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewProxyGroupDialer 创建代理组拨号器，members 需按配置顺序给出。创建后立即开始后台测速，
+// 已被 health 检查的成员直接采用健康检查结果，不再单独测速
+func NewProxyGroupDialer(proxy *CoreHttpServer, group ProxyGroup, members []OutboundDialer, health *HealthChecker) (*ProxyGroupDialer, error) {
+	if err := validateProxyGroup(group, len(members)); err != nil {
+		return nil, err
+	}
+	if group.Type == GroupLoadBalance && group.Strategy == "" {
+		group.Strategy = StrategyRoundRobin
+	}
+
+	g := &ProxyGroupDialer{
+		proxy:     proxy,
+		health:    health,
+		name:      group.Name,
+		groupType: group.Type,
+		strategy:  group.Strategy,
+		testURL:   group.URL,
+		interval:  time.Duration(group.Interval) * time.Second,
+		tolerance: time.Duration(group.Tolerance) * time.Millisecond,
+		stop:      make(chan struct{}),
+	}
+	if g.testURL == "" {
+		g.testURL = defaultGroupTestURL
+	}
+	if g.interval <= 0 {
+		g.interval = defaultGroupInterval
+	}
+	for _, d := range members {
+		m := &groupMember{dialer: d}
+		m.alive.Store(true) // 首次测速前默认所有成员可用
+		g.members = append(g.members, m)
+	}
+
+	tr := createBaseTransport(DialOptions{})
+	tr.DialContext = func(c context.Context, network, addr string) (net.Conn, error) {
+		return g.Dial(network, addr)
+	}
+	g.transport = tr
+
+	go g.testLoop()
+	return g, nil
+}
+
+// validateProxyGroup 检查组类型、策略和成员数量，不创建任何后台任务
+func validateProxyGroup(group ProxyGroup, members int) error {
+	switch group.Type {
+	case GroupFallback, GroupURLTest:
+	case GroupLoadBalance:
+		switch group.Strategy {
+		case "", StrategyRoundRobin, StrategyConsistentHashing:
+		default:
+			return fmt.Errorf("代理组 %s 未知的负载均衡策略 %s", group.Name, group.Strategy)
+		}
+	default:
+		return fmt.Errorf("代理组 %s 未知的类型 %s", group.Name, group.Type)
+	}
+	if members == 0 {
+		return fmt.Errorf("代理组 %s 没有可用成员", group.Name)
+	}
+	return nil
+}
+
+func (g *ProxyGroupDialer) Name() string { return g.name }
+
+func (g *ProxyGroupDialer) GetTransport() *http.Transport {
+	return g.transport
+}
+
+// Dial 按组类型给出的候选顺序依次尝试成员。连不上节点的成员被标记为不可用直到下一次测速恢复；
+// 节点已连通但目标不可达时只尝试下一个成员，不影响该成员的状态
+func (g *ProxyGroupDialer) Dial(network, addr string) (net.Conn, error) {
+	var errs []error
+	for _, m := range g.candidates(addr) {
+		conn, err := m.dialer.Dial(network, addr)
+		if err == nil {
+			return conn, nil
+		}
+		if !isTargetError(err) {
+			g.markDead(m, err)
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", m.dialer.Name(), err))
+	}
+	return nil, fmt.Errorf("代理组 %s 所有成员拨号失败: %w", g.name, errors.Join(errs...))
+}
+
+// DialUDP 按同样的候选顺序选择第一个支持 UDP 的成员。UDP 拨号不经过握手，失败不代表节点不可用，不标记成员状态
+func (g *ProxyGroupDialer) DialUDP(network, addr string) (net.Conn, error) {
+	var errs []error
+	for _, m := range g.candidates(addr) {
+		ud, ok := m.dialer.(UDPDialer)
+		if !ok {
+			continue
+		}
+		conn, err := ud.DialUDP(network, addr)
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", m.dialer.Name(), err))
+	}
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("代理组 %s 没有支持 UDP 的成员", g.name)
+	}
+	return nil, fmt.Errorf("代理组 %s 所有成员 UDP 拨号失败: %w", g.name, errors.Join(errs...))
+}
+
+// Close 停止后台测速并释放连接池，热重载替换后由 Router 调用
+func (g *ProxyGroupDialer) Close() error {
+	g.closeOnce.Do(func() {
+		close(g.stop)
+		g.transport.CloseIdleConnections()
+	})
+	return nil
+}
+
+// Now 返回当前实际使用的成员名称（fallback/url-test），load-balance 返回组名
+func (g *ProxyGroupDialer) Now() string {
+	if g.groupType == GroupLoadBalance {
+		return g.name
+	}
+	if c := g.candidates(""); len(c) > 0 {
+		return c[0].dialer.Name()
+	}
+	return ""
+}
+
+// candidates 返回本次拨号的成员尝试顺序。可用成员优先，全部不可用时按配置顺序兜底
+func (g *ProxyGroupDialer) candidates(addr string) []*groupMember {
+	alive := make([]*groupMember, 0, len(g.members))
+	for _, m := range g.members {
+		if g.isAlive(m) {
+			alive = append(alive, m)
+		}
+	}
+	if len(alive) == 0 {
+		return g.members
+	}
+
+	switch g.groupType {
+	case GroupURLTest:
+		// 当前选中的成员排第一，其余按延迟升序作为失败后的备选
+		sel := g.members[g.selected.Load()]
+		sort.SliceStable(alive, func(i, j int) bool {
+			if alive[i] == sel || alive[j] == sel {
+				return alive[i] == sel
+			}
+			return g.latencyKey(alive[i]) < g.latencyKey(alive[j])
+		})
+	case GroupLoadBalance:
+		if g.strategy == StrategyConsistentHashing {
+			host := addr
+			if h, _, err := net.SplitHostPort(addr); err == nil {
+				host = h
+			}
+			// 最高随机权重（rendezvous）哈希：成员增减时只有落在该成员上的主机会迁移
+			sort.SliceStable(alive, func(i, j int) bool {
+				return rendezvousScore(host, alive[i]) > rendezvousScore(host, alive[j])
+			})
+		} else {
+			start := int((g.rr.Add(1) - 1) % uint64(len(alive)))
+			rotated := make([]*groupMember, 0, len(alive))
+			alive = append(append(rotated, alive[start:]...), alive[:start]...)
+		}
+	}
+	return alive
+}
+
+// isTargetError 判断拨号失败是否由目标引起：成员节点已连通但拒绝或无法连接目标。
+// 链式代理在前一跳失败时，前一跳连不上的是本节点的服务器，仍算作节点不可用
+func isTargetError(err error) bool {
+	var chainErr *ChainDialError
+	if errors.As(err, &chainErr) && chainErr.Hop != chainErr.Chain[len(chainErr.Chain)-1] {
+		return false
+	}
+	var targetErr *TargetDialError
+	return errors.As(err, &targetErr)
+}
+
+func (g *ProxyGroupDialer) markDead(m *groupMember, err error) {
+	if m.alive.Swap(false) {
+		g.proxy.Logger.Printf("WARN: [代理组] %s 成员 %s 不可用: %v", g.name, m.dialer.Name(), err)
+	}
+	if g.groupType == GroupURLTest {
+		g.reselect()
+	}
+}
+
+// isAlive 成员未因拨号失败被标记，且健康检查（如启用）认为可用
+func (g *ProxyGroupDialer) isAlive(m *groupMember) bool {
+	if !m.alive.Load() {
+		return false
+	}
+	return g.health == nil || g.health.IsHealthy(m.dialer.Name())
+}
+
+// latencyKey 优先使用健康检查延迟，未测速的成员排在已测速成员之后
+func (g *ProxyGroupDialer) latencyKey(m *groupMember) int64 {
+	if g.health != nil {
+		if st, ok := g.health.Get(m.dialer.Name()); ok && st.Alive {
+			return st.Latency + 1
+		}
+	}
+	if l := m.latency.Load(); l > 0 {
+		return l
+	}
+	return int64(groupTestTimeout / time.Millisecond)
+}
+
+func rendezvousScore(host string, m *groupMember) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(host))
+	h.Write([]byte{0})
+	h.Write([]byte(m.dialer.Name()))
+	return h.Sum64()
+}
+
+// reselect url-test 重新选择延迟最低的可用成员，新成员需比当前成员快出 tolerance 才切换
+func (g *ProxyGroupDialer) reselect() {
+	cur := g.members[g.selected.Load()]
+	best := -1
+	for i, m := range g.members {
+		if !g.isAlive(m) {
+			continue
+		}
+		if best == -1 || g.latencyKey(m) < g.latencyKey(g.members[best]) {
+			best = i
+		}
+	}
+	if best == -1 {
+		return
+	}
+	if g.isAlive(cur) && g.latencyKey(cur)-g.latencyKey(g.members[best]) <= int64(g.tolerance/time.Millisecond) {
+		return
+	}
+	if g.members[best] != cur {
+		g.selected.Store(int32(best))
+		g.proxy.Logger.Printf("INFO: [代理组] %s 切换到 %s (%dms)", g.name, g.members[best].dialer.Name(), g.latencyKey(g.members[best]))
+	}
+}
+
+// testLoop 周期性测速所有成员，更新可用状态与延迟
+func (g *ProxyGroupDialer) testLoop() {
+	g.testAll()
+	ticker := time.NewTicker(g.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-g.stop:
+			return
+		case <-ticker.C:
+			g.testAll()
+		}
+	}
+}
+
+func (g *ProxyGroupDialer) testAll() {
+	var wg sync.WaitGroup
+	for _, m := range g.members {
+		wg.Add(1)
+		go func(m *groupMember) {
+			defer wg.Done()
+			if g.health != nil {
+				if st, ok := g.health.Get(m.dialer.Name()); ok {
+					// 健康检查已覆盖该成员：同步其结果，并撤销拨号失败留下的临时标记
+					m.latency.Store(st.Latency + 1)
+					m.alive.Store(st.Alive)
+					return
+				}
+			}
+			delay, err := URLTest(m.dialer, g.testURL, groupTestTimeout)
+			if err != nil {
+				m.latency.Store(0)
+				g.markDead(m, err)
+				return
+			}
+			m.latency.Store(delay.Milliseconds() + 1) // +1 保证测速成功的延迟大于 0
+			m.alive.Store(true)
+		}(m)
+	}
+	wg.Wait()
+	if g.groupType == GroupURLTest {
+		g.reselect()
+	}
+}
@@ -0,0 +1,170 @@
+package mproxy
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubDialer 测试用拨号器：可用时把所有连接都拨到 server，并记录被拨号的次数
+type stubDialer struct {
+	name   string
+	server string
+	down   atomic.Bool
+	dials  atomic.Int32
+}
+
+func (d *stubDialer) Dial(network, addr string) (net.Conn, error) {
+	d.dials.Add(1)
+	if d.down.Load() {
+		return nil, errors.New(d.name + " is down")
+	}
+	return net.Dial("tcp", d.server)
+}
+
+func (d *stubDialer) Name() string                  { return d.name }
+func (d *stubDialer) GetTransport() *http.Transport { return nil }
+
+func newStubMembers(t *testing.T, names ...string) []*stubDialer {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(srv.Close)
+	stubs := make([]*stubDialer, len(names))
+	for i, n := range names {
+		stubs[i] = &stubDialer{name: n, server: srv.Listener.Addr().String()}
+	}
+	return stubs
+}
+
+func newTestGroup(t *testing.T, group ProxyGroup, stubs []*stubDialer) *ProxyGroupDialer {
+	members := make([]OutboundDialer, len(stubs))
+	for i, s := range stubs {
+		members[i] = s
+	}
+	group.URL = "http://probe.test/generate_204"
+	group.Interval = 3600
+	g, err := NewProxyGroupDialer(NewCoreHttpSever(), group, members, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { g.Close() })
+	// 等待首轮测速完成
+	require.Eventually(t, func() bool {
+		for _, m := range g.members {
+			if m.latency.Load() == 0 {
+				return false
+			}
+		}
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+	return g
+}
+
+func TestProxyGroup_Fallback(t *testing.T) {
+	stubs := newStubMembers(t, "a", "b", "c")
+	g := newTestGroup(t, ProxyGroup{Name: "fb", Type: GroupFallback}, stubs)
+	assert.Equal(t, "a", g.Now())
+
+	stubs[0].down.Store(true)
+	conn, err := g.Dial("tcp", "example.com:443")
+	require.NoError(t, err)
+	conn.Close()
+	// a 拨号失败后被标记不可用，后续直接使用 b
+	assert.Equal(t, "b", g.Now())
+
+	for _, s := range stubs {
+		s.down.Store(true)
+	}
+	_, err = g.Dial("tcp", "example.com:443")
+	assert.ErrorContains(t, err, "所有成员拨号失败")
+}
+
+func TestProxyGroup_TargetErrorKeepsMemberAlive(t *testing.T) {
+	probe := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer probe.Close()
+	upstream := httptest.NewServer(connectProxyHandler(t, func(r *http.Request) int {
+		switch r.Host {
+		case "bad.test:443":
+			return http.StatusBadGateway
+		case "busy.test:443":
+			return http.StatusServiceUnavailable
+		}
+		return 0
+	}))
+	defer upstream.Close()
+
+	proxy := NewCoreHttpSever()
+	a, err := NewHttpProxyDialer(proxy, ProxyNode{Name: "a", URL: upstream.URL})
+	require.NoError(t, err)
+	b := &stubDialer{name: "b", server: probe.Listener.Addr().String()}
+	g, err := NewProxyGroupDialer(proxy, ProxyGroup{Name: "fb", Type: GroupFallback, URL: probe.URL, Interval: 3600},
+		[]OutboundDialer{a, b}, nil)
+	require.NoError(t, err)
+	defer g.Close()
+	require.Eventually(t, func() bool {
+		return g.members[0].latency.Load() > 0 && g.members[1].latency.Load() > 0
+	}, 5*time.Second, 10*time.Millisecond)
+	b.dials.Store(0) // 忽略首轮测速产生的拨号
+
+	// a 连通但目标被拒绝：回退到 b 完成本次拨号，a 仍保持选中
+	conn, err := g.Dial("tcp", "bad.test:443")
+	require.NoError(t, err)
+	conn.Close()
+	assert.EqualValues(t, 1, b.dials.Load())
+	assert.True(t, g.members[0].alive.Load())
+	assert.Equal(t, "a", g.Now())
+
+	_, err = a.Dial("tcp", "bad.test:443")
+	assert.ErrorContains(t, err, "502 Bad Gateway")
+
+	// 503 多为节点自身过载，视为节点故障：标记不可用并切换到 b
+	_, err = a.Dial("tcp", "busy.test:443")
+	var targetErr *TargetDialError
+	assert.False(t, errors.As(err, &targetErr))
+	conn, err = g.Dial("tcp", "busy.test:443")
+	require.NoError(t, err)
+	conn.Close()
+	assert.False(t, g.members[0].alive.Load())
+	assert.Equal(t, "b", g.Now())
+}
+
+func TestProxyGroup_LoadBalance(t *testing.T) {
+	stubs := newStubMembers(t, "a", "b", "c")
+	rr := newTestGroup(t, ProxyGroup{Name: "rr", Type: GroupLoadBalance}, stubs)
+	for _, s := range stubs {
+		s.dials.Store(0) // 忽略首轮测速产生的拨号
+	}
+	for i := 0; i < 6; i++ {
+		conn, err := rr.Dial("tcp", "example.com:443")
+		require.NoError(t, err)
+		conn.Close()
+	}
+	for _, s := range stubs {
+		assert.EqualValues(t, 2, s.dials.Load(), s.name)
+	}
+
+	// 一致性哈希：同一主机总是落在同一成员上
+	ch := newTestGroup(t, ProxyGroup{Name: "ch", Type: GroupLoadBalance, Strategy: StrategyConsistentHashing}, stubs)
+	first := ch.candidates("example.com:443")[0]
+	for i := 0; i < 5; i++ {
+		assert.Same(t, first, ch.candidates("example.com:8443")[0])
+	}
+}
+
+func TestProxyGroup_InvalidConfig(t *testing.T) {
+	members := []OutboundDialer{NewDirectDialer(nil)}
+	_, err := NewProxyGroupDialer(NewCoreHttpSever(), ProxyGroup{Name: "x", Type: "select"}, members, nil)
+	assert.Error(t, err)
+	_, err = NewProxyGroupDialer(NewCoreHttpSever(), ProxyGroup{Name: "x", Type: GroupLoadBalance, Strategy: "random"}, members, nil)
+	assert.Error(t, err)
+	_, err = NewProxyGroupDialer(NewCoreHttpSever(), ProxyGroup{Name: "x", Type: GroupFallback}, nil, nil)
+	assert.Error(t, err)
+}
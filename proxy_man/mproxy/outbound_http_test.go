@@ -0,0 +1,158 @@
+package mproxy
+
+import (
+	"encoding/pem"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// connectProxyHandler 极简 CONNECT 代理，check 返回非 0 状态码时拒绝请求
+func connectProxyHandler(t *testing.T, check func(r *http.Request) int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "only CONNECT", http.StatusMethodNotAllowed)
+			return
+		}
+		if check != nil {
+			if code := check(r); code != 0 {
+				w.WriteHeader(code)
+				return
+			}
+		}
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		client, _, err := w.(http.Hijacker).Hijack()
+		require.NoError(t, err)
+		io.WriteString(client, "HTTP/1.1 200 Connection established\r\n\r\n")
+		go func() {
+			io.Copy(target, client)
+			target.Close()
+		}()
+		io.Copy(client, target)
+		client.Close()
+	})
+}
+
+// startEchoServer 启动 TCP 回显服务器
+func startEchoServer(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(c, c)
+				c.Close()
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func assertEcho(t *testing.T, conn net.Conn) {
+	_, err := conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(buf))
+}
+
+func TestHttpProxyDialer_TLSUpstreamWithCustomCA(t *testing.T) {
+	echo := startEchoServer(t)
+	upstream := httptest.NewTLSServer(connectProxyHandler(t, nil))
+	defer upstream.Close()
+
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: upstream.Certificate().Raw})
+	node := ProxyNode{
+		Name: "tls",
+		URL:  upstream.URL,  // https://127.0.0.1:port
+		SNI:  "example.com", // httptest 证书包含 example.com
+		CA:   string(caPEM),
+	}
+	d, err := NewHttpProxyDialer(NewCoreHttpSever(), node)
+	require.NoError(t, err)
+
+	conn, err := d.Dial("tcp", echo)
+	require.NoError(t, err)
+	defer conn.Close()
+	assertEcho(t, conn)
+}
+
+func TestHttpProxyDialer_TLSUpstreamUntrusted(t *testing.T) {
+	upstream := httptest.NewTLSServer(connectProxyHandler(t, nil))
+	defer upstream.Close()
+
+	d, err := NewHttpProxyDialer(NewCoreHttpSever(), ProxyNode{Name: "tls", URL: upstream.URL})
+	require.NoError(t, err)
+	_, err = d.Dial("tcp", "127.0.0.1:80")
+	assert.ErrorContains(t, err, "TLS 握手失败")
+
+	// 显式跳过证书校验后可以连通
+	d, err = NewHttpProxyDialer(NewCoreHttpSever(), ProxyNode{Name: "tls", URL: upstream.URL, SkipCertVerify: true})
+	require.NoError(t, err)
+	conn, err := d.Dial("tcp", startEchoServer(t))
+	require.NoError(t, err)
+	defer conn.Close()
+	assertEcho(t, conn)
+}
+
+func TestHttpProxyDialer_ProxyAuthorization(t *testing.T) {
+	echo := startEchoServer(t)
+	upstream := httptest.NewServer(connectProxyHandler(t, func(r *http.Request) int {
+		user, pass, ok := parseProxyBasicAuth(r.Header.Get("Proxy-Authorization"))
+		if !ok || user != "bob" || pass != "pw" || r.Header.Get("X-Team") != "infra" {
+			return http.StatusProxyAuthRequired
+		}
+		return 0
+	}))
+	defer upstream.Close()
+	host := upstream.Listener.Addr().String()
+
+	// URL 中携带凭据
+	d, err := NewHttpProxyDialer(NewCoreHttpSever(), ProxyNode{
+		Name: "auth", URL: "http://bob:pw@" + host, Headers: map[string]string{"X-Team": "infra"},
+	})
+	require.NoError(t, err)
+	conn, err := d.Dial("tcp", echo)
+	require.NoError(t, err)
+	assertEcho(t, conn)
+	conn.Close()
+
+	// 显式字段覆盖 URL 中的凭据
+	d, err = NewHttpProxyDialer(NewCoreHttpSever(), ProxyNode{
+		Name: "auth", URL: "http://bob:old@" + host, Username: "bob", Password: "pw",
+		Headers: map[string]string{"X-Team": "infra"},
+	})
+	require.NoError(t, err)
+	conn, err = d.Dial("tcp", echo)
+	require.NoError(t, err)
+	conn.Close()
+
+	// 凭据错误时返回 ProxyAuthError
+	d, err = NewHttpProxyDialer(NewCoreHttpSever(), ProxyNode{Name: "auth", URL: "http://bob:bad@" + host})
+	require.NoError(t, err)
+	_, err = d.Dial("tcp", echo)
+	var authErr *ProxyAuthError
+	require.ErrorAs(t, err, &authErr)
+	assert.Equal(t, host, authErr.Proxy)
+	assert.Contains(t, err.Error(), "407")
+}
+
+func parseProxyBasicAuth(h string) (user, pass string, ok bool) {
+	r := &http.Request{Header: http.Header{"Authorization": {h}}}
+	return r.BasicAuth()
+}
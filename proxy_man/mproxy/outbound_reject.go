@@ -0,0 +1,133 @@
+package mproxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// 内置拒绝目标，可直接作为 RouteRule.Action 使用
+const (
+	RejectTarget           = "Reject"             // 隧道返回 403，MITM/HTTP 合成 403 响应
+	RejectDropTarget       = "Reject-Drop"        // 不做任何响应直接断开
+	RejectWithStatusTarget = "Reject-With-Status" // 写作 "Reject-With-Status:451"，以指定状态码响应
+)
+
+// RejectError 请求命中拒绝规则，拨号器不会建立任何连接
+type RejectError struct {
+	Target string // 命中的拒绝目标名称
+	Addr   string
+	Status int  // Drop 为 false 时返回给客户端的状态码
+	Drop   bool // 直接断开，不返回响应
+}
+
+func (e *RejectError) Error() string {
+	if e.Drop {
+		return fmt.Sprintf("规则拒绝 %s (%s): 断开连接", e.Addr, e.Target)
+	}
+	return fmt.Sprintf("规则拒绝 %s (%s): %d %s", e.Addr, e.Target, e.Status, http.StatusText(e.Status))
+}
+
+// isRejectTarget 判断名称是否为内置拒绝目标。Reject-With-Status 只保留能解析出有效状态码的写法，
+// 如 "Reject-With-Status-backup" 仍可用作节点名
+func isRejectTarget(name string) bool {
+	if name == RejectTarget || name == RejectDropTarget {
+		return true
+	}
+	_, err := parseRejectStatus(name)
+	return err == nil
+}
+
+// isRejectWithStatus 判断名称是否写成了 "Reject-With-Status:<code>" 的形式（状态码未必有效），用于给出明确的错误
+func isRejectWithStatus(name string) bool {
+	return strings.HasPrefix(name, RejectWithStatusTarget+":")
+}
+
+// parseRejectStatus 解析 "Reject-With-Status:<code>" 中的状态码
+func parseRejectStatus(name string) (int, error) {
+	if !isRejectWithStatus(name) {
+		return 0, fmt.Errorf("未知的拒绝目标 %s，状态码写作 %s:451", name, RejectWithStatusTarget)
+	}
+	code, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(name, RejectWithStatusTarget+":")))
+	// 2xx 会被隧道客户端当作建连成功，3xx 没有 Location 无法跳转，只允许错误状态码
+	if err != nil || code < 400 || code > 599 {
+		return 0, fmt.Errorf("无效的拒绝状态码 %s，应为 400~599", name)
+	}
+	return code, nil
+}
+
+// RejectDialer 拒绝拨号器，Dial 与 Transport 都直接返回 RejectError
+type RejectDialer struct {
+	name      string
+	status    int
+	drop      bool
+	transport *http.Transport
+}
+
+// NewRejectDialer 按目标名称创建拒绝拨号器
+func NewRejectDialer(name string) (*RejectDialer, error) {
+	d := &RejectDialer{name: name, status: http.StatusForbidden}
+	switch name {
+	case RejectTarget:
+	case RejectDropTarget:
+		d.drop = true
+	default:
+		code, err := parseRejectStatus(name)
+		if err != nil {
+			return nil, err
+		}
+		d.status = code
+	}
+	d.transport = &http.Transport{
+		DialContext: func(c context.Context, network, addr string) (net.Conn, error) {
+			return d.Dial(network, addr)
+		},
+	}
+	return d, nil
+}
+
+func (d *RejectDialer) Name() string { return d.name }
+
+func (d *RejectDialer) Dial(network, addr string) (net.Conn, error) {
+	return nil, d.reject(addr)
+}
+
+func (d *RejectDialer) DialUDP(network, addr string) (net.Conn, error) {
+	return nil, d.reject(addr)
+}
+
+func (d *RejectDialer) GetTransport() *http.Transport {
+	return d.transport
+}
+
+func (d *RejectDialer) reject(addr string) *RejectError {
+	return &RejectError{Target: d.name, Addr: addr, Status: d.status, Drop: d.drop}
+}
+
+// RoundTrip 不经过任何拨号，直接合成拒绝响应；Drop 模式返回 RejectError 由调用方断开连接
+func (d *RejectDialer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if d.drop {
+		return nil, d.reject(req.URL.Host)
+	}
+	body := ""
+	if d.status != http.StatusNoContent && d.status != http.StatusNotModified {
+		body = "Blocked by proxy rule: " + d.name
+	}
+	resp := NewResponse(req, ContentTypeText, d.status, body)
+	resp.Status = strconv.Itoa(d.status) + " " + http.StatusText(d.status)
+	resp.Proto, resp.ProtoMajor, resp.ProtoMinor = "HTTP/1.1", 1, 1
+	return resp, nil
+}
+
+// writeRejectResponse 隧道模式下以原始 HTTP 报文回复被拒绝的 CONNECT
+func writeRejectResponse(w io.Writer, e *RejectError) error {
+	body := "Blocked by proxy rule: " + e.Target
+	_, err := fmt.Fprintf(w,
+		"HTTP/1.1 %d %s\r\nContent-Type: text/plain\r\nContent-Length: %d\r\nConnection: close\r\n\r\n%s",
+		e.Status, http.StatusText(e.Status), len(body), body)
+	return err
+}
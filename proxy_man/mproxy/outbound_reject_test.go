@@ -0,0 +1,135 @@
+package mproxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startRoutedProxy 启动开启路由的代理服务器，返回代理地址
+func startRoutedProxy(t *testing.T, cfg *ServerConfig) (*httptest.Server, *Router) {
+	t.Helper()
+	proxy := NewCoreHttpSever()
+	cm := NewConfigManager(filepath.Join(t.TempDir(), "config.json"))
+	cfg.RouteEnable = true
+	require.NoError(t, cm.UpdateConfig(cfg))
+	proxy.Config = cm
+	router := AddRouter(proxy, cm)
+	srv := httptest.NewServer(proxy)
+	t.Cleanup(srv.Close)
+	return srv, router
+}
+
+// rawConnect 通过代理发送 CONNECT，返回连接和读取器
+func rawConnect(t *testing.T, proxyAddr, target string) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", proxyAddr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	_, err = io.WriteString(conn, "CONNECT "+target+" HTTP/1.1\r\nHost: "+target+"\r\n\r\n")
+	require.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	return conn, bufio.NewReader(conn)
+}
+
+func rejectTestConfig() *ServerConfig {
+	return &ServerConfig{
+		Routes: []RouteRule{
+			{Id: 1, Type: "DomainSuffix", Value: "ads.test", Action: RejectTarget, Enable: true},
+			{Id: 2, Type: "DomainSuffix", Value: "drop.test", Action: RejectDropTarget, Enable: true},
+			{Id: 3, Type: "DomainSuffix", Value: "legal.test", Action: "Reject-With-Status:451", Enable: true},
+			{Id: 4, Type: "DomainSuffix", Value: "bad.test", Action: "Reject-With-Status:abc", Enable: true},
+		},
+	}
+}
+
+func TestReject_Tunnel(t *testing.T) {
+	srv, router := startRoutedProxy(t, rejectTestConfig())
+	assert.Len(t, router.Rules, 3, "无效状态码的规则被跳过")
+	addr := srv.Listener.Addr().String()
+
+	_, r := rawConnect(t, addr, "tracker.ads.test:443")
+	resp, err := http.ReadResponse(r, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	_, r = rawConnect(t, addr, "www.legal.test:443")
+	resp, err = http.ReadResponse(r, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnavailableForLegalReasons, resp.StatusCode)
+
+	// Reject-Drop 不返回任何数据直接断开
+	_, r = rawConnect(t, addr, "drop.test:443")
+	n, err := r.Read(make([]byte, 1))
+	assert.Equal(t, 0, n)
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestReject_HTTP(t *testing.T) {
+	srv, _ := startRoutedProxy(t, rejectTestConfig())
+	proxyURL, _ := url.Parse(srv.URL)
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)},
+		Timeout:   5 * time.Second,
+	}
+
+	resp, err := client.Get("http://tracker.ads.test/pixel.gif")
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Contains(t, string(body), RejectTarget)
+
+	resp, err = client.Get("http://legal.test/")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnavailableForLegalReasons, resp.StatusCode)
+
+	_, err = client.Get("http://drop.test/")
+	assert.Error(t, err, "Reject-Drop 直接断开连接")
+}
+
+func TestNewRejectDialer(t *testing.T) {
+	d, err := NewRejectDialer("Reject-With-Status:429")
+	require.NoError(t, err)
+	_, err = d.Dial("tcp", "example.com:443")
+	var rejectErr *RejectError
+	require.ErrorAs(t, err, &rejectErr)
+	assert.Equal(t, http.StatusTooManyRequests, rejectErr.Status)
+
+	// 2xx/3xx 会被隧道客户端误认为建连成功或缺少 Location，只允许 4xx/5xx
+	for _, name := range []string{"Reject-With-Status", "Reject-With-Status:99", "Reject-With-Status:200",
+		"Reject-With-Status:302", "Reject-With-Status:600", "Reject-Foo"} {
+		_, err := NewRejectDialer(name)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestIsRejectTarget(t *testing.T) {
+	for _, name := range []string{RejectTarget, RejectDropTarget, "Reject-With-Status:451"} {
+		assert.True(t, isRejectTarget(name), name)
+	}
+	for _, name := range []string{"Reject-With-Status", "Reject-With-Status-backup", "Reject-With-Status:99"} {
+		assert.False(t, isRejectTarget(name), name)
+	}
+
+	// 只有有效的 Reject-With-Status:<code> 才是保留名，其余以它开头的名称可用作节点
+	router := NewRouter(NewCoreHttpSever())
+	require.NoError(t, router.ReloadFromConfig(&ServerConfig{
+		ProxyNodes: []ProxyNode{
+			{Name: "Reject-With-Status-backup", URL: "http://127.0.0.1:1"},
+			{Name: "Reject-With-Status:403", URL: "http://127.0.0.1:1"},
+		},
+	}))
+	assert.Contains(t, router.Dialers, "Reject-With-Status-backup")
+	assert.NotContains(t, router.Dialers, "Reject-With-Status:403")
+}
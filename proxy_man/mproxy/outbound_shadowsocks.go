@@ -0,0 +1,415 @@
+package mproxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"lukechampine.com/blake3"
+)
+
+const (
+	ssMaxPayload     = 0x3FFF // AEAD（SIP004）单个分块的最大长度
+	ss2022MaxPayload = 0xFFFF // 2022 版（SIP022）单个分块的最大长度
+	ss2022MaxPadding = 900
+	ss2022TimeWindow = 30 * time.Second // 2022 版允许的时间戳偏差
+
+	ss2022SubkeyContext = "shadowsocks 2022 session subkey"
+)
+
+// ssMethod 加密方式
+type ssMethod struct {
+	keySize int
+	newAEAD func(key []byte) (cipher.AEAD, error)
+	is2022  bool
+}
+
+var ssMethods = map[string]ssMethod{
+	"aes-128-gcm":                   {16, newAESGCM, false},
+	"aes-256-gcm":                   {32, newAESGCM, false},
+	"chacha20-ietf-poly1305":        {32, chacha20poly1305.New, false},
+	"2022-blake3-aes-128-gcm":       {16, newAESGCM, true},
+	"2022-blake3-aes-256-gcm":       {32, newAESGCM, true},
+	"2022-blake3-chacha20-poly1305": {32, chacha20poly1305.New, true},
+}
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// ssCipher 一个节点的加密方式与主密钥
+type ssCipher struct {
+	ssMethod
+	key []byte
+}
+
+// newSSCipher 经典 AEAD 由密码经 EVP_BytesToKey 派生主密钥，2022 版的密码是 base64 编码的 PSK
+func newSSCipher(method, password string) (*ssCipher, error) {
+	m, ok := ssMethods[strings.ToLower(method)]
+	if !ok {
+		return nil, fmt.Errorf("不支持的加密方式 %s", method)
+	}
+	if !m.is2022 {
+		return &ssCipher{ssMethod: m, key: ssKDF(password, m.keySize)}, nil
+	}
+	if strings.Contains(password, ":") {
+		return nil, errors.New("暂不支持多用户（EIH）的 PSK")
+	}
+	key, err := base64.StdEncoding.DecodeString(password)
+	if err != nil {
+		return nil, fmt.Errorf("PSK 不是有效的 base64: %w", err)
+	}
+	if len(key) != m.keySize {
+		return nil, fmt.Errorf("%s 的 PSK 长度应为 %d 字节，实际 %d 字节", method, m.keySize, len(key))
+	}
+	return &ssCipher{ssMethod: m, key: key}, nil
+}
+
+// ssKDF OpenSSL EVP_BytesToKey（MD5，无盐，单轮）
+func ssKDF(password string, keySize int) []byte {
+	var key, prev []byte
+	h := md5.New()
+	for len(key) < keySize {
+		h.Write(prev)
+		h.Write([]byte(password))
+		key = h.Sum(key)
+		prev = key[len(key)-h.Size():]
+		h.Reset()
+	}
+	return key[:keySize]
+}
+
+// sessionAEAD 由 salt 派生会话子密钥
+func (c *ssCipher) sessionAEAD(salt []byte) (cipher.AEAD, error) {
+	var subkey []byte
+	if c.is2022 {
+		material := append(append([]byte(nil), c.key...), salt...)
+		subkey = make([]byte, c.keySize)
+		blake3.DeriveKey(subkey, ss2022SubkeyContext, material)
+	} else {
+		var err error
+		if subkey, err = hkdf.Key(sha1.New, c.key, salt, "ss-subkey", c.keySize); err != nil {
+			return nil, err
+		}
+	}
+	return c.newAEAD(subkey)
+}
+
+func (c *ssCipher) maxPayload() int {
+	if c.is2022 {
+		return ss2022MaxPayload
+	}
+	return ssMaxPayload
+}
+
+// parseSSURL 解析 ss:// 链接，支持 SIP002 "ss://userinfo@host:port#tag"（userinfo 为 base64url(method:password)，
+// 2022 版为百分号编码的 method:password）以及旧格式 "ss://base64(method:password@host:port)#tag"
+func parseSSURL(raw string) (method, password, server string, err error) {
+	rest, ok := strings.CutPrefix(raw, "ss://")
+	if !ok {
+		return "", "", "", fmt.Errorf("无效的代理 URL: %s (仅支持 ss scheme)", raw)
+	}
+	rest, _, _ = strings.Cut(rest, "#")
+	if !strings.Contains(rest, "@") {
+		// 旧格式：整体 base64 编码
+		body, _, _ := strings.Cut(rest, "?")
+		decoded, err := ssBase64Decode(strings.TrimSuffix(body, "/"))
+		if err != nil {
+			return "", "", "", fmt.Errorf("无效的 ss 链接: %w", err)
+		}
+		i := strings.LastIndexByte(decoded, '@')
+		if i < 0 {
+			return "", "", "", errors.New("无效的 ss 链接: 缺少服务器地址")
+		}
+		server = decoded[i+1:]
+		if _, port, err := net.SplitHostPort(server); err != nil || port == "" {
+			return "", "", "", fmt.Errorf("无效的 ss 服务器地址 %s", server)
+		}
+		method, password, _ = strings.Cut(decoded[:i], ":")
+		return method, password, server, nil
+	}
+
+	u, err := url.Parse("ss://" + rest)
+	if err != nil {
+		return "", "", "", err
+	}
+	if plugin := u.Query().Get("plugin"); plugin != "" {
+		return "", "", "", fmt.Errorf("暂不支持 SIP003 插件 %s", plugin)
+	}
+	if u.Port() == "" {
+		return "", "", "", errors.New("ss 链接缺少端口")
+	}
+	if u.User == nil {
+		return "", "", "", errors.New("ss 链接缺少加密方式和密码")
+	}
+	method = u.User.Username()
+	password, hasPassword := u.User.Password()
+	if !hasPassword {
+		decoded, err := ssBase64Decode(method)
+		if err != nil {
+			return "", "", "", fmt.Errorf("无效的 ss 用户信息: %w", err)
+		}
+		method, password, _ = strings.Cut(decoded, ":")
+	}
+	return method, password, u.Host, nil
+}
+
+// ssBase64Decode 兼容 URL 安全与标准字母表、有无填充
+func ssBase64Decode(s string) (string, error) {
+	s = strings.TrimRight(s, "=")
+	for _, enc := range []*base64.Encoding{base64.RawURLEncoding, base64.RawStdEncoding} {
+		if b, err := enc.DecodeString(s); err == nil {
+			return string(b), nil
+		}
+	}
+	return "", errors.New("不是有效的 base64")
+}
+
+// ShadowsocksDialer Shadowsocks AEAD 拨号器（SIP004 / SIP022），只支持 TCP
+type ShadowsocksDialer struct {
+	proxy     *CoreHttpServer
+	name      string
+	server    string
+	cipher    *ssCipher
+	dial      dialContextFunc // 建立到服务器的连接，nil 时使用 proxy.dial
+	transport *http.Transport
+}
+
+// NewShadowsocksDialer 创建 Shadowsocks 拨号器，node.URL 为 SIP002 链接
+func NewShadowsocksDialer(proxy *CoreHttpServer, node ProxyNode) (*ShadowsocksDialer, error) {
+	return newShadowsocksDialer(proxy, node, nil, DialOptions{})
+}
+
+func newShadowsocksDialer(proxy *CoreHttpServer, node ProxyNode, dial dialContextFunc, opts DialOptions) (*ShadowsocksDialer, error) {
+	method, password, server, err := parseSSURL(node.URL)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newSSCipher(method, password)
+	if err != nil {
+		return nil, fmt.Errorf("节点 %s: %w", node.Name, err)
+	}
+	d := &ShadowsocksDialer{proxy: proxy, name: node.Name, server: server, cipher: c, dial: dial}
+	tr := createBaseTransport(opts)
+	tr.DialContext = func(c context.Context, network, addr string) (net.Conn, error) {
+		return d.Dial(network, addr)
+	}
+	d.transport = tr
+	return d, nil
+}
+
+func (d *ShadowsocksDialer) Dial(network, addr string) (net.Conn, error) {
+	if !strings.HasPrefix(network, "tcp") {
+		return nil, fmt.Errorf("shadowsocks: 不支持的网络类型 %s", network)
+	}
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 0xFFFF {
+		return nil, fmt.Errorf("shadowsocks: 无效端口 %s", portStr)
+	}
+	target, err := appendSocks5Addr(nil, host, port)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := d.proxy.dial(&Pcontext{Req: &http.Request{}, Dialer: d.dial}, "tcp", d.server)
+	if err != nil {
+		return nil, err
+	}
+	conn := &ssConn{Conn: c, cipher: d.cipher}
+	// 立即发送请求头，服务端先发言的协议（如 SSH、SMTP）不必等待客户端数据
+	if err := conn.writeRequest(target); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("shadowsocks %s: %w", d.server, err)
+	}
+	return conn, nil
+}
+
+func (d *ShadowsocksDialer) Name() string { return d.name }
+
+func (d *ShadowsocksDialer) GetTransport() *http.Transport {
+	return d.transport
+}
+
+// ssConn 加密连接：每个方向各自的 salt 与子密钥，nonce 为从 0 开始的小端计数器
+type ssConn struct {
+	net.Conn
+	cipher *ssCipher
+
+	wmu      sync.Mutex
+	enc      cipher.AEAD
+	encNonce []byte
+	reqSalt  []byte
+
+	dec      cipher.AEAD
+	decNonce []byte
+	pending  []byte // 已解密未读取的数据
+}
+
+func ssIncrementNonce(nonce []byte) {
+	for i := range nonce {
+		nonce[i]++
+		if nonce[i] != 0 {
+			return
+		}
+	}
+}
+
+func (c *ssConn) seal(dst, plaintext []byte) []byte {
+	dst = c.enc.Seal(dst, c.encNonce, plaintext, nil)
+	ssIncrementNonce(c.encNonce)
+	return dst
+}
+
+// open 读取 n 字节明文对应的密文块并解密
+func (c *ssConn) open(n int) ([]byte, error) {
+	buf := make([]byte, n+c.dec.Overhead())
+	if _, err := io.ReadFull(c.Conn, buf); err != nil {
+		return nil, err
+	}
+	plain, err := c.dec.Open(buf[:0], c.decNonce, buf, nil)
+	if err != nil {
+		return nil, fmt.Errorf("shadowsocks: 解密失败（密码或加密方式不匹配）: %w", err)
+	}
+	ssIncrementNonce(c.decNonce)
+	return plain, nil
+}
+
+// writeRequest 发送 salt 和目标地址。2022 版为定长头（类型、时间戳、变长头长度）+ 变长头（地址、随机填充）
+func (c *ssConn) writeRequest(target []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	salt := make([]byte, c.cipher.keySize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	enc, err := c.cipher.sessionAEAD(salt)
+	if err != nil {
+		return err
+	}
+	c.enc, c.encNonce, c.reqSalt = enc, make([]byte, enc.NonceSize()), salt
+
+	out := append([]byte(nil), salt...)
+	if !c.cipher.is2022 {
+		out = c.appendChunk(out, target)
+		_, err = c.Conn.Write(out)
+		return err
+	}
+
+	padLen, err := rand.Int(rand.Reader, big.NewInt(ss2022MaxPadding))
+	if err != nil {
+		return err
+	}
+	variable := binary.BigEndian.AppendUint16(target, uint16(padLen.Int64()+1))
+	variable = append(variable, make([]byte, padLen.Int64()+1)...)
+	fixed := []byte{0} // 0 = 客户端请求
+	fixed = binary.BigEndian.AppendUint64(fixed, uint64(time.Now().Unix()))
+	fixed = binary.BigEndian.AppendUint16(fixed, uint16(len(variable)))
+	out = c.seal(out, fixed)
+	out = c.seal(out, variable)
+	_, err = c.Conn.Write(out)
+	return err
+}
+
+// appendChunk 追加一个分块：加密的 2 字节长度 + 加密的数据
+func (c *ssConn) appendChunk(dst, payload []byte) []byte {
+	dst = c.seal(dst, binary.BigEndian.AppendUint16(nil, uint16(len(payload))))
+	return c.seal(dst, payload)
+}
+
+func (c *ssConn) Write(b []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	max := c.cipher.maxPayload()
+	var out []byte
+	for p := b; len(p) > 0; {
+		n := min(len(p), max)
+		out = c.appendChunk(out, p[:n])
+		p = p[n:]
+	}
+	if _, err := c.Conn.Write(out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *ssConn) Read(b []byte) (int, error) {
+	if len(c.pending) == 0 {
+		if c.dec == nil {
+			if err := c.readResponseHeader(); err != nil {
+				return 0, err
+			}
+		}
+		for len(c.pending) == 0 {
+			length, err := c.open(2)
+			if err != nil {
+				return 0, err
+			}
+			if c.pending, err = c.open(int(binary.BigEndian.Uint16(length))); err != nil {
+				return 0, err
+			}
+		}
+	}
+	n := copy(b, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// readResponseHeader 读取服务器的 salt。2022 版还需校验响应头中的时间戳和请求 salt，并读取首个数据块
+func (c *ssConn) readResponseHeader() error {
+	salt := make([]byte, c.cipher.keySize)
+	if _, err := io.ReadFull(c.Conn, salt); err != nil {
+		return err
+	}
+	dec, err := c.cipher.sessionAEAD(salt)
+	if err != nil {
+		return err
+	}
+	c.dec, c.decNonce = dec, make([]byte, dec.NonceSize())
+	if !c.cipher.is2022 {
+		return nil
+	}
+
+	header, err := c.open(1 + 8 + len(c.reqSalt) + 2)
+	if err != nil {
+		return err
+	}
+	if header[0] != 1 { // 1 = 服务器响应
+		return errors.New("shadowsocks: 响应类型错误")
+	}
+	ts := time.Unix(int64(binary.BigEndian.Uint64(header[1:9])), 0)
+	if d := time.Since(ts); d > ss2022TimeWindow || d < -ss2022TimeWindow {
+		return fmt.Errorf("shadowsocks: 响应时间戳偏差过大 %s", d.Round(time.Second))
+	}
+	if !bytes.Equal(header[9:9+len(c.reqSalt)], c.reqSalt) {
+		return errors.New("shadowsocks: 响应中的请求 salt 不匹配")
+	}
+	c.pending, err = c.open(int(binary.BigEndian.Uint16(header[9+len(c.reqSalt):])))
+	return err
+}
@@ -0,0 +1,284 @@
+package mproxy
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/shadowsocks/go-shadowsocks2/core"
+	"github.com/shadowsocks/go-shadowsocks2/socks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startSSServer 用 go-shadowsocks2 启动经典 AEAD 服务器，收到的目标地址写入 targets
+func startSSServer(t *testing.T, method, password string, targets chan<- string) string {
+	ciph, err := core.PickCipher(method, nil, password)
+	require.NoError(t, err)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				sc := ciph.StreamConn(c)
+				addr, err := socks.ReadAddr(sc)
+				if err != nil {
+					return
+				}
+				targets <- addr.String()
+				target, err := net.Dial("tcp", addr.String())
+				if err != nil {
+					return
+				}
+				defer target.Close()
+				go io.Copy(target, sc)
+				io.Copy(sc, target)
+			}(c)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// ss2022TestConn 2022 版服务端一侧的加解密
+type ss2022TestConn struct {
+	net.Conn
+	aead  cipher.AEAD
+	nonce []byte
+}
+
+func (s *ss2022TestConn) open(n int) ([]byte, error) {
+	buf := make([]byte, n+s.aead.Overhead())
+	if _, err := io.ReadFull(s.Conn, buf); err != nil {
+		return nil, err
+	}
+	plain, err := s.aead.Open(nil, s.nonce, buf, nil)
+	ssIncrementNonce(s.nonce)
+	return plain, err
+}
+
+func (s *ss2022TestConn) seal(dst, plain []byte) []byte {
+	dst = s.aead.Seal(dst, s.nonce, plain, nil)
+	ssIncrementNonce(s.nonce)
+	return dst
+}
+
+// startSS2022Server 极简的 SIP022 服务器。go-shadowsocks2 尚不支持 2022 版，
+// 这里按规范实现服务端，子密钥派生复用被测代码
+func startSS2022Server(t *testing.T, method, psk string) string {
+	c, err := newSSCipher(method, psk)
+	require.NoError(t, err)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+		reqSalt := make([]byte, c.keySize)
+		if _, err := io.ReadFull(conn, reqSalt); err != nil {
+			return
+		}
+		aead, _ := c.sessionAEAD(reqSalt)
+		in := &ss2022TestConn{Conn: conn, aead: aead, nonce: make([]byte, aead.NonceSize())}
+		fixed, err := in.open(11)
+		if err != nil || fixed[0] != 0 {
+			return
+		}
+		if d := time.Since(time.Unix(int64(binary.BigEndian.Uint64(fixed[1:9])), 0)); d.Abs() > ss2022TimeWindow {
+			return
+		}
+		variable, err := in.open(int(binary.BigEndian.Uint16(fixed[9:])))
+		if err != nil {
+			return
+		}
+		r := bytes.NewReader(variable)
+		addr, err := readSocks5Addr(r)
+		if err != nil {
+			return
+		}
+		var padLen uint16
+		binary.Read(r, binary.BigEndian, &padLen)
+		r.Seek(int64(padLen), io.SeekCurrent)
+		target, err := net.Dial("tcp", addr)
+		if err != nil {
+			return
+		}
+		defer target.Close()
+		io.Copy(target, r) // 请求头携带的首段数据
+
+		go func() {
+			for {
+				length, err := in.open(2)
+				if err != nil {
+					return
+				}
+				payload, err := in.open(int(binary.BigEndian.Uint16(length)))
+				if err != nil {
+					return
+				}
+				target.Write(payload)
+			}
+		}()
+
+		buf := make([]byte, 4096)
+		n, err := target.Read(buf)
+		if err != nil {
+			return
+		}
+		respSalt := make([]byte, c.keySize)
+		rand.Read(respSalt)
+		aead, _ = c.sessionAEAD(respSalt)
+		out := &ss2022TestConn{Conn: conn, aead: aead, nonce: make([]byte, aead.NonceSize())}
+		header := []byte{1}
+		header = binary.BigEndian.AppendUint64(header, uint64(time.Now().Unix()))
+		header = append(header, reqSalt...)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+		conn.Write(out.seal(out.seal(respSalt, header), buf[:n]))
+		for {
+			n, err := target.Read(buf)
+			if err != nil {
+				return
+			}
+			conn.Write(out.seal(out.seal(nil, binary.BigEndian.AppendUint16(nil, uint16(n))), buf[:n]))
+		}
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestParseSSURL(t *testing.T) {
+	for _, tc := range []struct {
+		raw, method, password, server string
+	}{
+		// SIP002，base64url 带填充
+		{"ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpzM2NyZXQ=@1.2.3.4:8388#hk", "chacha20-ietf-poly1305", "s3cret", "1.2.3.4:8388"},
+		// SIP002，无填充，密码中含冒号
+		{"ss://YWVzLTI1Ni1nY206cGFzczp3b3Jk@[::1]:443/?outline=1", "aes-256-gcm", "pass:word", "[::1]:443"},
+		// 2022 版使用百分号编码
+		{"ss://2022-blake3-aes-128-gcm:" + url.QueryEscape("YWJjZGVmZ2hpamtsbW5vcA==") + "@example.com:443", "2022-blake3-aes-128-gcm", "YWJjZGVmZ2hpamtsbW5vcA==", "example.com:443"},
+		// 旧格式
+		{"ss://YWVzLTEyOC1nY206cEBzc0BleGFtcGxlLmNvbTo4Mzg4#old", "aes-128-gcm", "p@ss", "example.com:8388"},
+	} {
+		method, password, server, err := parseSSURL(tc.raw)
+		require.NoError(t, err, tc.raw)
+		assert.Equal(t, []string{tc.method, tc.password, tc.server}, []string{method, password, server}, tc.raw)
+	}
+
+	for _, raw := range []string{
+		"ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpzM2NyZXQ@1.2.3.4:8388/?plugin=obfs-local%3Bobfs%3Dhttp",
+		"ss://Y2hhY2hhMjAtaWV0Zi1wb2x5MTMwNTpzM2NyZXQ@1.2.3.4",
+		"ss://!!!",
+	} {
+		_, _, _, err := parseSSURL(raw)
+		assert.Error(t, err, raw)
+	}
+
+	_, err := newSSCipher("rc4-md5", "x")
+	assert.Error(t, err, "不支持流加密")
+	_, err = newSSCipher("2022-blake3-aes-256-gcm", "YWJjZGVmZ2hpamtsbW5vcA==")
+	assert.Error(t, err, "PSK 长度与加密方式不符")
+}
+
+func TestShadowsocksDialer_Interop(t *testing.T) {
+	echo := startEchoServer(t)
+	targets := make(chan string, 4)
+	psk128 := "YWJjZGVmZ2hpamtsbW5vcA=="
+	psk256 := "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY="
+
+	for _, tc := range []struct{ name, url string }{
+		{"chacha20", "ss://" + url.UserPassword("chacha20-ietf-poly1305", "s3cret").String() + "@" + startSSServer(t, "CHACHA20-IETF-POLY1305", "s3cret", targets)},
+		{"aes-256", "ss://" + url.UserPassword("aes-256-gcm", "s3cret").String() + "@" + startSSServer(t, "AES-256-GCM", "s3cret", targets)},
+		{"2022-aes-128", "ss://" + url.UserPassword("2022-blake3-aes-128-gcm", psk128).String() + "@" + startSS2022Server(t, "2022-blake3-aes-128-gcm", psk128)},
+		{"2022-chacha20", "ss://" + url.UserPassword("2022-blake3-chacha20-poly1305", psk256).String() + "@" + startSS2022Server(t, "2022-blake3-chacha20-poly1305", psk256)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := NewShadowsocksDialer(NewCoreHttpSever(), ProxyNode{Name: tc.name, URL: tc.url})
+			require.NoError(t, err)
+			conn, err := d.Dial("tcp", echo)
+			require.NoError(t, err)
+			assertEcho(t, conn)
+
+			// 超过单个分块上限的数据会被拆分
+			big := bytes.Repeat([]byte("0123456789abcdef"), 0x1800)
+			go conn.Write(big)
+			got := make([]byte, len(big))
+			_, err = io.ReadFull(conn, got)
+			require.NoError(t, err)
+			assert.Equal(t, big, got)
+			conn.Close()
+		})
+	}
+	assert.Equal(t, echo, <-targets)
+
+	// 密码错误时服务器无法解密，连接被关闭
+	d, err := NewShadowsocksDialer(NewCoreHttpSever(), ProxyNode{Name: "bad", URL: "ss://" + url.UserPassword("aes-256-gcm", "wrong").String() + "@" + startSSServer(t, "AES-256-GCM", "s3cret", targets)})
+	require.NoError(t, err)
+	conn, err := d.Dial("tcp", echo)
+	require.NoError(t, err)
+	conn.Write([]byte("hello"))
+	_, err = conn.Read(make([]byte, 5))
+	assert.Error(t, err)
+	conn.Close()
+}
+
+func TestShadowsocksDialer_RouteDialAndTransport(t *testing.T) {
+	targets := make(chan string, 4)
+	server := startSSServer(t, "AES-256-GCM", "s3cret", targets)
+	httpSrv := startHTTPServer(t)
+
+	router := NewRouter(NewCoreHttpSever())
+	require.NoError(t, router.ReloadFromConfig(&ServerConfig{
+		ProxyNodes: []ProxyNode{{Name: "ss", URL: "ss://" + url.UserPassword("aes-256-gcm", "s3cret").String() + "@" + server}},
+		Routes: []RouteRule{
+			{Id: 1, Type: "IP-CIDR", Value: "127.0.0.0/8", Action: "ss", Enable: true, NoResolve: true},
+		},
+	}))
+	assert.IsType(t, &ShadowsocksDialer{}, router.Dialers["ss"])
+
+	req, _ := http.NewRequest(http.MethodConnect, "http://"+httpSrv, nil)
+	conn, err := router.RouteDial(req, "tcp", httpSrv)
+	require.NoError(t, err)
+	conn.Close()
+	assert.Equal(t, httpSrv, <-targets)
+
+	// MITM 流量经由节点的 Transport
+	req, _ = http.NewRequest(http.MethodGet, "http://"+httpSrv+"/", nil)
+	resp, err := router.Dialers["ss"].GetTransport().RoundTrip(req)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "ok", string(body))
+	assert.Equal(t, httpSrv, <-targets)
+}
+
+// startHTTPServer 启动返回 "ok" 的 HTTP 服务器
+func startHTTPServer(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	})}
+	go srv.Serve(ln)
+	t.Cleanup(func() { srv.Close() })
+	return ln.Addr().String()
+}
@@ -0,0 +1,275 @@
+package mproxy
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// SOCKS5 协议常量（RFC 1928 / RFC 1929）
+const (
+	socks5Version = 0x05
+
+	socks5AuthNone         = 0x00
+	socks5AuthPassword     = 0x02
+	socks5AuthNoAcceptable = 0xFF
+
+	socks5CmdConnect      = 0x01
+	socks5CmdUDPAssociate = 0x03
+
+	socks5AddrIPv4   = 0x01
+	socks5AddrDomain = 0x03
+	socks5AddrIPv6   = 0x04
+
+	socks5ReplySucceeded        = 0x00
+	socks5ReplyGeneralFailure   = 0x01
+	socks5ReplyNotAllowed       = 0x02
+	socks5ReplyNetUnreachable   = 0x03
+	socks5ReplyHostUnreachable  = 0x04
+	socks5ReplyConnRefused      = 0x05
+	socks5ReplyCmdNotSupported  = 0x07
+	socks5ReplyAddrNotSupported = 0x08
+)
+
+// socks5ReplyText SOCKS5 应答码对应的错误描述
+var socks5ReplyText = map[byte]string{
+	0x01: "general SOCKS server failure",
+	0x02: "connection not allowed by ruleset",
+	0x03: "network unreachable",
+	0x04: "host unreachable",
+	0x05: "connection refused",
+	0x06: "TTL expired",
+	0x07: "command not supported",
+	0x08: "address type not supported",
+}
+
+// appendSocks5Addr 按 SOCKS5 地址格式（ATYP + ADDR + PORT）编码目标地址
+func appendSocks5Addr(b []byte, host string, port int) ([]byte, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			b = append(b, socks5AddrIPv4)
+			b = append(b, ip4...)
+		} else {
+			b = append(b, socks5AddrIPv6)
+			b = append(b, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return nil, fmt.Errorf("socks5: 域名过长 %s", host)
+		}
+		b = append(b, socks5AddrDomain, byte(len(host)))
+		b = append(b, host...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(port)), nil
+}
+
+// readSocks5Addr 从连接读取 SOCKS5 地址格式（ATYP 已包含在内），返回 host:port
+func readSocks5Addr(r io.Reader) (string, error) {
+	var atyp [1]byte
+	if _, err := io.ReadFull(r, atyp[:]); err != nil {
+		return "", err
+	}
+	var host string
+	switch atyp[0] {
+	case socks5AddrIPv4:
+		ip := make([]byte, net.IPv4len)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case socks5AddrIPv6:
+		ip := make([]byte, net.IPv6len)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case socks5AddrDomain:
+		var l [1]byte
+		if _, err := io.ReadFull(r, l[:]); err != nil {
+			return "", err
+		}
+		name := make([]byte, l[0])
+		if _, err := io.ReadFull(r, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		return "", fmt.Errorf("socks5: 未知地址类型 %d", atyp[0])
+	}
+	var port [2]byte
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))), nil
+}
+
+// socks5ClientHandshake 在已建立的到 SOCKS5 服务器的连接上完成认证协商和 CONNECT 命令
+func socks5ClientHandshake(conn net.Conn, addr string, user *url.Userinfo) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 0xFFFF {
+		return fmt.Errorf("socks5: 无效端口 %s", portStr)
+	}
+
+	// 1. 方法协商：有账号时同时声明无认证和用户名密码认证
+	methods := []byte{socks5AuthNone}
+	if user != nil {
+		methods = append(methods, socks5AuthPassword)
+	}
+	greeting := append([]byte{socks5Version, byte(len(methods))}, methods...)
+	if _, err := conn.Write(greeting); err != nil {
+		return err
+	}
+	var choice [2]byte
+	if _, err := io.ReadFull(conn, choice[:]); err != nil {
+		return err
+	}
+	if choice[0] != socks5Version {
+		return fmt.Errorf("socks5: 服务器版本错误 %d", choice[0])
+	}
+
+	// 2. 用户名密码子协商（RFC 1929）
+	switch choice[1] {
+	case socks5AuthNone:
+	case socks5AuthPassword:
+		if user == nil {
+			return errors.New("socks5: 服务器要求用户名密码认证")
+		}
+		name := user.Username()
+		pass, _ := user.Password()
+		if len(name) > 255 || len(pass) > 255 {
+			return errors.New("socks5: 用户名或密码过长")
+		}
+		auth := []byte{0x01, byte(len(name))}
+		auth = append(auth, name...)
+		auth = append(auth, byte(len(pass)))
+		auth = append(auth, pass...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		var status [2]byte
+		if _, err := io.ReadFull(conn, status[:]); err != nil {
+			return err
+		}
+		if status[1] != 0x00 {
+			return errors.New("socks5: 用户名密码认证失败")
+		}
+	case socks5AuthNoAcceptable:
+		return errors.New("socks5: 服务器不接受任何认证方式")
+	default:
+		return fmt.Errorf("socks5: 服务器选择了不支持的认证方式 %d", choice[1])
+	}
+
+	// 3. CONNECT 命令
+	req := []byte{socks5Version, socks5CmdConnect, 0x00}
+	if req, err = appendSocks5Addr(req, host, port); err != nil {
+		return err
+	}
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	var reply [3]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+	if reply[1] != socks5ReplySucceeded {
+		text, ok := socks5ReplyText[reply[1]]
+		if !ok {
+			text = "unknown error " + strconv.Itoa(int(reply[1]))
+		}
+		return &TargetDialError{Err: fmt.Errorf("socks5: 代理拒绝连接 %s: %s", addr, text)}
+	}
+	// 丢弃服务器返回的绑定地址
+	_, err = readSocks5Addr(conn)
+	return err
+}
+
+// Socks5ProxyDialer SOCKS5 二级代理拨号器
+// socks5:// 在本地解析域名后以 IP 发送，socks5h:// 将域名交给代理服务器解析
+type Socks5ProxyDialer struct {
+	proxy         *CoreHttpServer
+	name          string
+	server        string // 代理服务器 host:port
+	user          *url.Userinfo
+	remoteResolve bool
+	dial          dialContextFunc // 建立到代理服务器的连接，nil 时使用 proxy.dial
+	transport     *http.Transport
+}
+
+// NewSocks5ProxyDialer 创建 SOCKS5 二级代理拨号器
+func NewSocks5ProxyDialer(proxy *CoreHttpServer, node ProxyNode) (*Socks5ProxyDialer, error) {
+	return newSocks5ProxyDialer(proxy, node, nil, DialOptions{})
+}
+
+func newSocks5ProxyDialer(proxy *CoreHttpServer, node ProxyNode, dial dialContextFunc, opts DialOptions) (*Socks5ProxyDialer, error) {
+	u, err := url.Parse(node.URL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "socks5" && u.Scheme != "socks5h" {
+		return nil, fmt.Errorf("无效的代理 URL: %s (仅支持 socks5/socks5h scheme)", node.URL)
+	}
+	server := u.Host
+	if u.Port() == "" {
+		server = net.JoinHostPort(u.Hostname(), "1080")
+	}
+	d := &Socks5ProxyDialer{
+		proxy:         proxy,
+		name:          node.Name,
+		server:        server,
+		user:          nodeUserinfo(node),
+		remoteResolve: u.Scheme == "socks5h",
+		dial:          dial,
+	}
+	tr := createBaseTransport(opts)
+	tr.DialContext = func(c context.Context, network, addr string) (net.Conn, error) {
+		return d.Dial(network, addr)
+	}
+	d.transport = tr
+	return d, nil
+}
+
+func (d *Socks5ProxyDialer) Dial(network, addr string) (net.Conn, error) {
+	target := addr
+	if !d.remoteResolve {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if net.ParseIP(host) == nil {
+			ips, err := d.proxy.Resolver.LookupNetIP(context.Background(), host)
+			if err != nil {
+				return nil, &TargetDialError{Err: err}
+			}
+			if len(ips) == 0 {
+				return nil, &TargetDialError{Err: fmt.Errorf("socks5: 无法解析 %s", host)}
+			}
+			target = net.JoinHostPort(ips[0].String(), port)
+		}
+	}
+
+	c, err := d.proxy.dial(&Pcontext{Req: &http.Request{}, Dialer: d.dial}, "tcp", d.server)
+	if err != nil {
+		return nil, err
+	}
+	if err := socks5ClientHandshake(c, target, d.user); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (d *Socks5ProxyDialer) Name() string { return d.name }
+
+func (d *Socks5ProxyDialer) GetTransport() *http.Transport {
+	return d.transport
+}
@@ -0,0 +1,107 @@
+package mproxy
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startFakeSocks5 启动一个只支持 CONNECT 的极简 SOCKS5 服务器，收到的目标地址写入 targets
+func startFakeSocks5(t *testing.T, user, pass string, targets chan<- string) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				head := make([]byte, 2)
+				io.ReadFull(c, head)
+				methods := make([]byte, head[1])
+				io.ReadFull(c, methods)
+				if user == "" {
+					c.Write([]byte{socks5Version, socks5AuthNone})
+				} else {
+					c.Write([]byte{socks5Version, socks5AuthPassword})
+					ver := make([]byte, 2)
+					io.ReadFull(c, ver)
+					name := make([]byte, ver[1])
+					io.ReadFull(c, name)
+					pl := make([]byte, 1)
+					io.ReadFull(c, pl)
+					pw := make([]byte, pl[0])
+					io.ReadFull(c, pw)
+					if string(name) != user || string(pw) != pass {
+						c.Write([]byte{0x01, 0x01})
+						return
+					}
+					c.Write([]byte{0x01, 0x00})
+				}
+				cmd := make([]byte, 3)
+				io.ReadFull(c, cmd)
+				addr, err := readSocks5Addr(c)
+				if err != nil {
+					return
+				}
+				targets <- addr
+				c.Write([]byte{socks5Version, socks5ReplySucceeded, 0x00, socks5AddrIPv4, 0, 0, 0, 0, 0, 0})
+				io.Copy(c, c)
+			}(c)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestSocks5ProxyDialer_RemoteResolveWithAuth(t *testing.T) {
+	targets := make(chan string, 1)
+	server := startFakeSocks5(t, "alice", "s3cret", targets)
+
+	d, err := NewSocks5ProxyDialer(NewCoreHttpSever(), ProxyNode{Name: "node", URL: "socks5h://alice:s3cret@" + server})
+	require.NoError(t, err)
+
+	conn, err := d.Dial("tcp", "example.com:443")
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, "example.com:443", <-targets)
+
+	// 握手完成后连接应当透明转发数据
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(buf))
+}
+
+func TestSocks5ProxyDialer_WrongPassword(t *testing.T) {
+	server := startFakeSocks5(t, "alice", "s3cret", make(chan string, 1))
+
+	d, err := NewSocks5ProxyDialer(NewCoreHttpSever(), ProxyNode{Name: "node", URL: "socks5://alice:wrong@" + server})
+	require.NoError(t, err)
+
+	_, err = d.Dial("tcp", "127.0.0.1:80")
+	assert.ErrorContains(t, err, "认证失败")
+}
+
+func TestNewOutboundDialer_Scheme(t *testing.T) {
+	proxy := NewCoreHttpSever()
+
+	d, err := NewOutboundDialer(proxy, ProxyNode{Name: "s", URL: "socks5://127.0.0.1:1080"})
+	require.NoError(t, err)
+	assert.IsType(t, &Socks5ProxyDialer{}, d)
+
+	d, err = NewOutboundDialer(proxy, ProxyNode{Name: "h", URL: "http://127.0.0.1:7892"})
+	require.NoError(t, err)
+	assert.IsType(t, &HttpProxyDialer{}, d)
+
+	_, err = NewOutboundDialer(proxy, ProxyNode{Name: "x", URL: "ftp://127.0.0.1:21"})
+	assert.Error(t, err)
+}
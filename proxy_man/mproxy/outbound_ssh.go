@@ -0,0 +1,298 @@
+package mproxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const sshHandshakeTimeout = 10 * time.Second
+
+// keepalive 间隔与等待应答的超时，创建拨号器时读取，测试时可调小
+var (
+	sshKeepAliveInterval = 30 * time.Second
+	sshKeepAliveTimeout  = 10 * time.Second
+)
+
+// SshProxyDialer SSH 跳板机拨号器。所有 Dial 共用一条 SSH 连接，每次 Dial 打开一个 direct-tcpip 通道；
+// 连接断开后由下一次 Dial 自动重连
+type SshProxyDialer struct {
+	proxy     *CoreHttpServer
+	name      string
+	server    string // SSH 服务器 host:port
+	config    *ssh.ClientConfig
+	dial      dialContextFunc // 建立到 SSH 服务器的连接，nil 时使用 proxy.dial
+	transport *http.Transport
+
+	keepAliveInterval time.Duration // 创建时取自 sshKeepAliveInterval / sshKeepAliveTimeout
+	keepAliveTimeout  time.Duration
+
+	mu         sync.Mutex
+	client     *ssh.Client
+	connecting *sshConnect // 正在建立的连接，并发的 Dial 等待同一次握手
+	closed     bool
+}
+
+// sshConnect 一次进行中的 SSH 建连，done 关闭后 client/err 可读
+type sshConnect struct {
+	done   chan struct{}
+	client *ssh.Client
+	err    error
+}
+
+// NewSshProxyDialer 创建 SSH 跳板机拨号器
+func NewSshProxyDialer(proxy *CoreHttpServer, node ProxyNode) (*SshProxyDialer, error) {
+	return newSshProxyDialer(proxy, node, nil, DialOptions{})
+}
+
+func newSshProxyDialer(proxy *CoreHttpServer, node ProxyNode, dial dialContextFunc, opts DialOptions) (*SshProxyDialer, error) {
+	u, err := url.Parse(node.URL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "ssh" {
+		return nil, fmt.Errorf("无效的代理 URL: %s (仅支持 ssh scheme)", node.URL)
+	}
+	server := u.Host
+	if u.Port() == "" {
+		server = net.JoinHostPort(u.Hostname(), "22")
+	}
+	config, err := buildSshClientConfig(node)
+	if err != nil {
+		return nil, err
+	}
+	d := &SshProxyDialer{
+		proxy:  proxy,
+		name:   node.Name,
+		server: server,
+		config: config,
+		dial:   dial,
+
+		keepAliveInterval: sshKeepAliveInterval,
+		keepAliveTimeout:  sshKeepAliveTimeout,
+	}
+	tr := createBaseTransport(opts)
+	tr.DialContext = func(c context.Context, network, addr string) (net.Conn, error) {
+		return d.Dial(network, addr)
+	}
+	d.transport = tr
+	return d, nil
+}
+
+// buildSshClientConfig 根据节点配置生成认证方式和主机密钥校验
+func buildSshClientConfig(node ProxyNode) (*ssh.ClientConfig, error) {
+	user := nodeUserinfo(node)
+	if user == nil || user.Username() == "" {
+		return nil, fmt.Errorf("节点 %s 缺少 SSH 用户名", node.Name)
+	}
+	config := &ssh.ClientConfig{User: user.Username(), Timeout: sshHandshakeTimeout}
+
+	if node.PrivateKey != "" {
+		signer, err := loadSshPrivateKey(node)
+		if err != nil {
+			return nil, err
+		}
+		config.Auth = append(config.Auth, ssh.PublicKeys(signer))
+	}
+	if pass, ok := user.Password(); ok {
+		config.Auth = append(config.Auth, ssh.Password(pass))
+	}
+	if len(config.Auth) == 0 {
+		return nil, fmt.Errorf("节点 %s 需要配置 SSH 密码或私钥", node.Name)
+	}
+
+	if node.SkipCertVerify {
+		config.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+		return config, nil
+	}
+	path := node.KnownHosts
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("节点 %s 无法定位 known_hosts: %w", node.Name, err)
+		}
+		path = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	callback, err := knownhosts.New(path)
+	if err != nil {
+		return nil, fmt.Errorf("节点 %s 读取 known_hosts 失败: %w", node.Name, err)
+	}
+	config.HostKeyCallback = callback
+	return config, nil
+}
+
+// loadSshPrivateKey 私钥既可以直接填写 PEM 内容，也可以填写文件路径
+func loadSshPrivateKey(node ProxyNode) (ssh.Signer, error) {
+	pemData := []byte(node.PrivateKey)
+	if !strings.Contains(node.PrivateKey, "-----BEGIN") {
+		data, err := os.ReadFile(node.PrivateKey)
+		if err != nil {
+			return nil, fmt.Errorf("节点 %s 读取私钥失败: %w", node.Name, err)
+		}
+		pemData = data
+	}
+	var signer ssh.Signer
+	var err error
+	if node.PrivateKeyPassphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(pemData, []byte(node.PrivateKeyPassphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(pemData)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("节点 %s 解析私钥失败: %w", node.Name, err)
+	}
+	return signer, nil
+}
+
+// Dial 经由 SSH 连接打开 direct-tcpip 通道。连接已失效时丢弃并重连一次；
+// 服务器拒绝打开通道（如目标不可达）属于确定的结果，不重试
+func (d *SshProxyDialer) Dial(network, addr string) (net.Conn, error) {
+	for attempt := 0; ; attempt++ {
+		client, err := d.getClient()
+		if err != nil {
+			return nil, err
+		}
+		conn, err := client.Dial(network, addr)
+		if err == nil {
+			return conn, nil
+		}
+		var openErr *ssh.OpenChannelError
+		if errors.As(err, &openErr) {
+			return nil, &TargetDialError{Err: fmt.Errorf("ssh %s: %w", d.server, err)}
+		}
+		if attempt > 0 {
+			return nil, fmt.Errorf("ssh %s: %w", d.server, err)
+		}
+		d.proxy.Logger.Printf("WARN: 节点 %s 的 SSH 连接失效，重新连接: %v", d.name, err)
+		d.drop(client)
+	}
+}
+
+// getClient 返回可用的 SSH 连接，没有时新建。拨号与握手不持锁，
+// 因此 Close 和 drop 不会被慢速建连阻塞；同一时刻只有一次建连，其余调用方等待其结果
+func (d *SshProxyDialer) getClient() (*ssh.Client, error) {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil, fmt.Errorf("节点 %s 已关闭", d.name)
+	}
+	if d.client != nil {
+		client := d.client
+		d.mu.Unlock()
+		return client, nil
+	}
+	if c := d.connecting; c != nil {
+		d.mu.Unlock()
+		<-c.done
+		return c.client, c.err
+	}
+	c := &sshConnect{done: make(chan struct{})}
+	d.connecting = c
+	d.mu.Unlock()
+
+	c.client, c.err = d.connect()
+
+	d.mu.Lock()
+	d.connecting = nil
+	if c.err == nil && d.closed {
+		// 建连期间节点已被热重载替换
+		c.client.Close()
+		c.client, c.err = nil, fmt.Errorf("节点 %s 已关闭", d.name)
+	}
+	if c.err == nil {
+		d.client = c.client
+	}
+	d.mu.Unlock()
+	close(c.done)
+
+	if c.err == nil {
+		d.proxy.Logger.Printf("INFO: 节点 %s 已建立 SSH 连接 %s", d.name, d.server)
+		client := c.client
+		go d.keepAlive(client)
+		go func() {
+			client.Wait()
+			d.drop(client)
+		}()
+	}
+	return c.client, c.err
+}
+
+// connect 拨号并完成 SSH 握手
+func (d *SshProxyDialer) connect() (*ssh.Client, error) {
+	conn, err := d.proxy.dial(&Pcontext{Req: &http.Request{}, Dialer: d.dial}, "tcp", d.server)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(sshHandshakeTimeout))
+	c, chans, reqs, err := ssh.NewClientConn(conn, d.server, d.config)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("ssh %s 握手失败: %w", d.server, err)
+	}
+	conn.SetDeadline(time.Time{})
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// keepAlive 定期发送 keepalive 请求，及时发现已断开但未收到 FIN 的连接。
+// 请求本身没有超时，链路黑洞时会一直阻塞，因此超过 keepAliveTimeout 未应答即视为失效
+func (d *SshProxyDialer) keepAlive(client *ssh.Client) {
+	ticker := time.NewTicker(d.keepAliveInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		errc := make(chan error, 1)
+		go func() {
+			_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+			errc <- err
+		}()
+		var err error
+		select {
+		case err = <-errc:
+		case <-time.After(d.keepAliveTimeout):
+			err = fmt.Errorf("keepalive %s 内未应答", d.keepAliveTimeout)
+		}
+		if err != nil {
+			d.proxy.Logger.Printf("WARN: 节点 %s 的 SSH 连接失效: %v", d.name, err)
+			d.drop(client) // 关闭连接后阻塞的 SendRequest 随之返回
+			return
+		}
+	}
+}
+
+// drop 关闭失效的连接，client 已被替换时不做处理
+func (d *SshProxyDialer) drop(client *ssh.Client) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.client == client {
+		d.client = nil
+	}
+	client.Close()
+}
+
+// Close 关闭 SSH 连接，配置热重载替换拨号器时调用
+func (d *SshProxyDialer) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.closed = true
+	if d.client != nil {
+		d.client.Close()
+		d.client = nil
+	}
+	return nil
+}
+
+func (d *SshProxyDialer) Name() string { return d.name }
+
+func (d *SshProxyDialer) GetTransport() *http.Transport {
+	return d.transport
+}
@@ -0,0 +1,300 @@
+package mproxy
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshTestServer 进程内 SSH 服务器，只支持 direct-tcpip 通道
+type sshTestServer struct {
+	addr     string
+	hostKey  ssh.PublicKey
+	accepted atomic.Int32 // 完成握手的连接数
+	mute     atomic.Bool  // 为 true 时不应答全局请求（如 keepalive），模拟链路黑洞
+
+	mu    sync.Mutex
+	conns []*ssh.ServerConn
+}
+
+// startSshServer 启动 SSH 服务器，接受密码 alice/s3cret 或 clientKey 对应的公钥
+func startSshServer(t *testing.T, clientKey ssh.PublicKey) *sshTestServer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == "alice" && string(pass) == "s3cret" {
+				return nil, nil
+			}
+			return nil, io.ErrUnexpectedEOF
+		},
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if clientKey != nil && string(key.Marshal()) == string(clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, io.ErrUnexpectedEOF
+		},
+	}
+	config.AddHostKey(signer)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	s := &sshTestServer{addr: ln.Addr().String(), hostKey: signer.PublicKey()}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c, config)
+		}
+	}()
+	return s
+}
+
+func (s *sshTestServer) serve(c net.Conn, config *ssh.ServerConfig) {
+	sconn, chans, reqs, err := ssh.NewServerConn(c, config)
+	if err != nil {
+		c.Close()
+		return
+	}
+	s.accepted.Add(1)
+	s.mu.Lock()
+	s.conns = append(s.conns, sconn)
+	s.mu.Unlock()
+	go func() {
+		for req := range reqs {
+			if req.WantReply && !s.mute.Load() {
+				req.Reply(false, nil)
+			}
+		}
+	}()
+	for nc := range chans {
+		if nc.ChannelType() != "direct-tcpip" {
+			nc.Reject(ssh.UnknownChannelType, "only direct-tcpip")
+			continue
+		}
+		var payload struct {
+			Host       string
+			Port       uint32
+			OriginHost string
+			OriginPort uint32
+		}
+		if err := ssh.Unmarshal(nc.ExtraData(), &payload); err != nil {
+			nc.Reject(ssh.Prohibited, err.Error())
+			continue
+		}
+		target, err := net.Dial("tcp", net.JoinHostPort(payload.Host, strconv.Itoa(int(payload.Port))))
+		if err != nil {
+			nc.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+		ch, chReqs, err := nc.Accept()
+		if err != nil {
+			target.Close()
+			continue
+		}
+		go ssh.DiscardRequests(chReqs)
+		go func() {
+			io.Copy(ch, target)
+			ch.Close()
+		}()
+		go func() {
+			io.Copy(target, ch)
+			target.Close()
+		}()
+	}
+}
+
+// kill 断开所有已建立的 SSH 连接，模拟网络中断
+func (s *sshTestServer) kill() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.conns = nil
+}
+
+// writeKnownHosts 生成只包含 server 主机密钥的 known_hosts 文件
+func writeKnownHosts(t *testing.T, s *sshTestServer) string {
+	path := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(s.addr)}, s.hostKey)
+	require.NoError(t, os.WriteFile(path, []byte(line+"\n"), 0600))
+	return path
+}
+
+func TestSshProxyDialer_PasswordAndReconnect(t *testing.T) {
+	echo := startEchoServer(t)
+	server := startSshServer(t, nil)
+
+	proxy := NewCoreHttpSever()
+	d, err := NewSshProxyDialer(proxy, ProxyNode{
+		Name:       "bastion",
+		URL:        "ssh://alice:s3cret@" + server.addr,
+		KnownHosts: writeKnownHosts(t, server),
+	})
+	require.NoError(t, err)
+	defer d.Close()
+
+	for range 3 {
+		conn, err := d.Dial("tcp", echo)
+		require.NoError(t, err)
+		assertEcho(t, conn)
+		conn.Close()
+	}
+	assert.EqualValues(t, 1, server.accepted.Load(), "多次 Dial 复用同一条 SSH 连接")
+
+	// 连接中断后下一次 Dial 自动重连
+	server.kill()
+	conn, err := d.Dial("tcp", echo)
+	require.NoError(t, err)
+	assertEcho(t, conn)
+	conn.Close()
+	assert.EqualValues(t, 2, server.accepted.Load())
+
+	// 目标不可达由服务器拒绝通道，不触发重连
+	_, err = d.Dial("tcp", "127.0.0.1:1")
+	var openErr *ssh.OpenChannelError
+	assert.ErrorAs(t, err, &openErr)
+	assert.EqualValues(t, 2, server.accepted.Load())
+
+	// MITM 流量使用节点自己的 Transport
+	assert.NotNil(t, d.GetTransport().DialContext)
+}
+
+func TestSshProxyDialer_PrivateKeyAndHostKey(t *testing.T) {
+	echo := startEchoServer(t)
+	_, clientPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	clientSigner, err := ssh.NewSignerFromKey(clientPriv)
+	require.NoError(t, err)
+	block, err := ssh.MarshalPrivateKey(clientPriv, "")
+	require.NoError(t, err)
+	server := startSshServer(t, clientSigner.PublicKey())
+
+	router := NewRouter(NewCoreHttpSever())
+	require.NoError(t, router.ReloadFromConfig(&ServerConfig{ProxyNodes: []ProxyNode{{
+		Name:       "bastion",
+		URL:        "ssh://deploy@" + server.addr,
+		PrivateKey: string(pem.EncodeToMemory(block)),
+		KnownHosts: writeKnownHosts(t, server),
+	}}}))
+	conn, err := router.Dialers["bastion"].Dial("tcp", echo)
+	require.NoError(t, err)
+	assertEcho(t, conn)
+	conn.Close()
+
+	// 主机密钥与 known_hosts 不符时拒绝连接
+	other := startSshServer(t, nil)
+	d, err := NewSshProxyDialer(NewCoreHttpSever(), ProxyNode{
+		Name:       "spoofed",
+		URL:        "ssh://alice:s3cret@" + other.addr,
+		KnownHosts: writeKnownHosts(t, &sshTestServer{addr: other.addr, hostKey: server.hostKey}),
+	})
+	require.NoError(t, err)
+	_, err = d.Dial("tcp", echo)
+	var keyErr *knownhosts.KeyError
+	assert.ErrorAs(t, err, &keyErr)
+
+	_, err = NewSshProxyDialer(NewCoreHttpSever(), ProxyNode{Name: "nouser", URL: "ssh://" + server.addr, SkipCertVerify: true})
+	assert.Error(t, err)
+}
+
+func TestSshProxyDialer_CloseDuringHandshake(t *testing.T) {
+	// 只接受 TCP 连接、从不发送 SSH 版本号的服务器，握手会一直等到超时
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	var accepted atomic.Int32
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			defer c.Close()
+		}
+	}()
+
+	d, err := NewSshProxyDialer(NewCoreHttpSever(), ProxyNode{
+		Name: "stuck", URL: "ssh://alice:pw@" + ln.Addr().String(), SkipCertVerify: true,
+	})
+	require.NoError(t, err)
+
+	errs := make(chan error, 2)
+	for range 2 {
+		go func() {
+			_, err := d.Dial("tcp", "127.0.0.1:80")
+			errs <- err
+		}()
+	}
+	require.Eventually(t, func() bool { return accepted.Load() == 1 }, 2*time.Second, 10*time.Millisecond)
+
+	// 握手进行中 Close 立即返回，并发的 Dial 共用同一次建连
+	closed := make(chan struct{})
+	go func() {
+		d.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close 被进行中的握手阻塞")
+	}
+	time.Sleep(50 * time.Millisecond)
+	assert.EqualValues(t, 1, accepted.Load())
+	ln.Close()
+}
+
+func TestSshProxyDialer_KeepAliveTimeout(t *testing.T) {
+	oldInterval, oldTimeout := sshKeepAliveInterval, sshKeepAliveTimeout
+	sshKeepAliveInterval, sshKeepAliveTimeout = 50*time.Millisecond, 100*time.Millisecond
+	t.Cleanup(func() { sshKeepAliveInterval, sshKeepAliveTimeout = oldInterval, oldTimeout })
+
+	echo := startEchoServer(t)
+	server := startSshServer(t, nil)
+	d, err := NewSshProxyDialer(NewCoreHttpSever(), ProxyNode{
+		Name: "bastion", URL: "ssh://alice:s3cret@" + server.addr, SkipCertVerify: true,
+	})
+	require.NoError(t, err)
+	defer d.Close()
+
+	conn, err := d.Dial("tcp", echo)
+	require.NoError(t, err)
+	conn.Close()
+
+	// 服务器不再应答 keepalive，超时后丢弃连接，下一次 Dial 重新建连
+	server.mute.Store(true)
+	require.Eventually(t, func() bool {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		return d.client == nil
+	}, 2*time.Second, 10*time.Millisecond)
+	server.mute.Store(false)
+
+	conn, err = d.Dial("tcp", echo)
+	require.NoError(t, err)
+	assertEcho(t, conn)
+	conn.Close()
+	assert.EqualValues(t, 2, server.accepted.Load())
+}
@@ -3,10 +3,16 @@ package mproxy
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
 	"regexp"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -24,18 +30,21 @@ func extractHost(req *http.Request) string {
 	return strings.ToLower(host)
 }
 
-// createBaseTransport 创建基础 Transport，每个出站节点持有独立实例，实现连接池隔离
-func createBaseTransport() *http.Transport {
-	return &http.Transport{
+// createBaseTransport 创建基础 Transport，每个出站节点持有独立实例，实现连接池隔离。
+// 连接池大小取自 opts，零值字段使用默认值
+func createBaseTransport(opts DialOptions) *http.Transport {
+	tr := &http.Transport{
 		TLSClientConfig:       &tls.Config{InsecureSkipVerify: true},
-		MaxIdleConns:          300,
-		MaxIdleConnsPerHost:   10,
-		IdleConnTimeout:       90 * time.Second,
 		TLSHandshakeTimeout:   10 * time.Second,
 		ExpectContinueTimeout: 2 * time.Second,
 	}
+	opts.applyTransport(tr)
+	return tr
 }
 
+// dialContextFunc 建立到代理服务器的连接，签名与 Pcontext.Dialer 相同
+type dialContextFunc = func(ctx context.Context, network, addr string) (net.Conn, error)
+
 // ======================== OutboundDialer 接口 ========================
 
 // OutboundDialer 出站拨号器接口，用于路由到不同的代理节点
@@ -45,19 +54,41 @@ type OutboundDialer interface {
 	GetTransport() *http.Transport
 }
 
+// UDPDialer 可选接口，实现者可以承载 UDP 流量（SOCKS5 UDP ASSOCIATE）。
+// 每个 NAT 会话调用一次 DialUDP，得到面向单个目标的数据报连接
+type UDPDialer interface {
+	DialUDP(network, addr string) (net.Conn, error)
+}
+
 // DirectDialer 直连拨号器
 type DirectDialer struct {
+	dialer    *netDialer
 	transport *http.Transport
 }
 
-func NewDirectDialer() *DirectDialer {
-	return &DirectDialer{
-		transport: createBaseTransport(),
+// NewDirectDialer 创建使用默认拨号参数的直连拨号器，域名经由 resolver 解析，resolver 为 nil 时使用系统解析器
+func NewDirectDialer(resolver *Resolver) *DirectDialer {
+	d, _ := NewDirectDialerWithOptions(resolver, DialOptions{})
+	return d
+}
+
+// NewDirectDialerWithOptions 创建直连拨号器，隧道拨号和 Transport 使用同一组拨号参数
+func NewDirectDialerWithOptions(resolver *Resolver, opts DialOptions) (*DirectDialer, error) {
+	nd, err := newNetDialer(resolver, opts)
+	if err != nil {
+		return nil, err
 	}
+	tr := createBaseTransport(opts)
+	tr.DialContext = nd.DialContext
+	return &DirectDialer{dialer: nd, transport: tr}, nil
 }
 
 func (d *DirectDialer) Dial(network, addr string) (net.Conn, error) {
-	return net.Dial(network, addr)
+	return d.dialer.DialContext(context.Background(), network, addr)
+}
+
+func (d *DirectDialer) DialUDP(network, addr string) (net.Conn, error) {
+	return d.dialer.DialContext(context.Background(), network, addr)
 }
 
 func (d *DirectDialer) Name() string { return "Direct" }
@@ -66,7 +97,7 @@ func (d *DirectDialer) GetTransport() *http.Transport {
 	return d.transport
 }
 
-// HttpProxyDialer HTTP 二级代理拨号器
+// HttpProxyDialer HTTP/HTTPS 二级代理拨号器
 type HttpProxyDialer struct {
 	name      string
 	proxyURL  string
@@ -74,17 +105,29 @@ type HttpProxyDialer struct {
 	transport *http.Transport
 }
 
-// NewHttpProxyDialer 创建 HTTP 二级代理拨号器，复用 CoreHttpServer.NewConnectDialToProxy
-func NewHttpProxyDialer(proxy *CoreHttpServer, name, proxyURL string) (*HttpProxyDialer, error) {
-	dialer := proxy.NewConnectDialToProxy(proxyURL)
+// NewHttpProxyDialer 创建 HTTP/HTTPS 二级代理拨号器，复用 CoreHttpServer.NewConnectDialToProxyWithTLS
+func NewHttpProxyDialer(proxy *CoreHttpServer, node ProxyNode) (*HttpProxyDialer, error) {
+	return newHttpProxyDialer(proxy, node, nil, DialOptions{})
+}
+
+func newHttpProxyDialer(proxy *CoreHttpServer, node ProxyNode, dial dialContextFunc, opts DialOptions) (*HttpProxyDialer, error) {
+	var tlsConfig *tls.Config
+	if strings.HasPrefix(node.URL, "https://") {
+		var err error
+		if tlsConfig, err = buildNodeTLSConfig(node); err != nil {
+			return nil, err
+		}
+	}
+	// 认证信息注入 CONNECT 请求。Transport 对明文 HTTP 请求同样先 CONNECT 建隧道，因此使用同一份凭据
+	dialer := proxy.newConnectDialToProxy(node.URL, tlsConfig, nodeConnectReqHandler(node), dial)
 	if dialer == nil {
-		return nil, fmt.Errorf("无效的代理 URL: %s (仅支持 HTTP scheme)", proxyURL)
+		return nil, fmt.Errorf("无效的代理 URL: %s (仅支持 HTTP/HTTPS scheme)", node.URL)
 	}
-	tr := createBaseTransport()
+	tr := createBaseTransport(opts)
 	tr.DialContext = func(c context.Context, network, addr string) (net.Conn, error) {
 		return dialer(network, addr)
 	}
-	return &HttpProxyDialer{name: name, proxyURL: proxyURL, dialer: dialer, transport: tr}, nil
+	return &HttpProxyDialer{name: node.Name, proxyURL: node.URL, dialer: dialer, transport: tr}, nil
 }
 
 func (d *HttpProxyDialer) Dial(network, addr string) (net.Conn, error) {
@@ -97,12 +140,135 @@ func (d *HttpProxyDialer) GetTransport() *http.Transport {
 	return d.transport
 }
 
+// NewOutboundDialer 根据节点 URL 的 scheme 创建对应的出站拨号器。
+// 节点的 Via 和 Dial 由 ReloadFromConfig 处理，这里创建的拨号器以默认参数直接连接代理服务器
+func NewOutboundDialer(proxy *CoreHttpServer, node ProxyNode) (OutboundDialer, error) {
+	return newOutboundDialer(proxy, node, nil, DialOptions{})
+}
+
+// newOutboundDialer 同 NewOutboundDialer，dial 非 nil 时由它建立到代理服务器的连接（链式代理的前一跳或带拨号参数的直连），
+// opts 决定节点 Transport 的连接池大小
+func newOutboundDialer(proxy *CoreHttpServer, node ProxyNode, dial dialContextFunc, opts DialOptions) (OutboundDialer, error) {
+	u, err := url.Parse(node.URL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "", "http", "https":
+		return newHttpProxyDialer(proxy, node, dial, opts)
+	case "socks5", "socks5h":
+		return newSocks5ProxyDialer(proxy, node, dial, opts)
+	case "ssh":
+		return newSshProxyDialer(proxy, node, dial, opts)
+	case "ss":
+		return newShadowsocksDialer(proxy, node, dial, opts)
+	default:
+		return nil, fmt.Errorf("不支持的代理协议 %s: %s", u.Scheme, node.URL)
+	}
+}
+
+// validateProxyNode 只检查节点 URL 和凭据是否完整，不读取 CA、私钥或 known_hosts 文件，也不创建拨号器。
+// 文件类错误要到 ReloadFromConfig 真正创建拨号器时才会发现
+func validateProxyNode(node ProxyNode) error {
+	u, err := url.Parse(node.URL)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "", "http", "https", "socks5", "socks5h":
+		return nil
+	case "ssh":
+		user := nodeUserinfo(node)
+		if user == nil || user.Username() == "" {
+			return fmt.Errorf("节点 %s 缺少 SSH 用户名", node.Name)
+		}
+		if _, ok := user.Password(); !ok && node.PrivateKey == "" {
+			return fmt.Errorf("节点 %s 需要配置 SSH 密码或私钥", node.Name)
+		}
+		return nil
+	case "ss":
+		method, password, _, err := parseSSURL(node.URL)
+		if err != nil {
+			return err
+		}
+		if _, err := newSSCipher(method, password); err != nil {
+			return fmt.Errorf("节点 %s: %w", node.Name, err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("不支持的代理协议 %s: %s", u.Scheme, node.URL)
+	}
+}
+
+// nodeUserinfo 返回节点的认证信息，显式的 Username/Password 优先于 URL 中的 user:pass
+func nodeUserinfo(node ProxyNode) *url.Userinfo {
+	if node.Username != "" || node.Password != "" {
+		return url.UserPassword(node.Username, node.Password)
+	}
+	if u, err := url.Parse(node.URL); err == nil && u.User != nil {
+		return u.User
+	}
+	return nil
+}
+
+// nodeConnectReqHandler 为 CONNECT 请求注入 Proxy-Authorization 和自定义头部，无需注入时返回 nil
+func nodeConnectReqHandler(node ProxyNode) func(req *http.Request) {
+	user := nodeUserinfo(node)
+	if user == nil && len(node.Headers) == 0 {
+		return nil
+	}
+	var auth string
+	if user != nil {
+		pass, _ := user.Password()
+		auth = "Basic " + base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+pass))
+	}
+	return func(req *http.Request) {
+		for k, v := range node.Headers {
+			req.Header.Set(k, v)
+		}
+		if auth != "" {
+			req.Header.Set("Proxy-Authorization", auth)
+		}
+	}
+}
+
+// buildNodeTLSConfig 构建 proxy→二级加密代理 这一跳的 TLS 配置（SNI、证书校验、自定义 CA）
+func buildNodeTLSConfig(node ProxyNode) (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         node.SNI,
+		InsecureSkipVerify: node.SkipCertVerify,
+	}
+	if node.CA == "" {
+		return cfg, nil
+	}
+	// CA 既可以直接填写 PEM 内容，也可以填写 PEM 文件路径
+	pemData := []byte(node.CA)
+	if !strings.Contains(node.CA, "-----BEGIN") {
+		data, err := os.ReadFile(node.CA)
+		if err != nil {
+			return nil, fmt.Errorf("节点 %s 读取 CA 文件失败: %w", node.Name, err)
+		}
+		pemData = data
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemData) {
+		return nil, fmt.Errorf("节点 %s 的 CA 中没有有效的 PEM 证书", node.Name)
+	}
+	cfg.RootCAs = pool
+	return cfg, nil
+}
+
 // ======================== Router 路由引擎 ========================
 
 // RoutingRule 路由规则，包含条件和目标拨号器名称
 type RoutingRule struct {
+	Id        int // 对应 RouteRule.Id，代码添加的规则为 0
 	Condition ReqCondition
 	Target    string
+	Layer7    bool        // 条件依赖完整 HTTP 请求，隧道模式下跳过
+	Window    *ruleWindow // 生效时间窗口，nil 表示始终生效
+
+	stats *ruleCounter // 命中统计，代码添加的规则为 nil
 }
 
 // Router 路由引擎，根据规则将请求分发到不同的出站拨号器
@@ -112,6 +278,15 @@ type Router struct {
 	Dialers map[string]OutboundDialer
 	Rules   []RoutingRule
 	Default OutboundDialer
+	Health  *HealthChecker // 节点健康检查，随 ReloadFromConfig 热更新
+
+	// Now 时间源，用于判断规则的生效时间窗口，测试时可替换
+	Now func() time.Time
+
+	geoip     *GeoIPDatabase              // GEOIP 规则使用的数据库，未配置时为 nil
+	providers map[string]*RuleSetProvider // RULE-SET 规则引用的规则集
+	index     *domainIndex                // 纯域名规则的合并索引，与 Rules 同时替换
+	stats     *routeStats                 // 规则命中统计，跨热重载保留
 }
 
 // NewRouter 创建路由引擎
@@ -119,7 +294,10 @@ func NewRouter(proxy *CoreHttpServer) *Router {
 	return &Router{
 		proxy:   proxy,
 		Dialers: make(map[string]OutboundDialer),
-		Default: NewDirectDialer(),
+		Default: NewDirectDialer(proxy.Resolver),
+		Health:  NewHealthChecker(proxy),
+		Now:     time.Now,
+		stats:   newRouteStats(),
 	}
 }
 
@@ -137,35 +315,115 @@ func (r *Router) AddRule(condition ReqCondition, target string) {
 	r.Rules = append(r.Rules, RoutingRule{Condition: condition, Target: target})
 }
 
-// MatchRoute 路由匹配纯计算函数，不执行拨号操作
+// MatchRoute 路由匹配纯计算函数，不执行拨号操作，命中计入路由统计
 // 返回：目标名称、对应的拨号器
 func (r *Router) MatchRoute(req *http.Request) (string, OutboundDialer) {
+	target, dialer, _ := r.route(req)
+	return target, dialer
+}
+
+// route 匹配并记录命中，返回的 routeHit 用于累加后续传输的字节数
+func (r *Router) route(req *http.Request) (string, OutboundDialer, *routeHit) {
+	target, dialer, rule := r.match(req, nil)
+	return target, dialer, r.stats.record(rule, target, r.Now())
+}
+
+// match 按顺序评估规则，返回目标名称、拨号器和命中的规则（未命中为 nil）。
+// trace 非 nil 时记录每条规则的评估过程（供 Explain 使用），此时不打印匹配日志。
+// 目标是 Fake-IP 假地址时按还原出的域名匹配
+func (r *Router) match(req *http.Request, trace *[]RuleTrace) (string, OutboundDialer, *RoutingRule) {
+	if restored := r.proxy.Resolver.restoreFakeIPRequest(req); restored != nil {
+		req = restored
+	}
 	ctx := &Pcontext{Req: req, core_proxy: r.proxy}
 
 	r.mu.RLock()
 	rules := r.Rules
 	dialers := r.Dialers
 	defaultDialer := r.Default
+	index := r.index
 	r.mu.RUnlock()
 
-	for _, rule := range rules {
-		if rule.Condition.HandleReq(req, ctx) {
+	// 试运行需要逐条记录评估过程，不走索引
+	var cands []int
+	if trace != nil {
+		index = nil
+	} else if index != nil {
+		cands = index.candidates(extractHost(req))
+	}
+
+	record := func(rule *RoutingRule, result, reason string) {
+		if trace != nil {
+			*trace = append(*trace, RuleTrace{Id: rule.Id, Target: rule.Target, Result: result, Reason: reason})
+		}
+	}
+	logf := func(format string, args ...any) {
+		if trace == nil {
+			r.proxy.Logger.Printf(format, args...)
+		}
+	}
+
+	tunnel := isTunnelRequest(req)
+	now := r.Now()
+	for i := 0; i < len(rules); i++ {
+		rule := &rules[i]
+		indexed := index.covers(i)
+		if indexed {
+			if _, hit := slices.BinarySearch(cands, i); !hit {
+				// 索引判定未命中，直接跳到下一个候选或下一条需要逐条评估的规则
+				i = index.skip(i, cands) - 1
+				continue
+			}
+		}
+		if rule.Window != nil && !rule.Window.Contains(now) {
+			record(rule, TraceSkipped, "不在生效时间窗口内")
+			continue // 时间窗口外视同未启用
+		}
+		if rule.Layer7 && tunnel {
+			// 热路径不打印日志，ReloadFromConfig 已统一提示这类规则
+			record(rule, TraceSkipped, "隧道模式无法判断七层条件")
+			continue
+		}
+		if indexed || rule.Condition.HandleReq(req, ctx) {
+			if r.Health.SkipUnhealthy() && !r.Health.IsHealthy(rule.Target) {
+				record(rule, TraceSkipped, "目标节点健康检查不可用")
+				logf("WARN: [路由匹配] 目标节点 '%s' 健康检查不可用，跳过该规则", rule.Target)
+				continue
+			}
 			if dialer, ok := dialers[rule.Target]; ok {
-				return rule.Target, dialer
+				record(rule, TraceMatched, "")
+				return rule.Target, dialer, rule
 			}
-			r.proxy.Logger.Printf("WARN: [路由匹配] 目标节点 '%s' 不存在 -> Direct", rule.Target)
-			break
+			record(rule, TraceMatched, "目标节点不存在，回退 Direct")
+			logf("WARN: [路由匹配] 目标节点 '%s' 不存在 -> Direct", rule.Target)
+			return "Direct", defaultDialer, rule
 		}
+		record(rule, TraceNotMatched, "")
 	}
-	return "Direct", defaultDialer
+	return "Direct", defaultDialer, nil
 }
 
 // RouteDial 路由分发函数，签名兼容 ConnectWithReqDial
-// 隧道透传模式专用入口（不经过 RoundTrip，必须在此打印日志）
+// 隧道透传模式专用入口（不经过 RoundTrip，必须在此打印日志）。network 为 udp 时要求出站实现 UDPDialer
 func (r *Router) RouteDial(req *http.Request, network, addr string) (net.Conn, error) {
-	target, dialer := r.MatchRoute(req)
+	target, dialer, hit := r.route(req)
+	addr, _ = r.proxy.Resolver.RestoreFakeIP(addr)
 	r.proxy.Logger.Printf("INFO: [路由匹配] %s -> %s", addr, target)
-	return dialer.Dial(network, addr)
+	var conn net.Conn
+	var err error
+	if strings.HasPrefix(network, "udp") {
+		ud, ok := dialer.(UDPDialer)
+		if !ok {
+			return nil, fmt.Errorf("出站 %s 不支持 UDP", target)
+		}
+		conn, err = ud.DialUDP(network, addr)
+	} else {
+		conn, err = dialer.Dial(network, addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return hit.wrapConn(conn), nil
 }
 
 // ReloadFromConfig 从配置热重载路由规则（线程安全）
@@ -174,10 +432,25 @@ func (r *Router) ReloadFromConfig(cfg *ServerConfig) error {
 	// === 锁外构建（耗时操作不持锁）===
 
 	// 1. 构建拨号器
-	directDialer := NewDirectDialer()
+	dialOpts := cfg.Dial
+	directDialer, err := NewDirectDialerWithOptions(r.proxy.Resolver, dialOpts)
+	if err != nil {
+		r.proxy.Logger.Printf("WARN: 全局拨号参数无效，使用默认值: %v", err)
+		dialOpts = DialOptions{}
+		directDialer = NewDirectDialer(r.proxy.Resolver)
+	}
 	newDialers := map[string]OutboundDialer{"Direct": directDialer}
+	for _, name := range []string{RejectTarget, RejectDropTarget} {
+		d, _ := NewRejectDialer(name)
+		newDialers[name] = d
+	}
+	chains := newChainPlan(cfg, dialOpts)
 	for _, node := range cfg.ProxyNodes {
-		dialer, err := NewHttpProxyDialer(r.proxy, node.Name, node.URL)
+		if _, reserved := newDialers[node.Name]; reserved || isRejectTarget(node.Name) {
+			r.proxy.Logger.Printf("WARN: 节点名 %s 为内置目标保留名，跳过", node.Name)
+			continue
+		}
+		dialer, err := chains.newDialer(r.proxy, node)
 		if err != nil {
 			r.proxy.Logger.Printf("WARN: 节点 %s 创建失败: %v", node.Name, err)
 			continue
@@ -185,84 +458,285 @@ func (r *Router) ReloadFromConfig(cfg *ServerConfig) error {
 		newDialers[node.Name] = dialer
 	}
 
+	// 健康检查只探测具体节点，代理组通过成员的检查结果间接感知
+	nodeDialers := make(map[string]OutboundDialer, len(newDialers))
+	for name, d := range newDialers {
+		if name != "Direct" && !isRejectTarget(name) {
+			nodeDialers[name] = d
+		}
+	}
+	r.Health.Reload(cfg.HealthCheck, nodeDialers)
+
+	// 代理组按声明顺序构建，成员只能引用节点或之前声明的组
+	groups := 0
+	for _, group := range cfg.ProxyGroups {
+		if _, exists := newDialers[group.Name]; exists {
+			r.proxy.Logger.Printf("WARN: 代理组 %s 与已有节点重名，跳过", group.Name)
+			continue
+		}
+		members := make([]OutboundDialer, 0, len(group.Proxies))
+		for _, name := range group.Proxies {
+			member, ok := newDialers[name]
+			if !ok {
+				r.proxy.Logger.Printf("WARN: 代理组 %s 的成员 '%s' 不存在，跳过", group.Name, name)
+				continue
+			}
+			members = append(members, member)
+		}
+		dialer, err := NewProxyGroupDialer(r.proxy, group, members, r.Health)
+		if err != nil {
+			r.proxy.Logger.Printf("WARN: 代理组 %s 创建失败: %v", group.Name, err)
+			continue
+		}
+		dialOpts.applyTransport(dialer.GetTransport())
+		newDialers[group.Name] = dialer
+		groups++
+	}
+	// 链式代理的 Via 可以引用任意节点或代理组，全部构建完成后再绑定
+	chains.bind(r.proxy, newDialers)
+
+	// GeoIP 数据库路径不变时沿用已加载的实例（文件内容变化由其自身监视并重载）
+	env := &ruleBuildEnv{}
+	r.mu.RLock()
+	oldGeoIP := r.geoip
+	oldProviders := r.providers
+	r.mu.RUnlock()
+	switch {
+	case cfg.GeoIPDatabase == "":
+	case oldGeoIP != nil && oldGeoIP.Path() == cfg.GeoIPDatabase:
+		env.geoip = oldGeoIP
+	default:
+		db, err := OpenGeoIPDatabase(r.proxy, cfg.GeoIPDatabase)
+		if err != nil {
+			r.proxy.Logger.Printf("WARN: GeoIP 数据库加载失败，GEOIP 规则将被跳过: %v", err)
+		} else {
+			env.geoip = db
+		}
+	}
+
+	// 规则集配置未变化时沿用旧实例，避免每次保存配置都重新下载
+	env.providers = make(map[string]*RuleSetProvider, len(cfg.RuleProviders))
+	for _, pc := range cfg.RuleProviders {
+		if _, exists := env.providers[pc.Name]; exists {
+			r.proxy.Logger.Printf("WARN: 规则集 %s 重名，跳过", pc.Name)
+			continue
+		}
+		if old, ok := oldProviders[pc.Name]; ok && old.cfg == pc {
+			env.providers[pc.Name] = old
+			continue
+		}
+		provider, err := NewRuleSetProvider(r.proxy, pc)
+		if err != nil {
+			r.proxy.Logger.Printf("WARN: 规则集 %s 加载失败: %v", pc.Name, err)
+			continue
+		}
+		env.providers[pc.Name] = provider
+	}
+
 	// 2. 构建规则（Action 直接是拨号器名称）
 	newRules := make([]RoutingRule, 0, len(cfg.Routes))
+	compiled := make([]RouteRule, 0, len(cfg.Routes)) // 与 newRules 一一对应，用于构建域名索引
 	for _, route := range cfg.Routes {
 		if !route.Enable {
 			continue
 		}
 
-		rawValues := strings.Split(route.Value, ",")
-		var values []string
-		for _, v := range rawValues {
-			v = strings.TrimSpace(v)
-			if v != "" {
-				values = append(values, v)
-			}
-		}
-		if len(values) == 0 {
+		if !isCompositeRule(route.Type) && len(splitRuleValues(route)) == 0 {
 			continue
 		}
 
-		var condition ReqCondition
-		switch route.Type {
-		case "DomainSuffix":
-			condition = DomainSuffixRule(values...)
-		case "DomainKeyword":
-			condition = DomainKeywordRule(values...)
-		case "IP":
-			condition = IPRule(values...)
-		default:
-			r.proxy.Logger.Printf("WARN: 未知规则类型 %s", route.Type)
+		window, err := compileSchedule(route.Schedule)
+		if err != nil {
+			r.proxy.Logger.Printf("WARN: 规则 #%d 生效时间配置无效，跳过: %v", route.Id, err)
 			continue
 		}
-		// 验证目标拨号器存在
+		condition, errs := compileRule(route, env, "")
+		if len(errs) > 0 {
+			// 复合规则的每个子规则错误单独输出
+			for _, err := range errs {
+				r.proxy.Logger.Printf("WARN: 规则 #%d 无效，跳过: %v", route.Id, err)
+			}
+			continue
+		}
+		// 验证目标拨号器存在，Reject-With-Status:<code> 按需创建
+		if _, ok := newDialers[route.Action]; !ok && (isRejectTarget(route.Action) || isRejectWithStatus(route.Action)) {
+			d, err := NewRejectDialer(route.Action)
+			if err != nil {
+				r.proxy.Logger.Printf("WARN: 规则 #%d 无效，跳过: %v", route.Id, err)
+				continue
+			}
+			newDialers[route.Action] = d
+		}
 		if _, ok := newDialers[route.Action]; !ok {
 			r.proxy.Logger.Printf("WARN: 规则目标 '%s' 对应的节点不存在，跳过", route.Action)
 			continue
 		}
-		newRules = append(newRules, RoutingRule{Condition: condition, Target: route.Action})
+		newRules = append(newRules, RoutingRule{
+			Id:        route.Id,
+			Condition: condition,
+			Target:    route.Action,
+			Layer7:    ruleNeedsLayer7(route),
+			Window:    window,
+			stats:     r.stats.rule(route.Id),
+		})
+		compiled = append(compiled, route)
+	}
+	index := buildDomainIndex(compiled)
+	var layer7 []string
+	for _, rule := range newRules {
+		if rule.Layer7 {
+			layer7 = append(layer7, fmt.Sprintf("#%d", rule.Id))
+		}
+	}
+	if len(layer7) > 0 {
+		r.proxy.Logger.Printf("INFO: 规则 %s 需要 MITM 解密后的请求内容，隧道模式下会被跳过", strings.Join(layer7, ", "))
 	}
 
 	// === 锁内原子替换（默认行为始终直连）===
 	r.mu.Lock()
+	oldDialers := r.Dialers
 	r.Dialers = newDialers
 	r.Rules = newRules
+	r.index = index
 	r.Default = directDialer
+	r.geoip = env.geoip
+	r.providers = env.providers
 	r.mu.Unlock()
+	r.stats.retain(newRules)
+
+	for name, p := range oldProviders {
+		if env.providers[name] != p {
+			p.Close()
+		}
+	}
+
+	if oldGeoIP != nil && oldGeoIP != env.geoip {
+		oldGeoIP.Close()
+	}
 
-	r.proxy.Logger.Printf("INFO: 配置已热重载，路由已热重载，%d 条规则，%d 个节点", len(newRules), len(newDialers)-1)
+	// 释放旧拨号器持有的后台任务（如代理组测速）
+	for _, d := range oldDialers {
+		if c, ok := d.(io.Closer); ok {
+			c.Close()
+		}
+	}
+
+	r.proxy.Logger.Printf("INFO: 配置已热重载，路由已热重载，%d 条规则，%d 个节点，%d 个代理组", len(newRules), len(nodeDialers), groups)
 	return nil
 }
 
 // ======================== 规则构建函数 ========================
 
-// DomainSuffixRule 域名后缀匹配规则（自动剥离端口）
-func DomainSuffixRule(suffixes ...string) ReqConditionFunc {
-	for i, s := range suffixes {
-		suffixes[i] = strings.ToLower(s)
+// splitRuleValues 按逗号拆分规则值并去除空项，正则类规则整体作为一个值
+func splitRuleValues(route RouteRule) []string {
+	if rawValueRuleTypes[route.Type] {
+		if v := strings.TrimSpace(route.Value); v != "" {
+			return []string{v}
+		}
+		return nil
 	}
-	return func(req *http.Request, ctx *Pcontext) bool {
-		host := extractHost(req)
-		for _, suffix := range suffixes {
-			if host == suffix || strings.HasSuffix(host, "."+suffix) {
-				return true
+	var values []string
+	for _, v := range strings.Split(route.Value, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// ruleBuildEnv 构建规则时依赖的外部资源
+type ruleBuildEnv struct {
+	geoip     *GeoIPDatabase
+	providers map[string]*RuleSetProvider
+}
+
+// buildRuleCondition 根据规则类型构建匹配条件，values 为已按逗号拆分的非空值
+func buildRuleCondition(route RouteRule, values []string, env *ruleBuildEnv) (ReqCondition, error) {
+	switch route.Type {
+	case "DomainSuffix":
+		return DomainSuffixRule(values...), nil
+	case "DomainKeyword":
+		return DomainKeywordRule(values...), nil
+	case "IP":
+		return IPRule(values...), nil
+	case "IP-CIDR", "IP-CIDR6":
+		if err := checkCIDRFamily(route.Type, values); err != nil {
+			return nil, err
+		}
+		return IPCIDRRule(!route.NoResolve, values...)
+	case "DST-PORT":
+		return DstPortRule(values...)
+	case "SRC-PORT":
+		return SrcPortRule(values...)
+	case "IN-PORT":
+		return InPortRule(values...)
+	case "SRC-IP-CIDR":
+		return SrcIPCIDRRule(values...)
+	case "URL-REGEX":
+		return URLRegexRule(values...)
+	case "PATH-PREFIX":
+		return PathPrefixRule(values...), nil
+	case "METHOD":
+		return MethodRule(values...), nil
+	case "HEADER":
+		return HeaderRule(values[0])
+	case "QUERY":
+		return QueryRule(values[0])
+	case "GEOIP":
+		if env == nil || env.geoip == nil {
+			return nil, fmt.Errorf("GEOIP 规则需要先配置 GeoIPDatabase")
+		}
+		return GeoIPRule(env.geoip, !route.NoResolve, values...), nil
+	case "RULE-SET":
+		providers := make([]*RuleSetProvider, 0, len(values))
+		for _, name := range values {
+			var p *RuleSetProvider
+			if env != nil {
+				p = env.providers[name]
 			}
+			if p == nil {
+				return nil, fmt.Errorf("规则集 %s 不存在", name)
+			}
+			providers = append(providers, p)
 		}
-		return false
+		return RuleSetRule(!route.NoResolve, providers...), nil
+	default:
+		return nil, fmt.Errorf("未知规则类型 %s", route.Type)
+	}
+}
+
+// DomainSuffixRule 域名后缀匹配规则（自动剥离端口），后缀编译为反转标签树
+func DomainSuffixRule(suffixes ...string) ReqConditionFunc {
+	trie := newDomainTrie()
+	for _, s := range suffixes {
+		trie.insert(strings.ToLower(s), 0)
+	}
+	return func(req *http.Request, ctx *Pcontext) bool {
+		return trie.contains(extractHost(req))
 	}
 }
 
 // DomainKeywordRule 域名正则匹配规则（自动剥离端口，忽略大小写）
+// 不含正则元字符的关键字合并为 Aho-Corasick 自动机，其余逐个按正则匹配
 func DomainKeywordRule(patterns ...string) ReqConditionFunc {
+	var literals []string
 	regs := make([]*regexp.Regexp, 0, len(patterns))
 	for _, p := range patterns {
+		if isLiteralKeyword(p) {
+			literals = append(literals, strings.ToLower(p))
+			continue
+		}
 		if r, err := regexp.Compile("(?i)" + p); err == nil {
 			regs = append(regs, r)
 		}
 	}
+	keywords := newKeywordMatcher(literals, make([]int, len(literals)))
 	return func(req *http.Request, ctx *Pcontext) bool {
 		host := extractHost(req)
+		if keywords.contains(host) {
+			return true
+		}
 		for _, r := range regs {
 			if r.MatchString(host) {
 				return true
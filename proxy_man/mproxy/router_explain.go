@@ -0,0 +1,339 @@
+package mproxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// 单条规则的评估结果
+const (
+	TraceMatched    = "matched"
+	TraceNotMatched = "not-matched"
+	TraceSkipped    = "skipped"
+)
+
+// RuleTrace 路由试运行时单条规则的评估记录
+type RuleTrace struct {
+	Id     int    `json:"id"`
+	Target string `json:"target"`
+	Result string `json:"result"` // "matched" | "not-matched" | "skipped"
+	Reason string `json:"reason,omitempty"`
+}
+
+// ExplainRequest 路由试运行的输入，描述一个假想的请求
+type ExplainRequest struct {
+	URL      string            `json:"url"`                // 完整 URL；Method 为 CONNECT 时也可只写 host:port
+	Method   string            `json:"method,omitempty"`   // 默认 GET，CONNECT 表示按隧道模式评估
+	SourceIP string            `json:"sourceIP,omitempty"` // 客户端地址 "ip" 或 "ip:port"
+	InPort   int               `json:"inPort,omitempty"`   // 入站监听端口
+	Headers  map[string]string `json:"headers,omitempty"`
+}
+
+// ExplainResult 路由试运行的结果
+type ExplainResult struct {
+	Target string      `json:"target"`
+	RuleId int         `json:"ruleId"` // 命中的规则 Id，0 表示走默认直连
+	Rules  []RuleTrace `json:"rules"`  // 按顺序评估过的规则，命中后停止
+}
+
+// NewExplainRequest 将试运行输入转换为 MatchRoute 可用的请求，不会发起任何连接
+func NewExplainRequest(er ExplainRequest) (*http.Request, error) {
+	method := strings.ToUpper(er.Method)
+	if method == "" {
+		method = http.MethodGet
+	}
+	raw := er.URL
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("无效的 URL %q", er.URL)
+	}
+	if method == http.MethodConnect {
+		// 隧道模式只能看到 host:port
+		host := u.Host
+		if u.Port() == "" {
+			port := "80"
+			if u.Scheme == "https" {
+				port = "443"
+			}
+			host = net.JoinHostPort(u.Hostname(), port)
+		}
+		u = &url.URL{Host: host}
+	}
+
+	req, err := http.NewRequest(method, "http://placeholder", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.URL, req.Host = u, u.Host
+	for k, v := range er.Headers {
+		req.Header.Set(k, v)
+	}
+	if er.SourceIP != "" {
+		if ap, err := netip.ParseAddrPort(er.SourceIP); err == nil {
+			req.RemoteAddr = ap.String()
+		} else if addr, err := netip.ParseAddr(er.SourceIP); err == nil {
+			req.RemoteAddr = netip.AddrPortFrom(addr, 0).String()
+		} else {
+			return nil, fmt.Errorf("无效的源地址 %q", er.SourceIP)
+		}
+	}
+	if er.InPort > 0 {
+		req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, &net.TCPAddr{Port: er.InPort}))
+	}
+	return req, nil
+}
+
+// Explain 按当前规则试运行一次匹配，记录每条被评估的规则，不拨号也不打印匹配日志
+func (r *Router) Explain(req *http.Request) ExplainResult {
+	var trace []RuleTrace
+	target, _, rule := r.match(req, &trace)
+	if trace == nil {
+		trace = []RuleTrace{}
+	}
+	result := ExplainResult{Target: target, Rules: trace}
+	if rule != nil {
+		result.RuleId = rule.Id
+	}
+	return result
+}
+
+// ======================== 规则检查（lint） ========================
+
+// 规则问题类型
+const (
+	LintInvalid       = "invalid"        // 规则无法构建，热重载时会被丢弃
+	LintMissingTarget = "missing-target" // 目标节点不存在，热重载时会被丢弃
+	LintShadowed      = "shadowed"       // 被前面的规则完全覆盖，永远不会命中
+)
+
+// LintIssue 规则检查发现的问题
+type LintIssue struct {
+	Id         int    `json:"id"`
+	Kind       string `json:"kind"`
+	Message    string `json:"message"`
+	ShadowedBy int    `json:"shadowedBy,omitempty"`
+}
+
+// Lint 静态检查配置中的规则，不会创建拨号器、读取节点证书与密钥文件、下载规则集或发起连接。
+// 报告 ReloadFromConfig 会丢弃的规则，以及被前面规则完全覆盖的规则
+func (r *Router) Lint(cfg *ServerConfig) []LintIssue {
+	issues := []LintIssue{}
+	targets := lintTargets(cfg)
+	env := r.lintEnv(cfg)
+	skipUnhealthy := cfg.HealthCheck.Enable && cfg.HealthCheck.SkipUnhealthy
+
+	var effective []RouteRule // 能正常生效的规则，用于覆盖检查
+	for _, route := range cfg.Routes {
+		if !route.Enable {
+			continue
+		}
+		if !isCompositeRule(route.Type) && len(splitRuleValues(route)) == 0 {
+			continue
+		}
+		if _, err := compileSchedule(route.Schedule); err != nil {
+			issues = append(issues, LintIssue{Id: route.Id, Kind: LintInvalid, Message: err.Error()})
+			continue
+		}
+		if _, errs := compileRule(route, env, ""); len(errs) > 0 {
+			for _, err := range errs {
+				issues = append(issues, LintIssue{Id: route.Id, Kind: LintInvalid, Message: err.Error()})
+			}
+			continue
+		}
+		if !targets[route.Action] {
+			msg := fmt.Sprintf("目标节点 '%s' 不存在", route.Action)
+			if isRejectTarget(route.Action) || isRejectWithStatus(route.Action) {
+				if _, err := NewRejectDialer(route.Action); err != nil {
+					msg = err.Error()
+				} else {
+					msg = ""
+				}
+			}
+			if msg != "" {
+				issues = append(issues, LintIssue{Id: route.Id, Kind: LintMissingTarget, Message: msg})
+				continue
+			}
+		}
+		for _, prev := range effective {
+			// 开启 SkipUnhealthy 时，目标为节点或代理组的规则在其不可用时会被跳过，不能视为完全覆盖后面的规则
+			if skipUnhealthy && prev.Action != "Direct" && !isRejectTarget(prev.Action) {
+				continue
+			}
+			if ruleCovers(prev, route) {
+				issues = append(issues, LintIssue{
+					Id:         route.Id,
+					Kind:       LintShadowed,
+					Message:    fmt.Sprintf("被规则 #%d 完全覆盖，永远不会命中", prev.Id),
+					ShadowedBy: prev.Id,
+				})
+				break
+			}
+		}
+		effective = append(effective, route)
+	}
+	return issues
+}
+
+// lintTargets 收集配置中可用作规则目标的名称，与 ReloadFromConfig 的构建顺序一致。
+// 节点只经 validateProxyNode 校验 URL 和凭据
+func lintTargets(cfg *ServerConfig) map[string]bool {
+	names := map[string]bool{"Direct": true, RejectTarget: true, RejectDropTarget: true}
+	for _, node := range cfg.ProxyNodes {
+		if names[node.Name] || isRejectTarget(node.Name) {
+			continue
+		}
+		if validateProxyNode(node) == nil {
+			names[node.Name] = true
+		}
+	}
+	for _, group := range cfg.ProxyGroups {
+		if names[group.Name] {
+			continue
+		}
+		members := 0
+		for _, m := range group.Proxies {
+			if names[m] {
+				members++
+			}
+		}
+		if validateProxyGroup(group, members) == nil {
+			names[group.Name] = true
+		}
+	}
+	return names
+}
+
+// lintEnv 复用已加载的 GeoIP 数据库和规则集；配置中新出现的使用空占位，只用于校验引用关系
+func (r *Router) lintEnv(cfg *ServerConfig) *ruleBuildEnv {
+	r.mu.RLock()
+	geoip, providers := r.geoip, r.providers
+	r.mu.RUnlock()
+
+	env := &ruleBuildEnv{providers: make(map[string]*RuleSetProvider)}
+	if cfg.GeoIPDatabase != "" {
+		if geoip != nil && geoip.Path() == cfg.GeoIPDatabase {
+			env.geoip = geoip
+		} else {
+			env.geoip = &GeoIPDatabase{path: cfg.GeoIPDatabase}
+		}
+	}
+	for _, pc := range cfg.RuleProviders {
+		if p, ok := providers[pc.Name]; ok {
+			env.providers[pc.Name] = p
+		} else {
+			env.providers[pc.Name] = &RuleSetProvider{cfg: pc}
+		}
+	}
+	return env
+}
+
+// ruleCovers 判断规则 a 能否匹配规则 b 能匹配的所有请求（a 在前时 b 被遮蔽）。
+// 只做保守判断：无法确定时返回 false
+func ruleCovers(a, b RouteRule) bool {
+	if a.Schedule != nil {
+		return false // 有时间窗口的规则并非始终生效
+	}
+	// 同类型规则值是前者的子集（复合规则要求子规则完全相同）
+	if a.Type == b.Type && a.NoResolve == b.NoResolve && reflect.DeepEqual(a.SubRules, b.SubRules) &&
+		(isCompositeRule(a.Type) || isSubset(normalizedValues(b), normalizedValues(a))) {
+		return true
+	}
+	if isCompositeRule(a.Type) || isCompositeRule(b.Type) {
+		return false
+	}
+	// 会解析域名的 IP 类规则覆盖范围更大，反之不成立
+	resolveOK := !a.NoResolve || b.NoResolve
+	av, bv := splitRuleValues(a), splitRuleValues(b)
+
+	switch {
+	case a.Type == "DomainSuffix" && b.Type == "DomainSuffix":
+		return allCovered(bv, func(v string) bool {
+			v = strings.ToLower(v)
+			return slices.ContainsFunc(av, func(s string) bool {
+				s = strings.ToLower(s)
+				return v == s || strings.HasSuffix(v, "."+s)
+			})
+		})
+	case isCIDRRuleType(a.Type) && (isCIDRRuleType(b.Type) || b.Type == "IP"), a.Type == "SRC-IP-CIDR" && b.Type == "SRC-IP-CIDR":
+		if a.Type != "SRC-IP-CIDR" && b.Type != "IP" && !resolveOK {
+			return false
+		}
+		return allCovered(bv, func(v string) bool {
+			bp, err := parsePrefix(v)
+			if err != nil {
+				return false
+			}
+			return slices.ContainsFunc(av, func(s string) bool {
+				ap, err := parsePrefix(s)
+				return err == nil && ap.Bits() <= bp.Bits() && ap.Contains(bp.Addr())
+			})
+		})
+	case a.Type == b.Type && (a.Type == "DST-PORT" || a.Type == "SRC-PORT" || a.Type == "IN-PORT"):
+		ar, err1 := parsePortRanges(av)
+		br, err2 := parsePortRanges(bv)
+		if err1 != nil || err2 != nil {
+			return false
+		}
+		return allCovered(br, func(b portRange) bool {
+			return slices.ContainsFunc(ar, func(a portRange) bool { return a.lo <= b.lo && b.hi <= a.hi })
+		})
+	case a.Type == "PATH-PREFIX" && b.Type == "PATH-PREFIX":
+		return allCovered(bv, func(v string) bool {
+			bh, bp := splitPathPrefix(v)
+			return slices.ContainsFunc(av, func(s string) bool {
+				ah, ap := splitPathPrefix(s)
+				return (ah == "" || ah == bh) && strings.HasPrefix(bp, ap)
+			})
+		})
+	case a.Type == b.Type && (a.Type == "GEOIP" || a.Type == "RULE-SET"):
+		return resolveOK && isSubset(normalizedValues(b), normalizedValues(a))
+	}
+	return false
+}
+
+func isCIDRRuleType(t string) bool { return t == "IP-CIDR" || t == "IP-CIDR6" }
+
+// splitPathPrefix 与 PathPrefixRule 的解析方式一致
+func splitPathPrefix(p string) (host, path string) {
+	p = strings.TrimSuffix(p, "*")
+	if strings.HasPrefix(p, "/") {
+		return "", p
+	}
+	host, path, _ = strings.Cut(p, "/")
+	return strings.ToLower(host), "/" + path
+}
+
+// normalizedValues 大小写不敏感的规则类型统一转为大写后比较
+func normalizedValues(route RouteRule) []string {
+	values := splitRuleValues(route)
+	switch route.Type {
+	case "DomainSuffix", "DomainKeyword", "METHOD", "GEOIP":
+		for i, v := range values {
+			values[i] = strings.ToUpper(v)
+		}
+	}
+	return values
+}
+
+func isSubset(sub, super []string) bool {
+	return allCovered(sub, func(v string) bool { return slices.Contains(super, v) })
+}
+
+func allCovered[T any](items []T, covered func(T) bool) bool {
+	for _, it := range items {
+		if !covered(it) {
+			return false
+		}
+	}
+	return len(items) > 0
+}
@@ -0,0 +1,155 @@
+package mproxy
+
+import (
+	"encoding/base64"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouter_Explain(t *testing.T) {
+	router := NewRouter(NewCoreHttpSever())
+	require.NoError(t, router.ReloadFromConfig(&ServerConfig{
+		ProxyNodes: []ProxyNode{{Name: "office", URL: "http://127.0.0.1:1"}},
+		Routes: []RouteRule{
+			{Id: 1, Type: "PATH-PREFIX", Value: "/admin", Action: RejectTarget, Enable: true},
+			{Id: 2, Type: "HEADER", Value: "X-Team=^ops$", Action: "office", Enable: true},
+			{Id: 3, Type: "SRC-IP-CIDR", Value: "192.168.1.0/24", Action: "office", Enable: true},
+			{Id: 4, Type: "DomainSuffix", Value: "example.com", Action: RejectTarget, Enable: true},
+		},
+	}))
+
+	// 隧道模式：L7 规则被跳过，源地址规则命中
+	req, err := NewExplainRequest(ExplainRequest{URL: "https://www.example.com/admin", Method: "connect", SourceIP: "192.168.1.20"})
+	require.NoError(t, err)
+	assert.Equal(t, "www.example.com:443", req.Host)
+	result := router.Explain(req)
+	assert.Equal(t, "office", result.Target)
+	assert.Equal(t, 3, result.RuleId)
+	require.Len(t, result.Rules, 3)
+	assert.Equal(t, TraceSkipped, result.Rules[0].Result)
+	assert.Equal(t, TraceSkipped, result.Rules[1].Result)
+	assert.Equal(t, TraceMatched, result.Rules[2].Result)
+
+	// 普通请求：按请求头命中
+	req, err = NewExplainRequest(ExplainRequest{URL: "http://www.example.com/", Headers: map[string]string{"X-Team": "ops"}})
+	require.NoError(t, err)
+	result = router.Explain(req)
+	assert.Equal(t, 2, result.RuleId)
+	assert.Equal(t, TraceNotMatched, result.Rules[0].Result)
+
+	// 全部未命中走默认直连
+	req, err = NewExplainRequest(ExplainRequest{URL: "other.org/", SourceIP: "10.0.0.1:5000"})
+	require.NoError(t, err)
+	result = router.Explain(req)
+	assert.Equal(t, "Direct", result.Target)
+	assert.Zero(t, result.RuleId)
+	assert.Len(t, result.Rules, 4)
+
+	_, err = NewExplainRequest(ExplainRequest{URL: "http://a.com", SourceIP: "not-an-ip"})
+	assert.Error(t, err)
+}
+
+func TestRouter_LintTargets(t *testing.T) {
+	targets := lintTargets(&ServerConfig{
+		ProxyNodes: []ProxyNode{
+			// 不读取 known_hosts，路径不存在也视为可用目标
+			{Name: "jump", URL: "ssh://ops:pw@127.0.0.1:22", KnownHosts: "/nonexistent/known_hosts"},
+			{Name: "nouser", URL: "ssh://127.0.0.1:22", Password: "pw"},
+			{Name: "ss", URL: "ss://" + base64.RawURLEncoding.EncodeToString([]byte("aes-128-gcm:pw")) + "@127.0.0.1:8388"},
+			{Name: "badss", URL: "ss://" + base64.RawURLEncoding.EncodeToString([]byte("rc4:pw")) + "@127.0.0.1:8388"},
+			{Name: "ftp", URL: "ftp://127.0.0.1:21"},
+		},
+	})
+	assert.True(t, targets["jump"])
+	assert.True(t, targets["ss"])
+	assert.False(t, targets["nouser"])
+	assert.False(t, targets["badss"])
+	assert.False(t, targets["ftp"])
+}
+
+func TestRouter_Lint(t *testing.T) {
+	router := NewRouter(NewCoreHttpSever())
+	cfg := &ServerConfig{
+		ProxyNodes: []ProxyNode{{Name: "node", URL: "http://127.0.0.1:1"}},
+		Routes: []RouteRule{
+			{Id: 1, Type: "DomainSuffix", Value: "google.com", Action: "node", Enable: true},
+			{Id: 2, Type: "DomainSuffix", Value: "mail.google.com", Action: "Direct", Enable: true},
+			{Id: 3, Type: "IP-CIDR", Value: "10.0.0.0/8", Action: "node", Enable: true},
+			{Id: 4, Type: "IP-CIDR", Value: "10.1.0.0/16", Action: "node", Enable: true, NoResolve: true},
+			{Id: 5, Type: "DST-PORT", Value: "8000-9000", Action: "node", Enable: true},
+			{Id: 6, Type: "DST-PORT", Value: "8080,8443", Action: RejectTarget, Enable: true},
+			{Id: 7, Type: "DomainKeyword", Value: "ads", Action: "missing", Enable: true},
+			{Id: 8, Type: "IP-CIDR", Value: "bad", Action: "node", Enable: true},
+			{Id: 9, Type: "DomainSuffix", Value: "mail.google.com", Action: "Direct", Enable: true,
+				Schedule: &RuleSchedule{Start: "09:00", End: "18:00"}},
+			{Id: 10, Type: "DomainSuffix", Value: "docs.google.com", Action: "Direct", Enable: false},
+			{Id: 11, Type: "DomainKeyword", Value: "x", Action: "Reject-With-Status:99", Enable: true},
+		},
+	}
+
+	issues := router.Lint(cfg)
+	byId := map[int]LintIssue{}
+	for _, issue := range issues {
+		byId[issue.Id] = issue
+	}
+	assert.Len(t, byId, 7, "%+v", issues)
+	assert.Equal(t, LintShadowed, byId[2].Kind)
+	assert.Equal(t, 1, byId[2].ShadowedBy)
+	assert.Equal(t, LintShadowed, byId[4].Kind)
+	assert.Equal(t, 3, byId[4].ShadowedBy)
+	assert.Equal(t, LintShadowed, byId[6].Kind)
+	assert.Equal(t, LintMissingTarget, byId[7].Kind)
+	assert.Equal(t, LintInvalid, byId[8].Kind)
+	assert.Equal(t, LintShadowed, byId[9].Kind, "后面的规则即使有时间窗口也会被遮蔽")
+	assert.NotContains(t, byId, 10)
+	assert.Equal(t, LintMissingTarget, byId[11].Kind, "状态码超出范围")
+
+	// NoResolve 的 CIDR 规则不能覆盖会解析域名的规则
+	assert.False(t, ruleCovers(
+		RouteRule{Type: "IP-CIDR", Value: "10.0.0.0/8", NoResolve: true},
+		RouteRule{Type: "IP-CIDR", Value: "10.1.0.0/16"},
+	))
+	// 复合规则只识别完全相同的情况
+	and := RouteRule{Type: RuleAnd, SubRules: []RouteRule{{Type: "METHOD", Value: "GET"}}}
+	assert.True(t, ruleCovers(and, and))
+	assert.True(t, ruleCovers(
+		RouteRule{Type: "PATH-PREFIX", Value: "/api"},
+		RouteRule{Type: "PATH-PREFIX", Value: "a.com/api/v1*"},
+	))
+}
+
+func TestRouter_LintSkipUnhealthy(t *testing.T) {
+	router := NewRouter(NewCoreHttpSever())
+	cfg := &ServerConfig{
+		HealthCheck: HealthCheckConfig{Enable: true, SkipUnhealthy: true},
+		ProxyNodes:  []ProxyNode{{Name: "node", URL: "http://127.0.0.1:1"}},
+		ProxyGroups: []ProxyGroup{{Name: "auto", Type: GroupFallback, Proxies: []string{"node"}}},
+		Routes: []RouteRule{
+			{Id: 1, Type: "DomainSuffix", Value: "google.com", Action: "node", Enable: true},
+			{Id: 2, Type: "DomainSuffix", Value: "google.com", Action: "Direct", Enable: true},
+			{Id: 3, Type: "DomainSuffix", Value: "github.com", Action: "auto", Enable: true},
+			{Id: 4, Type: "DomainSuffix", Value: "github.com", Action: "Direct", Enable: true},
+			{Id: 5, Type: "DomainSuffix", Value: "ads.com", Action: RejectTarget, Enable: true},
+			{Id: 6, Type: "DomainSuffix", Value: "ads.com", Action: "Direct", Enable: true},
+		},
+	}
+
+	// 节点或代理组不可用时前面的规则被跳过，后面的规则是其回退，不算被覆盖
+	issues := router.Lint(cfg)
+	require.Len(t, issues, 1, "%+v", issues)
+	assert.Equal(t, 6, issues[0].Id)
+	assert.Equal(t, 5, issues[0].ShadowedBy)
+
+	cfg.HealthCheck.SkipUnhealthy = false
+	assert.Len(t, router.Lint(cfg), 3)
+}
+
+func TestNewExplainRequest_Methods(t *testing.T) {
+	req, err := NewExplainRequest(ExplainRequest{URL: "example.com", Method: http.MethodConnect, InPort: 7890})
+	require.NoError(t, err)
+	assert.Equal(t, "example.com:80", req.URL.Host)
+	assert.Equal(t, 7890, inboundPort(req))
+}
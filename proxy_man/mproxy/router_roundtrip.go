@@ -16,8 +16,21 @@ func NewRouterRoundTripper(proxy *CoreHttpServer, router *Router) *RouterRoundTr
 // RoundTrip 实现 mproxy.RoundTripper 接口
 // 直接使用对应节点的专属 Transport，天然隔离连接池，不受 Keep-Alive 复用影响
 func (rt *RouterRoundTripper) RoundTrip(req *http.Request, ctx *Pcontext) (*http.Response, error) {
-	targetName, dialer := rt.router.MatchRoute(req)
+	// 目标是 Fake-IP 假地址时改用原始域名转发，保证 Host 与 SNI 正确
+	if restored := rt.proxy.Resolver.restoreFakeIPRequest(req); restored != nil {
+		req = restored
+	}
+	targetName, dialer, hit := rt.router.route(req)
 	rt.proxy.Logger.Printf("INFO: [路由匹配] %s %s -> %s", req.Method, req.URL.Host, targetName)
+	// 拒绝目标不拨号，直接合成响应
+	if reject, ok := dialer.(*RejectDialer); ok {
+		return reject.RoundTrip(req)
+	}
 	// 直接使用对应节点的专属 Transport，天然隔离连接池
-	return dialer.GetTransport().RoundTrip(req)
+	resp, err := dialer.GetTransport().RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	hit.wrapResponse(req, resp)
+	return resp, nil
 }
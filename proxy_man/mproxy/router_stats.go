@@ -0,0 +1,259 @@
+package mproxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ruleCounter 单条规则的命中统计，按 RouteRule.Id 保存，热重载时 Id 不变则沿用
+type ruleCounter struct {
+	hits    atomic.Int64
+	bytes   atomic.Int64
+	lastHit atomic.Int64 // UnixNano，0 表示从未命中
+}
+
+// targetCounter 单个目标（节点 / 代理组 / 内置目标）的累计统计
+type targetCounter struct {
+	hits  atomic.Int64
+	bytes atomic.Int64
+}
+
+// routeStats 路由统计存储，独立于规则列表，不随热重载重建
+type routeStats struct {
+	mu      sync.RWMutex
+	rules   map[int]*ruleCounter
+	targets map[string]*targetCounter
+	unmatch targetCounter // 未命中任何规则、走默认直连的请求
+}
+
+func newRouteStats() *routeStats {
+	return &routeStats{
+		rules:   make(map[int]*ruleCounter),
+		targets: make(map[string]*targetCounter),
+	}
+}
+
+// rule 返回规则 Id 对应的计数器，不存在时创建
+func (s *routeStats) rule(id int) *ruleCounter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.rules[id]
+	if !ok {
+		c = &ruleCounter{}
+		s.rules[id] = c
+	}
+	return c
+}
+
+// target 返回目标对应的计数器，不存在时创建。每次命中都会调用，先走读锁
+func (s *routeStats) target(name string) *targetCounter {
+	s.mu.RLock()
+	c, ok := s.targets[name]
+	s.mu.RUnlock()
+	if ok {
+		return c
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok = s.targets[name]
+	if !ok {
+		c = &targetCounter{}
+		s.targets[name] = c
+	}
+	return c
+}
+
+// retain 热重载后丢弃已删除规则的计数器
+func (s *routeStats) retain(rules []RoutingRule) {
+	keep := make(map[int]bool, len(rules))
+	for _, rule := range rules {
+		keep[rule.Id] = true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id := range s.rules {
+		if !keep[id] {
+			delete(s.rules, id)
+		}
+	}
+}
+
+// routeHit 一次路由命中，后续的流量累加到对应规则和目标
+type routeHit struct {
+	rule   *ruleCounter // 代码添加的规则或默认直连时为 nil
+	target *targetCounter
+	other  *targetCounter // 默认直连时累加到 unmatch
+}
+
+// add 累加经由本次路由传输的字节数
+func (h *routeHit) add(n int64) {
+	if h == nil || n <= 0 {
+		return
+	}
+	if h.rule != nil {
+		h.rule.bytes.Add(n)
+	}
+	if h.other != nil {
+		h.other.bytes.Add(n)
+	}
+	h.target.bytes.Add(n)
+}
+
+// record 记录一次命中，rule 为 nil 表示走默认直连
+func (s *routeStats) record(rule *RoutingRule, target string, now time.Time) *routeHit {
+	hit := &routeHit{target: s.target(target)}
+	hit.target.hits.Add(1)
+	switch {
+	case rule == nil:
+		hit.other = &s.unmatch
+		s.unmatch.hits.Add(1)
+	case rule.stats != nil:
+		hit.rule = rule.stats
+		hit.rule.hits.Add(1)
+		hit.rule.lastHit.Store(now.UnixNano())
+	}
+	return hit
+}
+
+// ======================== 统计快照 ========================
+
+// RuleStats 单条规则的统计快照
+type RuleStats struct {
+	Id      int        `json:"id"`
+	Target  string     `json:"target"`
+	Hits    int64      `json:"hits"`
+	Bytes   int64      `json:"bytes"`
+	LastHit *time.Time `json:"lastHit,omitempty"` // 从未命中时省略
+}
+
+// TargetStats 单个目标的统计快照
+type TargetStats struct {
+	Name  string `json:"name"`
+	Hits  int64  `json:"hits"`
+	Bytes int64  `json:"bytes"`
+}
+
+// RoutingStats 路由统计快照，Rules 按规则顺序排列，包含从未命中的规则
+type RoutingStats struct {
+	Rules   []RuleStats   `json:"rules"`
+	Targets []TargetStats `json:"targets"`
+	Default TargetStats   `json:"default"` // 未命中任何规则的请求
+}
+
+// Stats 返回当前规则的命中统计
+func (r *Router) Stats() RoutingStats {
+	r.mu.RLock()
+	rules := r.Rules
+	r.mu.RUnlock()
+
+	out := RoutingStats{Rules: []RuleStats{}, Targets: []TargetStats{}}
+	seen := make(map[int]bool, len(rules))
+	for _, rule := range rules {
+		if rule.stats == nil || seen[rule.Id] {
+			continue
+		}
+		seen[rule.Id] = true
+		rs := RuleStats{Id: rule.Id, Target: rule.Target, Hits: rule.stats.hits.Load(), Bytes: rule.stats.bytes.Load()}
+		if ns := rule.stats.lastHit.Load(); ns != 0 {
+			t := time.Unix(0, ns)
+			rs.LastHit = &t
+		}
+		out.Rules = append(out.Rules, rs)
+	}
+
+	r.stats.mu.Lock()
+	for name, c := range r.stats.targets {
+		out.Targets = append(out.Targets, TargetStats{Name: name, Hits: c.hits.Load(), Bytes: c.bytes.Load()})
+	}
+	r.stats.mu.Unlock()
+	sort.Slice(out.Targets, func(i, j int) bool { return out.Targets[i].Name < out.Targets[j].Name })
+
+	out.Default = TargetStats{Name: "Direct", Hits: r.stats.unmatch.hits.Load(), Bytes: r.stats.unmatch.bytes.Load()}
+	return out
+}
+
+// ResetStats 清零所有计数器，规则与计数器的对应关系保持不变
+func (r *Router) ResetStats() {
+	r.stats.mu.Lock()
+	defer r.stats.mu.Unlock()
+	for _, c := range r.stats.rules {
+		c.hits.Store(0)
+		c.bytes.Store(0)
+		c.lastHit.Store(0)
+	}
+	for _, c := range r.stats.targets {
+		c.hits.Store(0)
+		c.bytes.Store(0)
+	}
+	r.stats.unmatch.hits.Store(0)
+	r.stats.unmatch.bytes.Store(0)
+}
+
+// ======================== 流量统计包装 ========================
+
+// statsConn 统计隧道连接双向的字节数
+type statsConn struct {
+	net.Conn
+	hit *routeHit
+}
+
+func (c *statsConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.hit.add(int64(n))
+	return n, err
+}
+
+func (c *statsConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.hit.add(int64(n))
+	return n, err
+}
+
+// statsHalfConn 底层连接支持半关闭时保留该能力，隧道转发依赖它传递 EOF
+type statsHalfConn struct {
+	statsConn
+	half halfClosable
+}
+
+func (c *statsHalfConn) CloseWrite() error { return c.half.CloseWrite() }
+func (c *statsHalfConn) CloseRead() error  { return c.half.CloseRead() }
+
+// wrapConn 为拨号得到的连接加上流量统计
+func (h *routeHit) wrapConn(conn net.Conn) net.Conn {
+	if h == nil {
+		return conn
+	}
+	if half, ok := conn.(halfClosable); ok {
+		return &statsHalfConn{statsConn: statsConn{Conn: conn, hit: h}, half: half}
+	}
+	return &statsConn{Conn: conn, hit: h}
+}
+
+// statsBody 统计 MITM 模式下响应体的字节数
+type statsBody struct {
+	io.ReadCloser
+	hit *routeHit
+}
+
+func (b *statsBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.hit.add(int64(n))
+	return n, err
+}
+
+// wrapResponse 统计请求体（按 ContentLength）和响应体的字节数
+func (h *routeHit) wrapResponse(req *http.Request, resp *http.Response) {
+	if h == nil {
+		return
+	}
+	h.add(req.ContentLength)
+	// 101 协议升级的 Body 同时可写，不能包装
+	if resp != nil && resp.Body != nil && resp.StatusCode != http.StatusSwitchingProtocols {
+		resp.Body = &statsBody{ReadCloser: resp.Body, hit: h}
+	}
+}
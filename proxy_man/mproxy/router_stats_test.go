@@ -0,0 +1,99 @@
+package mproxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouter_StatsSurviveReload(t *testing.T) {
+	router := NewRouter(NewCoreHttpSever())
+	cfg := &ServerConfig{Routes: []RouteRule{
+		{Id: 1, Type: "DomainSuffix", Value: "a.com", Action: RejectTarget, Enable: true},
+		{Id: 2, Type: "DomainSuffix", Value: "b.com", Action: "Direct", Enable: true},
+		{Id: 3, Type: "DomainSuffix", Value: "dead.com", Action: "Direct", Enable: true},
+	}}
+	require.NoError(t, router.ReloadFromConfig(cfg))
+
+	for _, host := range []string{"x.a.com:443", "a.com:443", "b.com:443", "other.org:443"} {
+		req, _ := http.NewRequest(http.MethodConnect, "http://"+host, nil)
+		router.MatchRoute(req)
+	}
+
+	stats := router.Stats()
+	require.Len(t, stats.Rules, 3)
+	assert.EqualValues(t, 2, stats.Rules[0].Hits)
+	assert.NotNil(t, stats.Rules[0].LastHit)
+	assert.EqualValues(t, 1, stats.Rules[1].Hits)
+	assert.Zero(t, stats.Rules[2].Hits, "从未命中的规则也要列出")
+	assert.Nil(t, stats.Rules[2].LastHit)
+	assert.EqualValues(t, 1, stats.Default.Hits)
+
+	targets := map[string]int64{}
+	for _, ts := range stats.Targets {
+		targets[ts.Name] = ts.Hits
+	}
+	assert.Equal(t, map[string]int64{RejectTarget: 2, "Direct": 2}, targets)
+
+	// 规则 1 改了值但 Id 不变，计数保留；规则 2 被删除
+	cfg.Routes = []RouteRule{
+		{Id: 1, Type: "DomainSuffix", Value: "a.com,a.net", Action: RejectTarget, Enable: true},
+		{Id: 3, Type: "DomainSuffix", Value: "dead.com", Action: "Direct", Enable: true},
+		{Id: 2, Type: "DomainSuffix", Value: "b.com", Action: "Direct", Enable: false},
+	}
+	require.NoError(t, router.ReloadFromConfig(cfg))
+	stats = router.Stats()
+	require.Len(t, stats.Rules, 2)
+	assert.Equal(t, 1, stats.Rules[0].Id)
+	assert.EqualValues(t, 2, stats.Rules[0].Hits)
+
+	cfg.Routes[2].Enable = true
+	require.NoError(t, router.ReloadFromConfig(cfg))
+	assert.Zero(t, router.Stats().Rules[2].Hits, "删除后重新添加的规则从零开始")
+
+	router.ResetStats()
+	stats = router.Stats()
+	assert.Zero(t, stats.Rules[0].Hits)
+	assert.Zero(t, stats.Default.Hits)
+}
+
+func TestRouter_StatsCountTunnelBytes(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		io.CopyN(c, c, 5) // 回显 5 字节
+	}()
+
+	router := NewRouter(NewCoreHttpSever())
+	require.NoError(t, router.ReloadFromConfig(&ServerConfig{Routes: []RouteRule{
+		{Id: 7, Type: "IP-CIDR", Value: "127.0.0.0/8", Action: "Direct", Enable: true, NoResolve: true},
+	}}))
+
+	req, _ := http.NewRequest(http.MethodConnect, "http://"+ln.Addr().String(), nil)
+	conn, err := router.RouteDial(req, "tcp", ln.Addr().String())
+	require.NoError(t, err)
+	_, isHalf := conn.(halfClosable)
+	assert.True(t, isHalf, "TCP 连接包装后仍支持半关闭")
+
+	_, err = conn.Write([]byte("hello"))
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	require.NoError(t, err)
+	conn.Close()
+
+	stats := router.Stats()
+	assert.EqualValues(t, 1, stats.Rules[0].Hits)
+	assert.EqualValues(t, 10, stats.Rules[0].Bytes)
+	assert.Zero(t, stats.Default.Bytes)
+}
@@ -0,0 +1,169 @@
+package mproxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+const routeResolveTimeout = 3 * time.Second
+
+// cidrTrie 按位前缀树，查询耗时只与地址位数相关，与前缀数量无关
+type cidrTrie struct {
+	root4 *cidrNode
+	root6 *cidrNode
+	size  int
+}
+
+type cidrNode struct {
+	child    [2]*cidrNode
+	terminal bool // 从根到此节点的路径构成一条已插入的前缀
+}
+
+func newCIDRTrie() *cidrTrie {
+	return &cidrTrie{root4: &cidrNode{}, root6: &cidrNode{}}
+}
+
+// parsePrefix 解析 CIDR，不带掩码的单个 IP 视为 /32 或 /128
+func parsePrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "/") {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, err
+		}
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	p, err := netip.ParsePrefix(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	if p.Addr().Is4In6() && p.Bits() >= 96 {
+		p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+	}
+	return p.Masked(), nil
+}
+
+// Insert 插入一条前缀
+func (t *cidrTrie) Insert(p netip.Prefix) {
+	addr := p.Addr()
+	node := t.root6
+	if addr.Is4() {
+		node = t.root4
+	}
+	bytes := addr.AsSlice()
+	for i := 0; i < p.Bits(); i++ {
+		if node.terminal {
+			return // 已被更短的前缀覆盖
+		}
+		bit := (bytes[i/8] >> (7 - uint(i%8))) & 1
+		if node.child[bit] == nil {
+			node.child[bit] = &cidrNode{}
+		}
+		node = node.child[bit]
+	}
+	if !node.terminal {
+		node.terminal = true
+		node.child = [2]*cidrNode{} // 更长的前缀已无意义
+		t.size++
+	}
+}
+
+// Contains 判断 IP 是否落在任意一条前缀内
+func (t *cidrTrie) Contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	node := t.root6
+	if addr.Is4() {
+		node = t.root4
+	}
+	bytes := addr.AsSlice()
+	for i := 0; node != nil; i++ {
+		if node.terminal {
+			return true
+		}
+		if i == len(bytes)*8 {
+			return false
+		}
+		node = node.child[(bytes[i/8]>>(7-uint(i%8)))&1]
+	}
+	return false
+}
+
+// Len 返回有效前缀数量（被覆盖的前缀不计入）
+func (t *cidrTrie) Len() int { return t.size }
+
+// destIPs 返回请求目标的 IP 列表：目标本身是 IP 时直接返回；
+// 目标是域名且 resolve 为 true 时进行解析，同一次路由匹配内只解析一次
+func destIPs(req *http.Request, ctx *Pcontext, resolve bool) []netip.Addr {
+	host := extractHost(req)
+	if addr, err := netip.ParseAddr(strings.Trim(host, "[]")); err == nil {
+		return []netip.Addr{addr.Unmap()}
+	}
+	if !resolve || host == "" {
+		return nil
+	}
+	if ctx != nil && ctx.resolved && ctx.resolvedHost == host {
+		return ctx.resolvedIPs
+	}
+
+	c, cancel := context.WithTimeout(context.Background(), routeResolveTimeout)
+	defer cancel()
+	var resolver *Resolver
+	if ctx != nil && ctx.core_proxy != nil {
+		resolver = ctx.core_proxy.Resolver
+	}
+	addrs, err := resolver.LookupNetIP(c, host)
+	if err != nil && ctx != nil && ctx.core_proxy != nil {
+		ctx.core_proxy.Logger.Printf("WARN: [路由匹配] 解析 %s 失败: %v", host, err)
+	}
+	for i := range addrs {
+		addrs[i] = addrs[i].Unmap()
+	}
+	if ctx != nil {
+		ctx.resolved, ctx.resolvedHost, ctx.resolvedIPs = true, host, addrs
+	}
+	return addrs
+}
+
+// checkCIDRFamily 检查前缀与规则类型的地址族一致：IP-CIDR 只接受 IPv4，IP-CIDR6 只接受 IPv6，
+// 规则类型写错时在重载阶段报错而不是静默生效
+func checkCIDRFamily(ruleType string, cidrs []string) error {
+	for _, c := range cidrs {
+		p, err := parsePrefix(c)
+		if err != nil {
+			return fmt.Errorf("无效的 CIDR %s: %w", c, err)
+		}
+		if ruleType == "IP-CIDR6" && p.Addr().Is4() {
+			return fmt.Errorf("IP-CIDR6 规则只接受 IPv6 前缀，%s 请使用 IP-CIDR", c)
+		}
+		if ruleType == "IP-CIDR" && p.Addr().Is6() {
+			return fmt.Errorf("IP-CIDR 规则只接受 IPv4 前缀，%s 请使用 IP-CIDR6", c)
+		}
+	}
+	return nil
+}
+
+// IPCIDRRule CIDR 匹配规则（IP-CIDR / IP-CIDR6），基于前缀树查找。
+// resolve 为 true 时对域名请求先解析再匹配（对应 Clash 未加 no-resolve 的语义）
+func IPCIDRRule(resolve bool, cidrs ...string) (ReqConditionFunc, error) {
+	trie := newCIDRTrie()
+	for _, c := range cidrs {
+		p, err := parsePrefix(c)
+		if err != nil {
+			return nil, fmt.Errorf("无效的 CIDR %s: %w", c, err)
+		}
+		trie.Insert(p)
+	}
+	return func(req *http.Request, ctx *Pcontext) bool {
+		for _, ip := range destIPs(req, ctx, resolve) {
+			if trie.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
@@ -0,0 +1,84 @@
+package mproxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCIDRTrie_Contains(t *testing.T) {
+	trie := newCIDRTrie()
+	for _, c := range []string{"10.0.0.0/8", "192.168.1.0/24", "203.0.113.7", "2001:db8::/32", "10.1.0.0/16"} {
+		p, err := parsePrefix(c)
+		require.NoError(t, err)
+		trie.Insert(p)
+	}
+	assert.Equal(t, 4, trie.Len(), "10.1.0.0/16 已被 10.0.0.0/8 覆盖")
+
+	cases := map[string]bool{
+		"10.255.1.1":        true,
+		"11.0.0.1":          false,
+		"192.168.1.200":     true,
+		"192.168.2.1":       false,
+		"203.0.113.7":       true,
+		"203.0.113.8":       false,
+		"2001:db8:1::1":     true,
+		"2001:db9::1":       false,
+		"::ffff:10.2.3.4":   true, // IPv4 映射地址按 IPv4 匹配
+		"::ffff:172.16.0.1": false,
+	}
+	for ip, want := range cases {
+		assert.Equal(t, want, trie.Contains(netip.MustParseAddr(ip)), ip)
+	}
+}
+
+func TestIPCIDRRule_Resolve(t *testing.T) {
+	_, err := IPCIDRRule(true, "10.0.0.0/33")
+	assert.Error(t, err)
+
+	resolving, err := IPCIDRRule(true, "127.0.0.0/8")
+	require.NoError(t, err)
+	noResolve, err := IPCIDRRule(false, "127.0.0.0/8")
+	require.NoError(t, err)
+
+	ipReq, _ := http.NewRequest(http.MethodGet, "http://127.0.0.1:8080/", nil)
+	assert.True(t, resolving(ipReq, &Pcontext{}))
+	assert.True(t, noResolve(ipReq, &Pcontext{}))
+
+	// localhost 由系统 hosts 解析到回环地址
+	hostReq, _ := http.NewRequest(http.MethodConnect, "http://localhost:443", nil)
+	assert.True(t, resolving(hostReq, &Pcontext{}))
+	assert.False(t, noResolve(hostReq, &Pcontext{}))
+}
+
+func TestRouter_IPCIDRRoutes(t *testing.T) {
+	router := NewRouter(NewCoreHttpSever())
+	require.NoError(t, router.ReloadFromConfig(&ServerConfig{
+		ProxyNodes: []ProxyNode{{Name: "corp", URL: "http://127.0.0.1:1"}},
+		Routes: []RouteRule{
+			{Id: 1, Type: "IP-CIDR", Value: "10.0.0.0/8, 172.16.0.0/12", Action: "corp", Enable: true},
+			{Id: 2, Type: "IP-CIDR6", Value: "2001:db8::/32", Action: "corp", Enable: true, NoResolve: true},
+			{Id: 3, Type: "IP-CIDR", Value: "not-a-cidr", Action: "corp", Enable: true},
+			{Id: 4, Type: "IP-CIDR6", Value: "192.168.0.0/16", Action: "corp", Enable: true},
+			{Id: 5, Type: "IP-CIDR", Value: "10.0.0.0/8, fd00::/8", Action: "corp", Enable: true},
+		},
+	}))
+	assert.Len(t, router.Rules, 2, "无效 CIDR 或地址族与规则类型不符的规则被跳过")
+
+	for host, want := range map[string]string{
+		"10.3.4.5:443":       "corp",
+		"172.31.0.1:80":      "corp",
+		"[2001:db8::1]:443":  "corp",
+		"8.8.8.8:53":         "Direct",
+		"[2001:4860::1]:443": "Direct",
+	} {
+		req, err := http.NewRequest(http.MethodConnect, fmt.Sprintf("http://%s", host), nil)
+		require.NoError(t, err)
+		target, _ := router.MatchRoute(req)
+		assert.Equal(t, want, target, host)
+	}
+}
@@ -0,0 +1,141 @@
+package mproxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// geoIPWatchInterval 检查 mmdb 文件是否被替换的间隔
+var geoIPWatchInterval = 10 * time.Second
+
+// geoIPRecord mmdb 中只需要国家代码（兼容 GeoLite2-Country / GeoIP2-City 等格式）
+type geoIPRecord struct {
+	Country struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+// GeoIPDatabase 离线 MaxMind 格式数据库，文件变化时自动重新加载并原子替换
+type GeoIPDatabase struct {
+	proxy  *CoreHttpServer
+	path   string
+	reader atomic.Pointer[maxminddb.Reader]
+
+	modTime time.Time
+	size    int64
+
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// OpenGeoIPDatabase 加载 mmdb 文件并开始监视文件变化
+func OpenGeoIPDatabase(proxy *CoreHttpServer, path string) (*GeoIPDatabase, error) {
+	g := &GeoIPDatabase{proxy: proxy, path: path, stop: make(chan struct{})}
+	if err := g.load(); err != nil {
+		return nil, err
+	}
+	go g.watch()
+	return g, nil
+}
+
+// load 整体读入内存而非 mmap，旧 Reader 被替换后由 GC 回收，不会与并发查询冲突
+func (g *GeoIPDatabase) load() error {
+	info, err := os.Stat(g.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(g.path)
+	if err != nil {
+		return err
+	}
+	reader, err := maxminddb.FromBytes(data)
+	if err != nil {
+		return fmt.Errorf("解析 GeoIP 数据库 %s 失败: %w", g.path, err)
+	}
+	g.reader.Store(reader)
+	g.modTime, g.size = info.ModTime(), info.Size()
+	return nil
+}
+
+func (g *GeoIPDatabase) watch() {
+	ticker := time.NewTicker(geoIPWatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-g.stop:
+			return
+		case <-ticker.C:
+			info, err := os.Stat(g.path)
+			if err != nil || (info.ModTime().Equal(g.modTime) && info.Size() == g.size) {
+				continue
+			}
+			if err := g.load(); err != nil {
+				g.proxy.Logger.Printf("WARN: GeoIP 数据库重新加载失败，继续使用旧数据: %v", err)
+				continue
+			}
+			g.proxy.Logger.Printf("INFO: GeoIP 数据库已重新加载: %s", g.path)
+		}
+	}
+}
+
+// Path 返回数据库文件路径
+func (g *GeoIPDatabase) Path() string { return g.path }
+
+// Country 返回 IP 所属国家的 ISO 代码（大写），查不到时返回空字符串
+func (g *GeoIPDatabase) Country(ip netip.Addr) string {
+	reader := g.reader.Load()
+	if reader == nil {
+		return ""
+	}
+	var rec geoIPRecord
+	if err := reader.Lookup(net.IP(ip.Unmap().AsSlice()), &rec); err != nil {
+		return ""
+	}
+	if rec.Country.IsoCode != "" {
+		return strings.ToUpper(rec.Country.IsoCode)
+	}
+	return strings.ToUpper(rec.RegisteredCountry.IsoCode)
+}
+
+// Close 停止文件监视
+func (g *GeoIPDatabase) Close() error {
+	g.closeOnce.Do(func() { close(g.stop) })
+	return nil
+}
+
+// isLANAddr 局域网、回环和链路本地地址，对应 GEOIP 规则中的 LAN
+func isLANAddr(ip netip.Addr) bool {
+	return ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified()
+}
+
+// GeoIPRule 按目标 IP 所属国家匹配，codes 为 ISO 国家代码，另支持 "LAN" 匹配局域网地址。
+// resolve 为 true 时对域名请求先解析再匹配
+func GeoIPRule(db *GeoIPDatabase, resolve bool, codes ...string) ReqConditionFunc {
+	codeSet := make(map[string]bool, len(codes))
+	for _, c := range codes {
+		codeSet[strings.ToUpper(c)] = true
+	}
+	return func(req *http.Request, ctx *Pcontext) bool {
+		for _, ip := range destIPs(req, ctx, resolve) {
+			if codeSet["LAN"] && isLANAddr(ip) {
+				return true
+			}
+			if country := db.Country(ip); country != "" && codeSet[country] {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,167 @@
+package mproxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestMMDB 生成只含 IPv4 的最小 MMDB 文件（record_size 24），prefixes 为 CIDR -> 国家代码
+func writeTestMMDB(t *testing.T, path string, prefixes map[string]string) {
+	t.Helper()
+	const empty = -1
+	type node struct{ rec [2]int } // >=0 子节点下标，empty 无数据，<= -2 数据下标 -(i+2)
+	nodes := []node{{rec: [2]int{empty, empty}}}
+
+	var data bytes.Buffer
+	offsets := map[string]int{}
+	for cidr, code := range prefixes {
+		if _, ok := offsets[code]; !ok {
+			offsets[code] = data.Len()
+			data.Write(mmdbMap(1))
+			data.Write(mmdbString("country"))
+			data.Write(mmdbMap(1))
+			data.Write(mmdbString("iso_code"))
+			data.Write(mmdbString(code))
+		}
+		p := netip.MustParsePrefix(cidr)
+		ip := p.Addr().As4()
+		cur := 0
+		for i := 0; i < p.Bits(); i++ {
+			bit := (ip[i/8] >> (7 - uint(i%8))) & 1
+			if i == p.Bits()-1 {
+				nodes[cur].rec[bit] = -(offsets[code] + 2)
+				break
+			}
+			if nodes[cur].rec[bit] < 0 {
+				nodes = append(nodes, node{rec: [2]int{empty, empty}})
+				nodes[cur].rec[bit] = len(nodes) - 1
+			}
+			cur = nodes[cur].rec[bit]
+		}
+	}
+
+	var out bytes.Buffer
+	n := len(nodes)
+	for _, nd := range nodes {
+		for _, r := range nd.rec {
+			v := r
+			switch {
+			case r == empty:
+				v = n
+			case r <= -2:
+				v = n + 16 + (-r - 2)
+			}
+			out.Write([]byte{byte(v >> 16), byte(v >> 8), byte(v)})
+		}
+	}
+	out.Write(make([]byte, 16))
+	out.Write(data.Bytes())
+
+	out.WriteString("\xab\xcd\xefMaxMind.com")
+	out.Write(mmdbMap(9))
+	out.Write(mmdbString("node_count"))
+	out.Write(mmdbUint(6, uint64(n)))
+	out.Write(mmdbString("record_size"))
+	out.Write(mmdbUint(5, 24))
+	out.Write(mmdbString("ip_version"))
+	out.Write(mmdbUint(5, 4))
+	out.Write(mmdbString("database_type"))
+	out.Write(mmdbString("Test-Country"))
+	out.Write(mmdbString("languages"))
+	out.Write([]byte{0x00, 11 - 7}) // 空数组（扩展类型 11）
+	out.Write(mmdbString("binary_format_major_version"))
+	out.Write(mmdbUint(5, 2))
+	out.Write(mmdbString("binary_format_minor_version"))
+	out.Write(mmdbUint(5, 0))
+	out.Write(mmdbString("build_epoch"))
+	out.Write(mmdbUint(9, uint64(time.Now().Unix())))
+	out.Write(mmdbString("description"))
+	out.Write(mmdbMap(0))
+
+	require.NoError(t, os.WriteFile(path, out.Bytes(), 0644))
+}
+
+func mmdbString(s string) []byte { return append([]byte{0x40 | byte(len(s))}, s...) }
+
+func mmdbMap(size int) []byte { return []byte{0xe0 | byte(size)} }
+
+// mmdbUint typ 为 5(uint16)、6(uint32) 或 9(uint64，扩展类型)
+func mmdbUint(typ byte, v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	b := bytes.TrimLeft(buf[:], "\x00")
+	if typ > 7 {
+		return append([]byte{byte(len(b)), typ - 7}, b...)
+	}
+	return append([]byte{typ<<5 | byte(len(b))}, b...)
+}
+
+func TestGeoIPDatabase_CountryAndReload(t *testing.T) {
+	old := geoIPWatchInterval
+	geoIPWatchInterval = 20 * time.Millisecond
+	defer func() { geoIPWatchInterval = old }()
+
+	path := filepath.Join(t.TempDir(), "Country.mmdb")
+	writeTestMMDB(t, path, map[string]string{"1.0.0.0/8": "CN", "8.8.8.0/24": "US"})
+
+	db, err := OpenGeoIPDatabase(NewCoreHttpSever(), path)
+	require.NoError(t, err)
+	defer db.Close()
+	assert.Equal(t, "CN", db.Country(netip.MustParseAddr("1.2.3.4")))
+	assert.Equal(t, "US", db.Country(netip.MustParseAddr("8.8.8.8")))
+	assert.Equal(t, "", db.Country(netip.MustParseAddr("9.9.9.9")))
+
+	// 替换文件后自动重新加载
+	writeTestMMDB(t, path, map[string]string{"1.0.0.0/8": "JP"})
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(path, future, future))
+	assert.Eventually(t, func() bool {
+		return db.Country(netip.MustParseAddr("1.2.3.4")) == "JP"
+	}, 2*time.Second, 20*time.Millisecond)
+
+	// 损坏的文件不影响已加载的数据
+	require.NoError(t, os.WriteFile(path, []byte("broken"), 0644))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, "JP", db.Country(netip.MustParseAddr("1.2.3.4")))
+}
+
+func TestRouter_GeoIPRoutes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "Country.mmdb")
+	writeTestMMDB(t, path, map[string]string{"1.0.0.0/8": "CN", "127.0.0.0/8": "ZZ"})
+
+	cfg := &ServerConfig{
+		ProxyNodes: []ProxyNode{{Name: "corp", URL: "http://127.0.0.1:1"}},
+		Routes: []RouteRule{
+			{Id: 1, Type: "GEOIP", Value: "lan", Action: "Direct", Enable: true, NoResolve: true},
+			{Id: 2, Type: "GEOIP", Value: "cn, zz", Action: "corp", Enable: true},
+		},
+	}
+	router := NewRouter(NewCoreHttpSever())
+	require.NoError(t, router.ReloadFromConfig(cfg))
+	assert.Empty(t, router.Rules, "未配置数据库时 GEOIP 规则被跳过")
+
+	cfg.GeoIPDatabase = path
+	require.NoError(t, router.ReloadFromConfig(cfg))
+	require.Len(t, router.Rules, 2)
+
+	for host, want := range map[string]string{
+		"1.2.3.4:443":    "corp",
+		"8.8.8.8:443":    "Direct",
+		"192.168.1.1:80": "Direct",
+		"localhost:80":   "corp", // LAN 规则不解析域名，由第二条规则解析后命中 ZZ
+	} {
+		req, err := http.NewRequest(http.MethodConnect, "http://"+host, nil)
+		require.NoError(t, err)
+		target, _ := router.MatchRoute(req)
+		assert.Equal(t, want, target, host)
+	}
+}
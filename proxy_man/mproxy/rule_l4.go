@@ -0,0 +1,145 @@
+package mproxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// portRange 闭区间端口范围
+type portRange struct{ lo, hi uint16 }
+
+// parsePortRanges 解析 "443"、"8000-9000" 形式的端口或端口范围
+func parsePortRanges(values []string) ([]portRange, error) {
+	ranges := make([]portRange, 0, len(values))
+	for _, v := range values {
+		lo, hi, isRange := strings.Cut(strings.TrimSpace(v), "-")
+		if !isRange {
+			hi = lo
+		}
+		l, err1 := strconv.ParseUint(strings.TrimSpace(lo), 10, 16)
+		h, err2 := strconv.ParseUint(strings.TrimSpace(hi), 10, 16)
+		if err1 != nil || err2 != nil || l > h {
+			return nil, fmt.Errorf("无效的端口范围 %s", v)
+		}
+		ranges = append(ranges, portRange{uint16(l), uint16(h)})
+	}
+	return ranges, nil
+}
+
+func portInRanges(port int, ranges []portRange) bool {
+	if port <= 0 {
+		return false
+	}
+	for _, r := range ranges {
+		if port >= int(r.lo) && port <= int(r.hi) {
+			return true
+		}
+	}
+	return false
+}
+
+// destPort 返回请求的目标端口，URL 未带端口时按 scheme 取默认值
+func destPort(req *http.Request) int {
+	host := req.URL.Host
+	if host == "" {
+		host = req.Host
+	}
+	if _, p, err := net.SplitHostPort(host); err == nil {
+		port, _ := strconv.Atoi(p)
+		return port
+	}
+	switch req.URL.Scheme {
+	case "https", "wss":
+		return 443
+	default:
+		return 80
+	}
+}
+
+// sourceAddr 解析 req.RemoteAddr 得到客户端地址
+func sourceAddr(req *http.Request) (netip.AddrPort, bool) {
+	ap, err := netip.ParseAddrPort(req.RemoteAddr)
+	if err != nil {
+		return netip.AddrPort{}, false
+	}
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port()), true
+}
+
+// inboundPort 返回请求进入的监听端口，取自 http.LocalAddrContextKey
+func inboundPort(req *http.Request) int {
+	addr, ok := req.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if !ok {
+		return 0
+	}
+	if tcp, ok := addr.(*net.TCPAddr); ok {
+		return tcp.Port
+	}
+	if _, p, err := net.SplitHostPort(addr.String()); err == nil {
+		port, _ := strconv.Atoi(p)
+		return port
+	}
+	return 0
+}
+
+// withListenerAddr 把入站连接的监听地址带入 MITM 内层请求的 context，使 IN-PORT 规则在 MITM 下同样生效
+func withListenerAddr(c context.Context, outer *http.Request) context.Context {
+	if addr, ok := outer.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		return context.WithValue(c, http.LocalAddrContextKey, addr)
+	}
+	return c
+}
+
+// DstPortRule 目标端口匹配规则（DST-PORT），支持 "8000-9000" 形式的范围
+func DstPortRule(ports ...string) (ReqConditionFunc, error) {
+	ranges, err := parsePortRanges(ports)
+	if err != nil {
+		return nil, err
+	}
+	return func(req *http.Request, ctx *Pcontext) bool {
+		return portInRanges(destPort(req), ranges)
+	}, nil
+}
+
+// SrcPortRule 客户端源端口匹配规则（SRC-PORT）
+func SrcPortRule(ports ...string) (ReqConditionFunc, error) {
+	ranges, err := parsePortRanges(ports)
+	if err != nil {
+		return nil, err
+	}
+	return func(req *http.Request, ctx *Pcontext) bool {
+		src, ok := sourceAddr(req)
+		return ok && portInRanges(int(src.Port()), ranges)
+	}, nil
+}
+
+// InPortRule 入站监听端口匹配规则（IN-PORT）
+func InPortRule(ports ...string) (ReqConditionFunc, error) {
+	ranges, err := parsePortRanges(ports)
+	if err != nil {
+		return nil, err
+	}
+	return func(req *http.Request, ctx *Pcontext) bool {
+		return portInRanges(inboundPort(req), ranges)
+	}, nil
+}
+
+// SrcIPCIDRRule 客户端源地址匹配规则（SRC-IP-CIDR），地址取自 req.RemoteAddr
+func SrcIPCIDRRule(cidrs ...string) (ReqConditionFunc, error) {
+	trie := newCIDRTrie()
+	for _, c := range cidrs {
+		p, err := parsePrefix(c)
+		if err != nil {
+			return nil, fmt.Errorf("无效的 CIDR %s: %w", c, err)
+		}
+		trie.Insert(p)
+	}
+	return func(req *http.Request, ctx *Pcontext) bool {
+		src, ok := sourceAddr(req)
+		return ok && trie.Contains(src.Addr())
+	}, nil
+}
@@ -0,0 +1,99 @@
+package mproxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDstPortRule(t *testing.T) {
+	_, err := DstPortRule("9000-8000")
+	assert.Error(t, err)
+	_, err = DstPortRule("70000")
+	assert.Error(t, err)
+
+	rule, err := DstPortRule("443", "8000-9000")
+	require.NoError(t, err)
+	for target, want := range map[string]bool{
+		"https://example.com/":      true, // 未带端口时按 scheme 取 443
+		"http://example.com/":       false,
+		"http://example.com:8080/":  true,
+		"http://example.com:9001/":  false,
+		"http://[2001:db8::1]:443/": true,
+	} {
+		req, _ := http.NewRequest(http.MethodGet, target, nil)
+		assert.Equal(t, want, rule(req, &Pcontext{}), target)
+	}
+}
+
+func TestSourceRules(t *testing.T) {
+	srcIP, err := SrcIPCIDRRule("192.168.1.0/24", "fd00::/8")
+	require.NoError(t, err)
+	srcPort, err := SrcPortRule("50000-60000")
+	require.NoError(t, err)
+
+	req, _ := http.NewRequest(http.MethodConnect, "http://example.com:443", nil)
+	req.RemoteAddr = "192.168.1.20:51234"
+	assert.True(t, srcIP(req, &Pcontext{}))
+	assert.True(t, srcPort(req, &Pcontext{}))
+
+	req.RemoteAddr = "[fd00::5]:1234"
+	assert.True(t, srcIP(req, &Pcontext{}))
+	assert.False(t, srcPort(req, &Pcontext{}))
+
+	req.RemoteAddr = "10.0.0.1:51234"
+	assert.False(t, srcIP(req, &Pcontext{}))
+}
+
+func TestInPortRule_ListenerAddrPropagation(t *testing.T) {
+	rule, err := InPortRule("7890")
+	require.NoError(t, err)
+
+	outer, _ := http.NewRequest(http.MethodConnect, "http://example.com:443", nil)
+	outer = outer.WithContext(context.WithValue(outer.Context(), http.LocalAddrContextKey,
+		&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 7890}))
+	assert.True(t, rule(outer, &Pcontext{}))
+
+	// MITM 内层请求从连接中解析，需要显式继承监听地址
+	inner, _ := http.NewRequest(http.MethodGet, "https://example.com/api", nil)
+	assert.False(t, rule(inner, &Pcontext{}))
+	inner = inner.WithContext(withListenerAddr(inner.Context(), outer))
+	assert.True(t, rule(inner, &Pcontext{}))
+}
+
+func TestRouter_L4RulesTunnel(t *testing.T) {
+	srv, router := startRoutedProxy(t, &ServerConfig{
+		Routes: []RouteRule{
+			{Id: 1, Type: "DST-PORT", Value: "25, 465-587", Action: RejectTarget, Enable: true},
+			{Id: 2, Type: "SRC-IP-CIDR", Value: "10.0.0.0/8", Action: RejectDropTarget, Enable: true},
+		},
+	})
+	addr := srv.Listener.Addr().String()
+	_, port, _ := net.SplitHostPort(addr)
+
+	_, r := rawConnect(t, addr, "mail.test:587")
+	resp, err := http.ReadResponse(r, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	// 本机地址不在 SRC-IP-CIDR 中，端口不匹配的请求照常放行
+	echo := startEchoServer(t)
+	conn, r := rawConnect(t, addr, echo)
+	resp, err = http.ReadResponse(r, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assertEcho(t, &readBufferedConn{Conn: conn, r: r})
+
+	// IN-PORT 取代理自身监听端口
+	require.NoError(t, router.ReloadFromConfig(&ServerConfig{
+		Routes: []RouteRule{{Id: 1, Type: "IN-PORT", Value: port, Action: "Reject-With-Status:418", Enable: true}},
+	}))
+	_, r = rawConnect(t, addr, echo)
+	resp, err = http.ReadResponse(r, nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusTeapot, resp.StatusCode)
+}
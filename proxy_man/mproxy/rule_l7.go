@@ -0,0 +1,157 @@
+package mproxy
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// layer7RuleTypes 需要完整 HTTP 请求（MITM 或明文 HTTP）才能判断的规则类型，
+// 隧道模式只能看到 CONNECT 目标，命中前会被跳过
+var layer7RuleTypes = map[string]bool{
+	"URL-REGEX":   true,
+	"PATH-PREFIX": true,
+	"METHOD":      true,
+	"HEADER":      true,
+	"QUERY":       true,
+}
+
+// rawValueRuleTypes Value 中可能含逗号（正则），不按逗号拆分
+var rawValueRuleTypes = map[string]bool{
+	"URL-REGEX": true,
+	"HEADER":    true,
+	"QUERY":     true,
+}
+
+// isTunnelRequest 隧道模式下路由看到的只有 CONNECT 请求本身
+func isTunnelRequest(req *http.Request) bool {
+	return req.Method == http.MethodConnect
+}
+
+// URLRegexRule 完整 URL 正则匹配规则（URL-REGEX），忽略大小写
+func URLRegexRule(patterns ...string) (ReqConditionFunc, error) {
+	regs := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		r, err := regexp.Compile("(?i)" + p)
+		if err != nil {
+			return nil, fmt.Errorf("无效的正则 %s: %w", p, err)
+		}
+		regs = append(regs, r)
+	}
+	return func(req *http.Request, ctx *Pcontext) bool {
+		u := req.URL.String()
+		for _, r := range regs {
+			if r.MatchString(u) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// PathPrefixRule 路径前缀匹配规则（PATH-PREFIX）。值以 "/" 开头时只比较路径，
+// 否则视为 "host/path" 同时限定主机；末尾的 "*" 会被忽略，如 "/api/v2/*"
+func PathPrefixRule(prefixes ...string) ReqConditionFunc {
+	type prefix struct{ host, path string }
+	list := make([]prefix, 0, len(prefixes))
+	for _, p := range prefixes {
+		p = strings.TrimSuffix(p, "*")
+		if strings.HasPrefix(p, "/") {
+			list = append(list, prefix{path: p})
+			continue
+		}
+		host, path, _ := strings.Cut(p, "/")
+		list = append(list, prefix{host: strings.ToLower(host), path: "/" + path})
+	}
+	return func(req *http.Request, ctx *Pcontext) bool {
+		path := req.URL.Path
+		if path == "" {
+			path = "/"
+		}
+		for _, p := range list {
+			if p.host != "" && extractHost(req) != p.host {
+				continue
+			}
+			if strings.HasPrefix(path, p.path) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// MethodRule 请求方法匹配规则（METHOD）
+func MethodRule(methods ...string) ReqConditionFunc {
+	set := make(map[string]bool, len(methods))
+	for _, m := range methods {
+		set[strings.ToUpper(m)] = true
+	}
+	return func(req *http.Request, ctx *Pcontext) bool {
+		return set[req.Method]
+	}
+}
+
+// parseNameRegex 解析 "name=regex"，省略 "=regex" 时只要求存在
+func parseNameRegex(value string) (string, *regexp.Regexp, error) {
+	name, pattern, hasPattern := strings.Cut(value, "=")
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", nil, fmt.Errorf("缺少名称: %s", value)
+	}
+	if !hasPattern {
+		return name, nil, nil
+	}
+	r, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", nil, fmt.Errorf("无效的正则 %s: %w", pattern, err)
+	}
+	return name, r, nil
+}
+
+// HeaderRule 请求头匹配规则（HEADER），值写作 "X-Canary=^(1|true)$"，任意一个同名头部匹配即命中
+func HeaderRule(value string) (ReqConditionFunc, error) {
+	name, r, err := parseNameRegex(value)
+	if err != nil {
+		return nil, err
+	}
+	name = http.CanonicalHeaderKey(name)
+	return func(req *http.Request, ctx *Pcontext) bool {
+		values, ok := req.Header[name]
+		if !ok {
+			return false
+		}
+		if r == nil {
+			return true
+		}
+		for _, v := range values {
+			if r.MatchString(v) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// QueryRule 查询参数匹配规则（QUERY），值写作 "version=^2" 或只写参数名
+func QueryRule(value string) (ReqConditionFunc, error) {
+	name, r, err := parseNameRegex(value)
+	if err != nil {
+		return nil, err
+	}
+	return func(req *http.Request, ctx *Pcontext) bool {
+		values, ok := req.URL.Query()[name]
+		if !ok {
+			return false
+		}
+		if r == nil {
+			return true
+		}
+		for _, v := range values {
+			if r.MatchString(v) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
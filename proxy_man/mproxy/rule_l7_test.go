@@ -0,0 +1,96 @@
+package mproxy
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLayer7Rules(t *testing.T) {
+	urlRule, err := URLRegexRule(`^https://example\.com/v\d+/users,\d+`)
+	require.NoError(t, err)
+	_, err = URLRegexRule("(")
+	assert.Error(t, err)
+	header, err := HeaderRule("x-canary=^(1|true)$")
+	require.NoError(t, err)
+	query, err := QueryRule("debug")
+	require.NoError(t, err)
+	_, err = HeaderRule("=abc")
+	assert.Error(t, err)
+
+	path := PathPrefixRule("/api/v2/*", "api.example.com/internal/")
+	method := MethodRule("post", "PUT")
+
+	req, _ := http.NewRequest(http.MethodPost, "https://example.com/v2/users,42?debug", nil)
+	req.Header.Set("X-Canary", "true")
+	assert.True(t, urlRule(req, &Pcontext{}))
+	assert.True(t, header(req, &Pcontext{}))
+	assert.True(t, query(req, &Pcontext{}))
+	assert.True(t, method(req, &Pcontext{}))
+	assert.False(t, path(req, &Pcontext{}))
+
+	req, _ = http.NewRequest(http.MethodGet, "https://api.example.com/internal/metrics", nil)
+	assert.True(t, path(req, &Pcontext{}))
+	assert.False(t, method(req, &Pcontext{}))
+	assert.False(t, header(req, &Pcontext{}))
+
+	req, _ = http.NewRequest(http.MethodGet, "https://other.com/internal/metrics", nil)
+	assert.False(t, path(req, &Pcontext{}), "限定主机的前缀不匹配其他主机")
+	req, _ = http.NewRequest(http.MethodGet, "https://other.com/api/v2/users", nil)
+	assert.True(t, path(req, &Pcontext{}))
+}
+
+func TestRouter_Layer7SkippedInTunnel(t *testing.T) {
+	proxy := NewCoreHttpSever()
+	var logs bytes.Buffer
+	proxy.Logger = log.New(&logs, "", 0)
+	router := NewRouter(proxy)
+	require.NoError(t, router.ReloadFromConfig(&ServerConfig{
+		ProxyNodes: []ProxyNode{{Name: "canary", URL: "http://127.0.0.1:1"}},
+		Routes: []RouteRule{
+			{Id: 7, Type: "HEADER", Value: "X-Env=canary|beta", Action: "canary", Enable: true},
+			{Id: 8, Type: "DomainSuffix", Value: "example.com", Action: RejectTarget, Enable: true},
+		},
+	}))
+
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com/", nil)
+	req.Header.Set("X-Env", "beta")
+	target, _ := router.MatchRoute(req)
+	assert.Equal(t, "canary", target)
+
+	connect, _ := http.NewRequest(http.MethodConnect, "http://example.com:443", nil)
+	connect.Header.Set("X-Env", "beta")
+	target, _ = router.MatchRoute(connect)
+	assert.Equal(t, RejectTarget, target, "隧道模式跳过七层规则，继续匹配后续规则")
+	assert.Contains(t, logs.String(), "规则 #7")
+}
+
+func TestRouter_PathPrefixHTTP(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("backend"))
+	}))
+	defer backend.Close()
+
+	srv, _ := startRoutedProxy(t, &ServerConfig{
+		Routes: []RouteRule{{Id: 1, Type: "PATH-PREFIX", Value: "/api/v2/", Action: "Reject-With-Status:418", Enable: true}},
+	})
+	proxyURL, _ := url.Parse(srv.URL)
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}, Timeout: 5 * time.Second}
+
+	resp, err := client.Get(backend.URL + "/api/v2/users")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusTeapot, resp.StatusCode)
+
+	resp, err = client.Get(backend.URL + "/api/v1/users")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
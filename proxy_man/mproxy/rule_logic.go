@@ -0,0 +1,129 @@
+package mproxy
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// 复合规则类型，子规则写在 RouteRule.SubRules 中，可任意嵌套
+const (
+	RuleAnd = "AND"
+	RuleOr  = "OR"
+	RuleNot = "NOT"
+)
+
+func isCompositeRule(ruleType string) bool {
+	return ruleType == RuleAnd || ruleType == RuleOr || ruleType == RuleNot
+}
+
+// RuleError 复合规则中单个子规则的构建错误
+type RuleError struct {
+	Path string // 子规则在 SubRules 中的下标路径，如 "1.0"
+	Type string
+	Err  error
+}
+
+func (e *RuleError) Error() string {
+	return fmt.Sprintf("子规则 %s (%s): %v", e.Path, e.Type, e.Err)
+}
+
+func (e *RuleError) Unwrap() error { return e.Err }
+
+// AndRule 所有条件都满足才匹配，按顺序短路求值
+func AndRule(conds ...ReqCondition) ReqConditionFunc {
+	return func(req *http.Request, ctx *Pcontext) bool {
+		for _, c := range conds {
+			if !c.HandleReq(req, ctx) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// OrRule 任一条件满足即匹配
+func OrRule(conds ...ReqCondition) ReqConditionFunc {
+	return func(req *http.Request, ctx *Pcontext) bool {
+		for _, c := range conds {
+			if c.HandleReq(req, ctx) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// NotRule 条件取反
+func NotRule(cond ReqCondition) ReqConditionFunc {
+	return func(req *http.Request, ctx *Pcontext) bool {
+		return !cond.HandleReq(req, ctx)
+	}
+}
+
+// compileRule 把一条规则（含嵌套子规则）编译为单个 ReqCondition。
+// 子规则的错误不会在第一处中断，而是全部收集后逐条返回，便于一次性修正配置
+func compileRule(route RouteRule, env *ruleBuildEnv, path string) (ReqCondition, []error) {
+	wrap := func(err error) []error {
+		if path == "" {
+			return []error{err}
+		}
+		return []error{&RuleError{Path: path, Type: route.Type, Err: err}}
+	}
+
+	if !isCompositeRule(route.Type) {
+		values := splitRuleValues(route)
+		if len(values) == 0 {
+			return nil, wrap(fmt.Errorf("规则值为空"))
+		}
+		cond, err := buildRuleCondition(route, values, env)
+		if err != nil {
+			return nil, wrap(err)
+		}
+		return cond, nil
+	}
+
+	if len(route.SubRules) == 0 {
+		return nil, wrap(fmt.Errorf("%s 规则缺少子规则", route.Type))
+	}
+	if route.Type == RuleNot && len(route.SubRules) != 1 {
+		return nil, wrap(fmt.Errorf("NOT 规则只能有一个子规则，实际 %d 个", len(route.SubRules)))
+	}
+
+	var errs []error
+	conds := make([]ReqCondition, 0, len(route.SubRules))
+	for i, sub := range route.SubRules {
+		subPath := strconv.Itoa(i)
+		if path != "" {
+			subPath = path + "." + subPath
+		}
+		cond, subErrs := compileRule(sub, env, subPath)
+		errs = append(errs, subErrs...)
+		conds = append(conds, cond)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	switch route.Type {
+	case RuleAnd:
+		return AndRule(conds...), nil
+	case RuleOr:
+		return OrRule(conds...), nil
+	default:
+		return NotRule(conds[0]), nil
+	}
+}
+
+// ruleNeedsLayer7 规则树中任一子规则依赖完整 HTTP 请求时，整条规则在隧道模式下都无法判断
+func ruleNeedsLayer7(route RouteRule) bool {
+	if layer7RuleTypes[route.Type] {
+		return true
+	}
+	for _, sub := range route.SubRules {
+		if ruleNeedsLayer7(sub) {
+			return true
+		}
+	}
+	return false
+}
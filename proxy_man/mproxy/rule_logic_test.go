@@ -0,0 +1,92 @@
+package mproxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRouter_CompositeRules(t *testing.T) {
+	var routes []RouteRule
+	require.NoError(t, json.Unmarshal([]byte(`[
+		{"Id": 1, "Type": "AND", "Action": "corp", "Enable": true, "SubRules": [
+			{"Type": "DomainSuffix", "Value": "corp.com"},
+			{"Type": "DST-PORT", "Value": "8443"}
+		]},
+		{"Id": 2, "Type": "NOT", "Action": "Reject", "Enable": true, "SubRules": [
+			{"Type": "OR", "SubRules": [
+				{"Type": "IP-CIDR", "Value": "10.0.0.0/8", "NoResolve": true},
+				{"Type": "DomainSuffix", "Value": "corp.com"}
+			]}
+		]}
+	]`), &routes))
+
+	router := NewRouter(NewCoreHttpSever())
+	require.NoError(t, router.ReloadFromConfig(&ServerConfig{
+		ProxyNodes: []ProxyNode{{Name: "corp", URL: "http://127.0.0.1:1"}},
+		Routes:     routes,
+	}))
+	require.Len(t, router.Rules, 2)
+
+	for host, want := range map[string]string{
+		"git.corp.com:8443": "corp",
+		"git.corp.com:443":  "Direct", // AND 只满足一半，NOT(OR) 不成立
+		"10.1.1.1:443":      "Direct",
+		"example.com:443":   RejectTarget,
+	} {
+		req, err := http.NewRequest(http.MethodConnect, "http://"+host, nil)
+		require.NoError(t, err)
+		target, _ := router.MatchRoute(req)
+		assert.Equal(t, want, target, host)
+	}
+}
+
+func TestRouter_CompositeRuleErrorsReportedIndividually(t *testing.T) {
+	proxy := NewCoreHttpSever()
+	var logs bytes.Buffer
+	proxy.Logger = log.New(&logs, "", 0)
+	router := NewRouter(proxy)
+
+	require.NoError(t, router.ReloadFromConfig(&ServerConfig{
+		Routes: []RouteRule{
+			{Id: 5, Type: "AND", Action: "Direct", Enable: true, SubRules: []RouteRule{
+				{Type: "DST-PORT", Value: "99999"},
+				{Type: "OR", SubRules: []RouteRule{
+					{Type: "DomainSuffix", Value: "ok.com"},
+					{Type: "IP-CIDR", Value: "bad-cidr"},
+				}},
+				{Type: "NOT", SubRules: []RouteRule{
+					{Type: "METHOD", Value: "GET"},
+					{Type: "METHOD", Value: "POST"},
+				}},
+			}},
+			{Id: 6, Type: "OR", Action: "Direct", Enable: true},
+		},
+	}))
+	assert.Empty(t, router.Rules)
+
+	out := logs.String()
+	assert.Contains(t, out, "子规则 0 (DST-PORT)")
+	assert.Contains(t, out, "子规则 1.1 (IP-CIDR)")
+	assert.Contains(t, out, "子规则 2 (NOT)")
+	assert.Contains(t, out, "规则 #6 无效")
+
+	_, errs := compileRule(RouteRule{Type: RuleAnd, SubRules: []RouteRule{{Type: "DST-PORT", Value: "x"}}}, nil, "")
+	require.Len(t, errs, 1)
+	var ruleErr *RuleError
+	require.ErrorAs(t, errs[0], &ruleErr)
+	assert.Equal(t, "0", ruleErr.Path)
+}
+
+func TestRuleNeedsLayer7(t *testing.T) {
+	assert.True(t, ruleNeedsLayer7(RouteRule{Type: RuleAnd, SubRules: []RouteRule{
+		{Type: "DomainSuffix", Value: "a.com"},
+		{Type: RuleNot, SubRules: []RouteRule{{Type: "PATH-PREFIX", Value: "/api"}}},
+	}}))
+	assert.False(t, ruleNeedsLayer7(RouteRule{Type: RuleOr, SubRules: []RouteRule{{Type: "DST-PORT", Value: "443"}}}))
+}
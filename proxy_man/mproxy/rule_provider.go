@@ -0,0 +1,351 @@
+package mproxy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 规则集提供者类型
+const (
+	ProviderFile = "file"
+	ProviderHTTP = "http"
+)
+
+// 规则集内容格式
+const (
+	BehaviorDomain    = "domain"    // Clash domain-set：每行一个域名，"+." 前缀匹配自身及子域名，"." 前缀只匹配子域名
+	BehaviorIPCIDR    = "ipcidr"    // 每行一个 CIDR
+	BehaviorClassical = "classical" // 每行一条 "类型,值" 规则，如 DOMAIN-SUFFIX,google.com
+)
+
+const providerFetchTimeout = 30 * time.Second
+
+// ruleSet 一份解析后的规则集，构建后只读，刷新时整体替换
+type ruleSet struct {
+	exact      map[string]struct{} // 完整域名
+	suffix     map[string]struct{} // 匹配自身及子域名
+	subdomain  map[string]struct{} // 只匹配子域名（domain-set 的 ".example.com"）
+	wildcard   map[string]struct{} // 只匹配一级子域名（"*.example.com"）
+	keywords   []string            // 子串匹配
+	keywordAC  *keywordMatcher     // keywords 编译后的自动机
+	cidr       *cidrTrie
+	count      int
+	skipped    int // 无法解析或不支持的行数
+	hasDomains bool
+}
+
+func newRuleSet() *ruleSet {
+	return &ruleSet{
+		exact:     make(map[string]struct{}),
+		suffix:    make(map[string]struct{}),
+		subdomain: make(map[string]struct{}),
+		wildcard:  make(map[string]struct{}),
+		cidr:      newCIDRTrie(),
+	}
+}
+
+// parseRuleSet 解析规则集文本。兼容纯文本（每行一条）和 Clash YAML（payload: 列表）两种写法，
+// 忽略空行、# 注释和不支持的规则类型
+func parseRuleSet(behavior string, data []byte) (*ruleSet, error) {
+	set := newRuleSet()
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") || line == "payload:" {
+			continue
+		}
+		if strings.HasPrefix(line, "- ") || line == "-" {
+			line = strings.TrimSpace(strings.TrimPrefix(line, "-"))
+		}
+		line = strings.Trim(line, `'"`)
+		if line == "" {
+			continue
+		}
+
+		var err error
+		switch behavior {
+		case BehaviorDomain:
+			err = set.addDomain(line)
+		case BehaviorIPCIDR:
+			err = set.addCIDR(line)
+		case BehaviorClassical:
+			err = set.addClassical(line)
+		default:
+			return nil, fmt.Errorf("未知的规则集格式 %s", behavior)
+		}
+		if err != nil {
+			set.skipped++
+			continue
+		}
+		set.count++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	set.keywordAC = newKeywordMatcher(set.keywords, make([]int, len(set.keywords)))
+	return set, nil
+}
+
+func (s *ruleSet) addDomain(d string) error {
+	d = strings.ToLower(d)
+	switch {
+	case strings.HasPrefix(d, "+."):
+		s.suffix[d[2:]] = struct{}{}
+	case strings.HasPrefix(d, "*."):
+		s.wildcard[d[2:]] = struct{}{}
+	case strings.HasPrefix(d, "."):
+		s.subdomain[d[1:]] = struct{}{}
+	case strings.ContainsAny(d, " ,*"):
+		return fmt.Errorf("无效的域名 %s", d)
+	default:
+		s.exact[d] = struct{}{}
+	}
+	s.hasDomains = true
+	return nil
+}
+
+func (s *ruleSet) addCIDR(c string) error {
+	p, err := parsePrefix(c)
+	if err != nil {
+		return fmt.Errorf("无效的 CIDR %s: %w", c, err)
+	}
+	s.cidr.Insert(p)
+	return nil
+}
+
+// addClassical 解析 "类型,值[,参数]" 形式的规则，参数（如 no-resolve）由 RULE-SET 规则统一控制
+func (s *ruleSet) addClassical(line string) error {
+	parts := strings.Split(line, ",")
+	if len(parts) < 2 {
+		return fmt.Errorf("无效的规则 %s", line)
+	}
+	value := strings.ToLower(strings.TrimSpace(parts[1]))
+	switch strings.ToUpper(strings.TrimSpace(parts[0])) {
+	case "DOMAIN":
+		s.exact[value] = struct{}{}
+	case "DOMAIN-SUFFIX":
+		s.suffix[strings.TrimPrefix(value, ".")] = struct{}{}
+	case "DOMAIN-KEYWORD":
+		s.keywords = append(s.keywords, value)
+	case "IP-CIDR", "IP-CIDR6":
+		return s.addCIDR(value)
+	default:
+		return fmt.Errorf("不支持的规则类型 %s", parts[0])
+	}
+	s.hasDomains = true
+	return nil
+}
+
+// matchDomain 逐级剥离标签查表，耗时只与域名层级相关
+func (s *ruleSet) matchDomain(host string) bool {
+	if !s.hasDomains || host == "" {
+		return false
+	}
+	if _, ok := s.exact[host]; ok {
+		return true
+	}
+	if _, ok := s.suffix[host]; ok {
+		return true
+	}
+	for i, level := 0, 0; i < len(host); i++ {
+		if host[i] != '.' {
+			continue
+		}
+		parent := host[i+1:]
+		if _, ok := s.suffix[parent]; ok {
+			return true
+		}
+		if _, ok := s.subdomain[parent]; ok {
+			return true
+		}
+		if _, ok := s.wildcard[parent]; ok && level == 0 {
+			return true
+		}
+		level++
+	}
+	return s.keywordAC.contains(host)
+}
+
+// RuleSetProvider 规则集提供者，从本地文件或 HTTP 地址加载规则集并按间隔刷新，
+// 刷新成功后原子替换，引用它的 RULE-SET 规则无需重建
+type RuleSetProvider struct {
+	proxy *CoreHttpServer
+	cfg   RuleProvider
+	set   atomic.Pointer[ruleSet]
+
+	updatedAt atomic.Int64 // 最近一次成功加载的 Unix 时间
+	client    *http.Client
+
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewRuleSetProvider 创建提供者并完成首次加载。HTTP 类型下载失败时回退到 Path 指定的本地缓存。
+// cfg 原样保存，供热重载比较配置是否变化
+func NewRuleSetProvider(proxy *CoreHttpServer, cfg RuleProvider) (*RuleSetProvider, error) {
+	switch cfg.Type {
+	case ProviderFile:
+		if cfg.Path == "" {
+			return nil, fmt.Errorf("规则集 %s 未配置 Path", cfg.Name)
+		}
+	case ProviderHTTP:
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("规则集 %s 未配置 URL", cfg.Name)
+		}
+	default:
+		return nil, fmt.Errorf("规则集 %s 未知的类型 %s", cfg.Name, cfg.Type)
+	}
+
+	p := &RuleSetProvider{
+		proxy:  proxy,
+		cfg:    cfg,
+		client: &http.Client{Timeout: providerFetchTimeout},
+		stop:   make(chan struct{}),
+	}
+	if err := p.Refresh(); err != nil {
+		if cfg.Type != ProviderHTTP || cfg.Path == "" {
+			return nil, err
+		}
+		data, cacheErr := os.ReadFile(cfg.Path)
+		if cacheErr != nil {
+			return nil, err
+		}
+		if err := p.apply(data); err != nil {
+			return nil, err
+		}
+		proxy.Logger.Printf("WARN: [规则集] %s 下载失败，使用本地缓存: %v", cfg.Name, err)
+	}
+	if cfg.Interval > 0 {
+		go p.loop(time.Duration(cfg.Interval) * time.Second)
+	}
+	return p, nil
+}
+
+func (p *RuleSetProvider) Name() string { return p.cfg.Name }
+
+// Count 返回当前规则集中的有效条目数
+func (p *RuleSetProvider) Count() int {
+	if s := p.set.Load(); s != nil {
+		return s.count
+	}
+	return 0
+}
+
+// UpdatedAt 返回最近一次成功加载的时间
+func (p *RuleSetProvider) UpdatedAt() time.Time {
+	return time.Unix(p.updatedAt.Load(), 0)
+}
+
+// Refresh 立即重新加载规则集，失败时保留旧数据
+func (p *RuleSetProvider) Refresh() error {
+	data, err := p.fetch()
+	if err != nil {
+		return fmt.Errorf("加载规则集 %s 失败: %w", p.cfg.Name, err)
+	}
+	if err := p.apply(data); err != nil {
+		return err
+	}
+	if p.cfg.Type == ProviderHTTP && p.cfg.Path != "" {
+		tmp := p.cfg.Path + ".tmp"
+		if err := os.WriteFile(tmp, data, 0644); err == nil {
+			os.Rename(tmp, p.cfg.Path)
+		}
+	}
+	return nil
+}
+
+func (p *RuleSetProvider) apply(data []byte) error {
+	behavior := p.cfg.Behavior
+	if behavior == "" {
+		behavior = BehaviorClassical
+	}
+	set, err := parseRuleSet(behavior, data)
+	if err != nil {
+		return fmt.Errorf("解析规则集 %s 失败: %w", p.cfg.Name, err)
+	}
+	if set.skipped > 0 {
+		p.proxy.Logger.Printf("WARN: [规则集] %s 跳过 %d 行无效或不支持的规则", p.cfg.Name, set.skipped)
+	}
+	p.set.Store(set)
+	p.updatedAt.Store(time.Now().Unix())
+	return nil
+}
+
+func (p *RuleSetProvider) fetch() ([]byte, error) {
+	if p.cfg.Type == ProviderFile {
+		return os.ReadFile(p.cfg.Path)
+	}
+	resp, err := p.client.Get(p.cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (p *RuleSetProvider) loop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			if err := p.Refresh(); err != nil {
+				p.proxy.Logger.Printf("WARN: [规则集] %v，继续使用旧数据", err)
+				continue
+			}
+			p.proxy.Logger.Printf("INFO: [规则集] %s 已刷新，%d 条", p.cfg.Name, p.Count())
+		}
+	}
+}
+
+// Close 停止后台刷新
+func (p *RuleSetProvider) Close() error {
+	p.closeOnce.Do(func() { close(p.stop) })
+	return nil
+}
+
+// Match 判断请求是否命中规则集。resolve 为 true 时对域名请求解析后再匹配 CIDR 条目
+func (p *RuleSetProvider) Match(req *http.Request, ctx *Pcontext, resolve bool) bool {
+	set := p.set.Load()
+	if set == nil {
+		return false
+	}
+	if set.matchDomain(extractHost(req)) {
+		return true
+	}
+	if set.cidr.Len() == 0 {
+		return false
+	}
+	for _, ip := range destIPs(req, ctx, resolve) {
+		if set.cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// RuleSetRule 引用规则集的匹配规则（RULE-SET），命中任意一个规则集即匹配
+func RuleSetRule(resolve bool, providers ...*RuleSetProvider) ReqConditionFunc {
+	return func(req *http.Request, ctx *Pcontext) bool {
+		for _, p := range providers {
+			if p.Match(req, ctx, resolve) {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,160 @@
+package mproxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRuleSet_DomainSet(t *testing.T) {
+	set, err := parseRuleSet(BehaviorDomain, []byte(`
+# 注释
+exact.com
++.google.com
+.cdn.net
+*.wild.org
+`))
+	require.NoError(t, err)
+	assert.Equal(t, 4, set.count)
+
+	cases := map[string]bool{
+		"exact.com":       true,
+		"www.exact.com":   false,
+		"google.com":      true,
+		"mail.google.com": true,
+		"cdn.net":         false, // "." 前缀只匹配子域名
+		"a.b.cdn.net":     true,
+		"x.wild.org":      true,
+		"y.x.wild.org":    false, // "*" 只匹配一级
+		"notgoogle.com":   false,
+	}
+	for host, want := range cases {
+		assert.Equal(t, want, set.matchDomain(host), host)
+	}
+}
+
+func TestParseRuleSet_ClassicalYAML(t *testing.T) {
+	set, err := parseRuleSet(BehaviorClassical, []byte(`payload:
+  - DOMAIN,api.example.com
+  - 'DOMAIN-SUFFIX,github.io'
+  - DOMAIN-KEYWORD,tracker
+  - IP-CIDR,10.0.0.0/8,no-resolve
+  - IP-CIDR6,2001:db8::/32
+  - PROCESS-NAME,curl
+`))
+	require.NoError(t, err)
+	assert.Equal(t, 5, set.count)
+	assert.Equal(t, 1, set.skipped, "不支持的规则类型被跳过")
+
+	assert.True(t, set.matchDomain("api.example.com"))
+	assert.False(t, set.matchDomain("www.example.com"))
+	assert.True(t, set.matchDomain("user.github.io"))
+	assert.True(t, set.matchDomain("ads.tracker-cdn.com"))
+}
+
+func TestRuleSetProvider_FileAndRouter(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "lan.list")
+	require.NoError(t, os.WriteFile(path, []byte("10.0.0.0/8\n192.168.0.0/16\n"), 0644))
+
+	cfg := &ServerConfig{
+		ProxyNodes:    []ProxyNode{{Name: "corp", URL: "http://127.0.0.1:1"}},
+		RuleProviders: []RuleProvider{{Name: "lan", Type: ProviderFile, Behavior: BehaviorIPCIDR, Path: path}},
+		Routes: []RouteRule{
+			{Id: 1, Type: "RULE-SET", Value: "lan", Action: "corp", Enable: true, NoResolve: true},
+			{Id: 2, Type: "RULE-SET", Value: "missing", Action: "corp", Enable: true},
+		},
+	}
+	router := NewRouter(NewCoreHttpSever())
+	require.NoError(t, router.ReloadFromConfig(cfg))
+	require.Len(t, router.Rules, 1, "引用不存在规则集的规则被跳过")
+
+	match := func(host string) string {
+		req, err := http.NewRequest(http.MethodConnect, "http://"+host, nil)
+		require.NoError(t, err)
+		target, _ := router.MatchRoute(req)
+		return target
+	}
+	assert.Equal(t, "corp", match("10.1.2.3:443"))
+	assert.Equal(t, "Direct", match("172.16.0.1:443"))
+
+	// 配置未变化时热重载沿用同一实例
+	provider := router.providers["lan"]
+	require.NoError(t, router.ReloadFromConfig(cfg))
+	assert.Same(t, provider, router.providers["lan"])
+
+	// 刷新后规则集被原子替换，无需重建规则
+	require.NoError(t, os.WriteFile(path, []byte("172.16.0.0/12\n"), 0644))
+	require.NoError(t, provider.Refresh())
+	assert.Equal(t, "corp", match("172.16.0.1:443"))
+	assert.Equal(t, "Direct", match("10.1.2.3:443"))
+}
+
+func TestRuleSetProvider_HTTPWithCache(t *testing.T) {
+	var body atomic.Value
+	body.Store("+.example.com\n")
+	var fail atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(body.Load().(string)))
+	}))
+	defer srv.Close()
+
+	cache := filepath.Join(t.TempDir(), "ads.txt")
+	cfg := RuleProvider{Name: "ads", Type: ProviderHTTP, Behavior: BehaviorDomain, URL: srv.URL, Path: cache}
+	p, err := NewRuleSetProvider(NewCoreHttpSever(), cfg)
+	require.NoError(t, err)
+	defer p.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, "http://www.example.com/", nil)
+	assert.True(t, p.Match(req, &Pcontext{}, false))
+
+	body.Store("+.example.org\n")
+	require.NoError(t, p.Refresh())
+	assert.False(t, p.Match(req, &Pcontext{}, false))
+
+	// 刷新失败保留旧数据
+	fail.Store(true)
+	assert.Error(t, p.Refresh())
+	assert.Equal(t, 1, p.Count())
+
+	// 下载失败时回退到本地缓存
+	p2, err := NewRuleSetProvider(NewCoreHttpSever(), cfg)
+	require.NoError(t, err)
+	defer p2.Close()
+	orgReq, _ := http.NewRequest(http.MethodGet, "http://example.org/", nil)
+	assert.True(t, p2.Match(orgReq, &Pcontext{}, false))
+}
+
+func TestRuleSetProvider_ReloadReusesDefaultBehavior(t *testing.T) {
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Write([]byte("DOMAIN-SUFFIX,example.com\n"))
+	}))
+	defer srv.Close()
+
+	// 未设置 Behavior，按 classical 解析
+	cfg := &ServerConfig{
+		RuleProviders: []RuleProvider{{Name: "ads", Type: ProviderHTTP, URL: srv.URL}},
+		Routes:        []RouteRule{{Id: 1, Type: "RULE-SET", Value: "ads", Action: "Reject", Enable: true}},
+	}
+	router := NewRouter(NewCoreHttpSever())
+	require.NoError(t, router.ReloadFromConfig(cfg))
+	provider := router.providers["ads"]
+	require.NotNil(t, provider)
+	assert.Equal(t, 1, provider.Count())
+
+	require.NoError(t, router.ReloadFromConfig(cfg))
+	assert.Same(t, provider, router.providers["ads"])
+	assert.EqualValues(t, 1, fetches.Load(), "配置未变化时不应重新下载")
+}
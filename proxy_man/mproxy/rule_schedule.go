@@ -0,0 +1,114 @@
+package mproxy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	// 内嵌时区数据库：Windows 上没有 Go 安装或系统 zoneinfo 时 time.LoadLocation 也能解析 Timezone
+	_ "time/tzdata"
+)
+
+// weekdayNames 星期的英文写法，另支持数字 0-7（0 和 7 均为周日）
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday,
+	"tue": time.Tuesday, "tuesday": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday,
+	"thu": time.Thursday, "thursday": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+}
+
+// ruleWindow 编译后的生效时间窗口
+type ruleWindow struct {
+	days       [7]bool
+	start, end int // 一天中的分钟数，start > end 表示跨越午夜
+	allDay     bool
+	loc        *time.Location
+}
+
+// compileSchedule 解析规则的时间窗口配置，schedule 为 nil 时返回 nil 表示始终生效
+func compileSchedule(schedule *RuleSchedule) (*ruleWindow, error) {
+	if schedule == nil {
+		return nil, nil
+	}
+	w := &ruleWindow{loc: time.Local}
+	if schedule.Timezone != "" {
+		loc, err := time.LoadLocation(schedule.Timezone)
+		if err != nil {
+			return nil, fmt.Errorf("无效的时区 %s: %w", schedule.Timezone, err)
+		}
+		w.loc = loc
+	}
+
+	if len(schedule.Weekdays) == 0 {
+		for i := range w.days {
+			w.days[i] = true
+		}
+	}
+	for _, d := range schedule.Weekdays {
+		key := strings.ToLower(strings.TrimSpace(d))
+		if day, ok := weekdayNames[key]; ok {
+			w.days[day] = true
+			continue
+		}
+		n, err := strconv.Atoi(key)
+		if err != nil || n < 0 || n > 7 {
+			return nil, fmt.Errorf("无效的星期 %s", d)
+		}
+		w.days[n%7] = true
+	}
+
+	if schedule.Start == "" && schedule.End == "" {
+		w.allDay = true
+		return w, nil
+	}
+	var err error
+	if w.start, err = parseClock(schedule.Start, 0); err != nil {
+		return nil, err
+	}
+	if w.end, err = parseClock(schedule.End, 24*60); err != nil {
+		return nil, err
+	}
+	if w.start == w.end {
+		return nil, fmt.Errorf("时间段 %s-%s 为空", schedule.Start, schedule.End)
+	}
+	return w, nil
+}
+
+// parseClock 解析 "HH:MM"，为空时返回 def，"24:00" 表示一天结束
+func parseClock(s string, def int) (int, error) {
+	if s == "" {
+		return def, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		if s == "24:00" {
+			return 24 * 60, nil
+		}
+		return 0, fmt.Errorf("无效的时间 %s，应为 HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Contains 判断某一时刻是否落在窗口内。跨午夜的时间段（如周五 22:00-02:00）
+// 午夜之后的部分归属于前一天的星期
+func (w *ruleWindow) Contains(now time.Time) bool {
+	now = now.In(w.loc)
+	day := now.Weekday()
+	if w.allDay {
+		return w.days[day]
+	}
+	minute := now.Hour()*60 + now.Minute()
+	if w.start < w.end {
+		return w.days[day] && minute >= w.start && minute < w.end
+	}
+	if minute >= w.start {
+		return w.days[day]
+	}
+	if minute < w.end {
+		return w.days[(day+6)%7]
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package mproxy
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRuleWindow_Contains(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	require.NoError(t, err)
+
+	// 工作日 09:00-18:00（上海时间）
+	office, err := compileSchedule(&RuleSchedule{
+		Weekdays: []string{"Mon", "tue", "3", "Thursday", "5"},
+		Start:    "09:00", End: "18:00", Timezone: "Asia/Shanghai",
+	})
+	require.NoError(t, err)
+	assert.True(t, office.Contains(time.Date(2026, 10, 16, 10, 0, 0, 0, shanghai)))  // 周五
+	assert.False(t, office.Contains(time.Date(2026, 10, 16, 18, 0, 0, 0, shanghai))) // 结束时间不含
+	assert.False(t, office.Contains(time.Date(2026, 10, 17, 10, 0, 0, 0, shanghai))) // 周六
+	// 时区换算：UTC 02:00 = 上海 10:00
+	assert.True(t, office.Contains(time.Date(2026, 10, 16, 2, 0, 0, 0, time.UTC)))
+
+	// 周五夜间跨午夜，周六凌晨归属周五
+	night, err := compileSchedule(&RuleSchedule{Weekdays: []string{"Fri"}, Start: "22:00", End: "02:00", Timezone: "UTC"})
+	require.NoError(t, err)
+	assert.True(t, night.Contains(time.Date(2026, 10, 16, 23, 0, 0, 0, time.UTC)))
+	assert.True(t, night.Contains(time.Date(2026, 10, 17, 1, 59, 0, 0, time.UTC)))
+	assert.False(t, night.Contains(time.Date(2026, 10, 17, 23, 0, 0, 0, time.UTC)))
+	assert.False(t, night.Contains(time.Date(2026, 10, 16, 1, 0, 0, 0, time.UTC)), "周五凌晨属于周四的窗口")
+
+	weekend, err := compileSchedule(&RuleSchedule{Weekdays: []string{"0", "6"}})
+	require.NoError(t, err)
+	assert.True(t, weekend.Contains(time.Date(2026, 10, 18, 12, 0, 0, 0, time.Local)))
+
+	for _, bad := range []*RuleSchedule{
+		{Weekdays: []string{"Funday"}},
+		{Start: "25:00", End: "26:00"},
+		{Start: "10:00", End: "10:00"},
+		{Timezone: "Mars/Olympus"},
+	} {
+		_, err := compileSchedule(bad)
+		assert.Error(t, err, "%+v", bad)
+	}
+}
+
+func TestRouter_ScheduledRule(t *testing.T) {
+	router := NewRouter(NewCoreHttpSever())
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC) // 周五中午
+	router.Now = func() time.Time { return now }
+
+	require.NoError(t, router.ReloadFromConfig(&ServerConfig{
+		ProxyNodes: []ProxyNode{{Name: "metered", URL: "http://127.0.0.1:1"}},
+		Routes: []RouteRule{{
+			Id: 1, Type: "DomainSuffix", Value: "video.com", Action: "metered", Enable: true,
+			// 非办公时间：工作日 18:00 到次日 09:00
+			Schedule: &RuleSchedule{Weekdays: []string{"Mon", "Tue", "Wed", "Thu", "Fri"}, Start: "18:00", End: "09:00", Timezone: "UTC"},
+		}},
+	}))
+
+	req, _ := http.NewRequest(http.MethodConnect, "http://cdn.video.com:443", nil)
+	target, _ := router.MatchRoute(req)
+	assert.Equal(t, "Direct", target, "窗口外视同未启用")
+
+	now = time.Date(2026, 10, 16, 20, 0, 0, 0, time.UTC)
+	target, _ = router.MatchRoute(req)
+	assert.Equal(t, "metered", target)
+}
@@ -0,0 +1,315 @@
+package mproxy
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+const socks5HandshakeTimeout = 10 * time.Second
+
+// ServeSocks5 在 ln 上接受 SOCKS5 客户端，阻塞直到 ln 关闭。
+// 每个 CONNECT 会话转换为等价的 CONNECT 请求交给 handleConnect，与 HTTP 代理共用规则和连接面板；
+// UDP ASSOCIATE 按目标建立 NAT 会话，同样经路由选择出站
+func (proxy *CoreHttpServer) ServeSocks5(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go proxy.serveSocks5Conn(conn)
+	}
+}
+
+// serveSocks5Conn 处理一个 SOCKS5 客户端连接
+func (proxy *CoreHttpServer) serveSocks5Conn(conn net.Conn) {
+	_ = conn.SetDeadline(time.Now().Add(socks5HandshakeTimeout))
+	cmd, addr, err := proxy.socks5ServerHandshake(conn)
+	if err != nil {
+		proxy.Logger.Printf("WARN: SOCKS5 握手失败 %s: %v", conn.RemoteAddr(), err)
+		_ = conn.Close()
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	switch cmd {
+	case socks5CmdConnect:
+		proxy.handleConnect(conn, newSocksConnectRequest(conn, addr), socks5ConnectReplier{})
+	case socks5CmdUDPAssociate:
+		proxy.serveSocks5UDP(conn, addr)
+	default:
+		_ = writeSocks5Reply(conn, socks5ReplyCmdNotSupported, netip.AddrPort{})
+		_ = conn.Close()
+	}
+}
+
+// socks5ServerHandshake 完成方法协商、可选的用户名密码认证（RFC 1929），返回命令和目标地址
+func (proxy *CoreHttpServer) socks5ServerHandshake(conn net.Conn) (byte, string, error) {
+	var head [2]byte
+	if _, err := io.ReadFull(conn, head[:]); err != nil {
+		return 0, "", err
+	}
+	if head[0] != socks5Version {
+		return 0, "", fmt.Errorf("socks5: 不支持的协议版本 %d", head[0])
+	}
+	methods := make([]byte, head[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return 0, "", err
+	}
+
+	// 认证配置每次握手时读取，修改后对新连接立即生效
+	cfg := proxy.Config.GetConfig()
+	want := byte(socks5AuthNone)
+	if cfg.SocksUsername != "" {
+		want = socks5AuthPassword
+	}
+	offered := false
+	for _, m := range methods {
+		offered = offered || m == want
+	}
+	if !offered {
+		_, _ = conn.Write([]byte{socks5Version, socks5AuthNoAcceptable})
+		return 0, "", errors.New("socks5: 客户端不支持所需的认证方式")
+	}
+	if _, err := conn.Write([]byte{socks5Version, want}); err != nil {
+		return 0, "", err
+	}
+	if want == socks5AuthPassword {
+		if err := socks5CheckPassword(conn, cfg.SocksUsername, cfg.SocksPassword); err != nil {
+			return 0, "", err
+		}
+	}
+
+	var req [3]byte
+	if _, err := io.ReadFull(conn, req[:]); err != nil {
+		return 0, "", err
+	}
+	if req[0] != socks5Version {
+		return 0, "", fmt.Errorf("socks5: 请求版本错误 %d", req[0])
+	}
+	addr, err := readSocks5Addr(conn)
+	if err != nil {
+		_ = writeSocks5Reply(conn, socks5ReplyAddrNotSupported, netip.AddrPort{})
+		return 0, "", err
+	}
+	return req[1], addr, nil
+}
+
+// socks5CheckPassword 读取用户名密码子协商并校验
+func socks5CheckPassword(conn net.Conn, user, pass string) error {
+	var ver [2]byte
+	if _, err := io.ReadFull(conn, ver[:]); err != nil {
+		return err
+	}
+	name := make([]byte, ver[1])
+	if _, err := io.ReadFull(conn, name); err != nil {
+		return err
+	}
+	var plen [1]byte
+	if _, err := io.ReadFull(conn, plen[:]); err != nil {
+		return err
+	}
+	pw := make([]byte, plen[0])
+	if _, err := io.ReadFull(conn, pw); err != nil {
+		return err
+	}
+	nameOk := subtle.ConstantTimeCompare(name, []byte(user)) == 1
+	passOk := subtle.ConstantTimeCompare(pw, []byte(pass)) == 1
+	if !nameOk || !passOk {
+		_, _ = conn.Write([]byte{0x01, 0x01})
+		return fmt.Errorf("socks5: 用户 %q 认证失败", name)
+	}
+	_, err := conn.Write([]byte{0x01, 0x00})
+	return err
+}
+
+// writeSocks5Reply 写回应答，bind 无效时绑定地址填 0.0.0.0:0
+func writeSocks5Reply(w io.Writer, rep byte, bind netip.AddrPort) error {
+	b := []byte{socks5Version, rep, 0x00}
+	if bind.Addr().Is4() || bind.Addr().Is4In6() {
+		b = append(b, socks5AddrIPv4)
+		ip := bind.Addr().Unmap().As4()
+		b = append(b, ip[:]...)
+	} else if bind.Addr().Is6() {
+		b = append(b, socks5AddrIPv6)
+		ip := bind.Addr().As16()
+		b = append(b, ip[:]...)
+	} else {
+		b = append(b, socks5AddrIPv4, 0, 0, 0, 0)
+	}
+	b = append(b, byte(bind.Port()>>8), byte(bind.Port()))
+	_, err := w.Write(b)
+	return err
+}
+
+// newSocksConnectRequest 构造与 HTTP CONNECT 等价的请求，规则匹配、连接面板和 MITM 均以它为准。
+// 上下文携带入站监听地址，IN-PORT 等入站规则与 HTTP 代理行为一致
+func newSocksConnectRequest(conn net.Conn, addr string) *http.Request {
+	req := &http.Request{
+		Method:     http.MethodConnect,
+		URL:        &url.URL{Host: addr},
+		Host:       addr,
+		RequestURI: addr,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Body:       http.NoBody,
+		RemoteAddr: conn.RemoteAddr().String(),
+	}
+	return req.WithContext(context.WithValue(context.Background(), http.LocalAddrContextKey, conn.LocalAddr()))
+}
+
+// socks5ConnectReplier SOCKS5 CONNECT 的应答
+type socks5ConnectReplier struct{}
+
+func (socks5ConnectReplier) protocol() string { return "SOCKS5" }
+
+func (socks5ConnectReplier) established(conn net.Conn, mitm bool) error {
+	return writeSocks5Reply(conn, socks5ReplySucceeded, netip.AddrPort{})
+}
+
+func (socks5ConnectReplier) dialFailed(conn net.Conn, ctx *Pcontext, err error) {
+	_ = writeSocks5Reply(conn, socks5DialErrorReply(err), netip.AddrPort{})
+	_ = conn.Close()
+}
+
+func (socks5ConnectReplier) rejected(conn net.Conn, err *RejectError) {
+	if !err.Drop {
+		_ = writeSocks5Reply(conn, socks5ReplyNotAllowed, netip.AddrPort{})
+	}
+}
+
+func (socks5ConnectReplier) refused(conn net.Conn, ctx *Pcontext) {
+	_ = writeSocks5Reply(conn, socks5ReplyNotAllowed, netip.AddrPort{})
+}
+
+// SOCKS4 协议常量
+const (
+	socks4Version       = 0x04
+	socks4CmdConnect    = 0x01
+	socks4ReplyGranted  = 0x5A
+	socks4ReplyRejected = 0x5B
+)
+
+// serveSocks4Conn 处理一个 SOCKS4/4a 客户端连接，只支持 CONNECT。
+// SOCKS4 无法携带密码，配置了 SOCKS 认证时一律拒绝
+func (proxy *CoreHttpServer) serveSocks4Conn(conn net.Conn) {
+	_ = conn.SetDeadline(time.Now().Add(socks5HandshakeTimeout))
+	cmd, addr, err := readSocks4Request(conn)
+	if err == nil && cmd != socks4CmdConnect {
+		err = fmt.Errorf("socks4: 不支持的命令 %d", cmd)
+	}
+	if err == nil && proxy.Config.GetConfig().SocksUsername != "" {
+		err = errors.New("socks4: 已启用 SOCKS 认证，SOCKS4 无法认证")
+	}
+	if err != nil {
+		proxy.Logger.Printf("WARN: SOCKS4 握手失败 %s: %v", conn.RemoteAddr(), err)
+		_ = writeSocks4Reply(conn, socks4ReplyRejected)
+		_ = conn.Close()
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+	proxy.handleConnect(conn, newSocksConnectRequest(conn, addr), socks4ConnectReplier{})
+}
+
+// readSocks4Request 读取 VN CD DSTPORT DSTIP USERID\0，DSTIP 为 0.0.0.x 时为 4a 扩展，其后是以 \0 结尾的域名
+func readSocks4Request(r io.Reader) (byte, string, error) {
+	var head [8]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return 0, "", err
+	}
+	if head[0] != socks4Version {
+		return 0, "", fmt.Errorf("socks4: 不支持的协议版本 %d", head[0])
+	}
+	port := strconv.Itoa(int(head[2])<<8 | int(head[3]))
+	if _, err := readNullTerminated(r); err != nil { // USERID
+		return 0, "", err
+	}
+	host := net.IP(head[4:8]).String()
+	if head[4] == 0 && head[5] == 0 && head[6] == 0 && head[7] != 0 {
+		name, err := readNullTerminated(r)
+		if err != nil {
+			return 0, "", err
+		}
+		host = name
+	}
+	return head[1], net.JoinHostPort(host, port), nil
+}
+
+// readNullTerminated 逐字节读取以 \0 结尾的字符串，最长 255 字节
+func readNullTerminated(r io.Reader) (string, error) {
+	var b []byte
+	var c [1]byte
+	for {
+		if _, err := io.ReadFull(r, c[:]); err != nil {
+			return "", err
+		}
+		if c[0] == 0 {
+			return string(b), nil
+		}
+		if len(b) == 255 {
+			return "", errors.New("socks4: 字段过长")
+		}
+		b = append(b, c[0])
+	}
+}
+
+func writeSocks4Reply(w io.Writer, rep byte) error {
+	_, err := w.Write([]byte{0x00, rep, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// socks4ConnectReplier SOCKS4/4a CONNECT 的应答，失败原因只有拒绝一种
+type socks4ConnectReplier struct{}
+
+func (socks4ConnectReplier) protocol() string { return "SOCKS4" }
+
+func (socks4ConnectReplier) established(conn net.Conn, mitm bool) error {
+	return writeSocks4Reply(conn, socks4ReplyGranted)
+}
+
+func (socks4ConnectReplier) dialFailed(conn net.Conn, ctx *Pcontext, err error) {
+	_ = writeSocks4Reply(conn, socks4ReplyRejected)
+	_ = conn.Close()
+}
+
+func (socks4ConnectReplier) rejected(conn net.Conn, err *RejectError) {
+	if !err.Drop {
+		_ = writeSocks4Reply(conn, socks4ReplyRejected)
+	}
+}
+
+func (socks4ConnectReplier) refused(conn net.Conn, ctx *Pcontext) {
+	_ = writeSocks4Reply(conn, socks4ReplyRejected)
+}
+
+// socks5DialErrorReply 将拨号错误映射为应答码，便于客户端给出准确提示
+func socks5DialErrorReply(err error) byte {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return socks5ReplyConnRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		return socks5ReplyNetUnreachable
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.As(err, &dnsErr):
+		return socks5ReplyHostUnreachable
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return socks5ReplyHostUnreachable
+	default:
+		return socks5ReplyGeneralFailure
+	}
+}
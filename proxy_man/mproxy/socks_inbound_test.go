@@ -0,0 +1,125 @@
+package mproxy
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startSocksProxy 启动开启路由的 SOCKS5 入站，返回监听地址
+func startSocksProxy(t *testing.T, cfg *ServerConfig) (string, *CoreHttpServer) {
+	t.Helper()
+	return startInbound(t, cfg, (*CoreHttpServer).ServeSocks5)
+}
+
+// startInbound 启动开启路由的代理，由 serve 在本地端口上提供入站
+func startInbound(t *testing.T, cfg *ServerConfig, serve func(*CoreHttpServer, net.Listener) error) (string, *CoreHttpServer) {
+	t.Helper()
+	proxy := NewCoreHttpSever()
+	cm := NewConfigManager(filepath.Join(t.TempDir(), "config.json"))
+	cfg.RouteEnable = true
+	require.NoError(t, cm.UpdateConfig(cfg))
+	proxy.Config = cm
+	AddRouter(proxy, cm)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+	go serve(proxy, ln)
+	return ln.Addr().String(), proxy
+}
+
+// socksDial 经由 SOCKS5 入站连接 target
+func socksDial(t *testing.T, proxyURL, target string) (net.Conn, error) {
+	d, err := NewSocks5ProxyDialer(NewCoreHttpSever(), ProxyNode{Name: "inbound", URL: proxyURL})
+	require.NoError(t, err)
+	return d.Dial("tcp", target)
+}
+
+// findConnections 按协议标签查找连接记录
+func findConnections(proxy *CoreHttpServer, protocol string) []*ConnectionInfo {
+	var out []*ConnectionInfo
+	proxy.Connections.Range(func(_, v any) bool {
+		if info := v.(*ConnectionInfo); info.Protocol == protocol {
+			out = append(out, info)
+		}
+		return true
+	})
+	return out
+}
+
+func TestSocksInbound_TunnelWithAuth(t *testing.T) {
+	echo := startEchoServer(t)
+	addr, proxy := startSocksProxy(t, &ServerConfig{SocksUsername: "alice", SocksPassword: "s3cret"})
+
+	conn, err := socksDial(t, "socks5h://alice:s3cret@"+addr, echo)
+	require.NoError(t, err)
+	assertEcho(t, conn)
+	conn.Close()
+
+	// 与 HTTP CONNECT 相同，隧道建立后的记录覆盖顶层记录
+	assert.Empty(t, findConnections(proxy, "SOCKS5"))
+	tunnels := findConnections(proxy, "HTTPS-Tunnel")
+	require.Len(t, tunnels, 1)
+	assert.Equal(t, echo, tunnels[0].Host)
+	assert.Equal(t, conn.LocalAddr().String(), tunnels[0].RemoteAddr)
+	assert.Equal(t, "TUNNEL", tunnels[0].Method)
+
+	_, err = socksDial(t, "socks5h://alice:wrong@"+addr, echo)
+	assert.ErrorContains(t, err, "认证失败")
+	_, err = socksDial(t, "socks5h://"+addr, echo)
+	assert.Error(t, err, "未提供凭据")
+}
+
+func TestSocksInbound_RejectAndDialError(t *testing.T) {
+	addr, proxy := startSocksProxy(t, rejectTestConfig())
+
+	_, err := socksDial(t, "socks5h://"+addr, "tracker.ads.test:443")
+	assert.ErrorContains(t, err, "connection not allowed by ruleset")
+	top := findConnections(proxy, "SOCKS5")
+	require.Len(t, top, 1)
+	assert.Contains(t, top[0].Error, "规则拒绝")
+
+	// 拨号失败映射为对应的应答码
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closed := ln.Addr().String()
+	ln.Close()
+	_, err = socksDial(t, "socks5h://"+addr, closed)
+	assert.ErrorContains(t, err, "connection refused")
+}
+
+func TestSocksInbound_Mitm(t *testing.T) {
+	target := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello "+r.URL.Path)
+	}))
+	defer target.Close()
+	addr, proxy := startSocksProxy(t, &ServerConfig{MitmEnabled: true})
+
+	conn, err := socksDial(t, "socks5h://"+addr, target.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+	require.NoError(t, tlsConn.Handshake())
+	assert.NotEqual(t, target.Certificate().Raw, tlsConn.ConnectionState().PeerCertificates[0].Raw, "证书由代理签发")
+
+	req, _ := http.NewRequest(http.MethodGet, "https://"+target.Listener.Addr().String()+"/mitm", nil)
+	require.NoError(t, req.Write(tlsConn))
+	resp, err := http.ReadResponse(bufio.NewReader(tlsConn), req)
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, "hello /mitm", string(body))
+
+	mitm := findConnections(proxy, "HTTPS-MITM")
+	require.Len(t, mitm, 1)
+	assert.Equal(t, "https://"+target.Listener.Addr().String()+"/mitm", mitm[0].URL)
+	assert.Equal(t, findConnections(proxy, "SOCKS5")[0].Session, mitm[0].ParentSess)
+}
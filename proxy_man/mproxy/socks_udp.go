@@ -0,0 +1,353 @@
+package mproxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const socks5MaxUDPPacket = 64 * 1024
+
+// udpSessionIdleTimeout UDP NAT 会话的空闲超时，双向都没有数据时关闭会话
+var udpSessionIdleTimeout = 60 * time.Second
+
+// udpAssociation 一个 SOCKS5 UDP ASSOCIATE 关联。控制连接关闭时关联结束；
+// 每个目标地址对应一个 NAT 会话，各自经路由选择出站
+type udpAssociation struct {
+	proxy   *CoreHttpServer
+	ctrl    net.Conn // SOCKS5 控制连接
+	pc      net.PacketConn
+	session int64
+	idle    time.Duration // 会话空闲超时，创建关联时取自 udpSessionIdleTimeout
+
+	clientIP netip.Addr                  // 只接受来自控制连接同一 IP 的数据报
+	client   atomic.Pointer[net.UDPAddr] // 客户端的 UDP 地址，收到第一个数据报后确定
+
+	upload   int64
+	download int64
+
+	mu       sync.Mutex
+	sessions map[string]*udpSession
+	closed   bool
+}
+
+// udpPendingPackets 会话拨号期间最多缓存的数据报数，超出的直接丢弃
+const udpPendingPackets = 16
+
+// udpSession 一个 NAT 会话：客户端 -> 单个目标
+type udpSession struct {
+	assoc    *udpAssociation
+	target   string
+	header   []byte // 回给客户端的数据报头（RSV FRAG ATYP ADDR PORT），拨号成功后设置
+	session  int64
+	upload   int64
+	download int64
+	active   atomic.Int64 // 最近一次收发的 UnixNano
+
+	mu      sync.Mutex
+	conn    net.Conn // 拨号中或拨号失败时为 nil
+	dialing bool
+	pending [][]byte // 拨号期间收到的数据报，拨号成功后按序发出
+	closed  bool
+
+	closeOnce sync.Once
+}
+
+// serveSocks5UDP 处理 UDP ASSOCIATE：在控制连接的本地 IP 上监听 UDP 并回复绑定地址，阻塞直到控制连接关闭
+func (proxy *CoreHttpServer) serveSocks5UDP(ctrl net.Conn, declared string) {
+	defer ctrl.Close()
+	local, _ := netip.ParseAddrPort(ctrl.LocalAddr().String())
+	pc, err := net.ListenUDP("udp", net.UDPAddrFromAddrPort(netip.AddrPortFrom(local.Addr(), 0)))
+	if err != nil {
+		proxy.Logger.Printf("WARN: SOCKS5 UDP 监听失败: %v", err)
+		_ = writeSocks5Reply(ctrl, socks5ReplyGeneralFailure, netip.AddrPort{})
+		return
+	}
+	remote, _ := netip.ParseAddrPort(ctrl.RemoteAddr().String())
+	a := &udpAssociation{
+		proxy:    proxy,
+		ctrl:     ctrl,
+		pc:       pc,
+		session:  atomic.AddInt64(&proxy.sess, 1),
+		idle:     udpSessionIdleTimeout,
+		clientIP: remote.Addr().Unmap(),
+		sessions: make(map[string]*udpSession),
+	}
+	// 客户端事先声明了发送端口时直接锁定，未声明 IP 时沿用控制连接的 IP
+	if ap, err := netip.ParseAddrPort(declared); err == nil && ap.Port() != 0 {
+		ip := ap.Addr().Unmap()
+		if ip.IsUnspecified() {
+			ip = a.clientIP
+		}
+		a.client.Store(net.UDPAddrFromAddrPort(netip.AddrPortFrom(ip, ap.Port())))
+	}
+
+	bind := pc.LocalAddr().(*net.UDPAddr).AddrPort()
+	proxy.Connections.Store(a.session, &ConnectionInfo{
+		Session:     a.session,
+		Host:        bind.String(),
+		Method:      "UDP ASSOCIATE",
+		URL:         "udp://" + bind.String(),
+		RemoteAddr:  ctrl.RemoteAddr().String(),
+		Protocol:    "SOCKS5-UDP",
+		StartTime:   time.Now(),
+		Status:      "Active",
+		UploadRef:   &a.upload,
+		DownloadRef: &a.download,
+		OnClose:     func() { ctrl.Close() },
+	})
+	if err := writeSocks5Reply(ctrl, socks5ReplySucceeded, bind); err != nil {
+		a.close()
+		return
+	}
+
+	go a.readLoop()
+	// 控制连接上不应再有数据，读到 EOF 或出错即结束关联
+	_, _ = io.Copy(io.Discard, ctrl)
+	a.close()
+}
+
+// readLoop 读取客户端数据报，按目标地址分发到 NAT 会话
+func (a *udpAssociation) readLoop() {
+	buf := make([]byte, socks5MaxUDPPacket)
+	for {
+		n, from, err := a.pc.ReadFrom(buf)
+		if err != nil {
+			a.close()
+			return
+		}
+		src := from.(*net.UDPAddr).AddrPort()
+		src = netip.AddrPortFrom(src.Addr().Unmap(), src.Port())
+		if client := a.client.Load(); client != nil {
+			if client.AddrPort() != src {
+				continue
+			}
+		} else if src.Addr() != a.clientIP {
+			continue
+		} else {
+			a.client.Store(net.UDPAddrFromAddrPort(src))
+		}
+
+		target, payload, err := parseSocks5UDPHeader(buf[:n])
+		if err != nil {
+			a.proxy.Logger.Printf("WARN: SOCKS5 UDP 丢弃数据报 %s: %v", src, err)
+			continue
+		}
+		if s := a.getSession(target); s != nil {
+			s.send(payload)
+		}
+	}
+}
+
+// getSession 返回目标对应的 NAT 会话，不存在时新建并在后台经路由拨号，
+// 慢速或不可达的目标不会阻塞 readLoop 处理其他会话的数据报。关联已关闭时返回 nil
+func (a *udpAssociation) getSession(target string) *udpSession {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		return nil
+	}
+	if s, ok := a.sessions[target]; ok {
+		return s
+	}
+
+	ctx := &Pcontext{
+		core_proxy: a.proxy,
+		Req:        newSocksConnectRequest(a.ctrl, target),
+		Session:    atomic.AddInt64(&a.proxy.sess, 1),
+	}
+	s := &udpSession{assoc: a, target: target, session: ctx.Session, dialing: true}
+	a.sessions[target] = s
+	go s.dial(ctx)
+	return s
+}
+
+// dial 建立到目标的连接，完成后登记到连接面板；成功时发出拨号期间缓存的数据报并开始读取回包
+func (s *udpSession) dial(ctx *Pcontext) {
+	a, proxy := s.assoc, s.assoc.proxy
+	start := time.Now()
+	host, portStr, _ := net.SplitHostPort(s.target)
+	port, _ := strconv.Atoi(portStr)
+	header, err := appendSocks5Addr([]byte{0, 0, 0}, host, port)
+	var conn net.Conn
+	if err == nil {
+		conn, err = proxy.dialUDP(ctx, s.target)
+	}
+
+	s.mu.Lock()
+	s.dialing = false
+	pending := s.pending
+	s.pending = nil
+	if s.closed {
+		s.mu.Unlock()
+		if conn != nil {
+			_ = conn.Close()
+		}
+		return
+	}
+	info := &ConnectionInfo{
+		Session:      s.session,
+		ParentSess:   a.session,
+		Host:         s.target,
+		Method:       "UDP",
+		URL:          "udp://" + s.target,
+		RemoteAddr:   a.ctrl.RemoteAddr().String(),
+		Protocol:     "UDP",
+		StartTime:    start,
+		Status:       "Active",
+		PuploadRef:   &a.upload,
+		PdownloadRef: &a.download,
+		UploadRef:    &s.upload,
+		DownloadRef:  &s.download,
+		OnClose:      func() { s.close() },
+	}
+	if err != nil {
+		info.Error = err.Error()
+	}
+	proxy.Connections.Store(s.session, info)
+	if err != nil {
+		s.mu.Unlock()
+		// 失败的会话同样保留一个空闲周期，期间发往该目标的数据报直接丢弃，避免逐包重试拨号
+		ctx.WarnP("SOCKS5 UDP 拨号 %s 失败: %v", s.target, err)
+		time.AfterFunc(a.idle, s.close)
+		return
+	}
+	s.conn, s.header = conn, header
+	s.active.Store(time.Now().UnixNano())
+	// 持锁发出缓存的数据报，保证先于之后 send 的数据报到达
+	for _, p := range pending {
+		s.write(conn, p)
+	}
+	s.mu.Unlock()
+	s.readLoop()
+}
+
+// send 转发一个客户端数据报。拨号期间先缓存，拨号失败的会话直接丢弃
+func (s *udpSession) send(payload []byte) {
+	s.mu.Lock()
+	if s.dialing {
+		if len(s.pending) < udpPendingPackets {
+			s.pending = append(s.pending, bytes.Clone(payload))
+		}
+		s.mu.Unlock()
+		return
+	}
+	conn := s.conn
+	s.mu.Unlock()
+	if conn != nil {
+		s.write(conn, payload)
+	}
+}
+
+func (s *udpSession) write(conn net.Conn, payload []byte) {
+	if _, err := conn.Write(payload); err != nil {
+		s.assoc.proxy.Logger.Printf("WARN: SOCKS5 UDP 发送到 %s 失败: %v", s.target, err)
+		return
+	}
+	s.active.Store(time.Now().UnixNano())
+	atomic.AddInt64(&s.upload, int64(len(payload)))
+	atomic.AddInt64(&s.assoc.upload, int64(len(payload)))
+}
+
+// dialUDP 开启路由时经 ConnectWithReqDial 选择出站，否则直连
+func (proxy *CoreHttpServer) dialUDP(ctx *Pcontext, addr string) (net.Conn, error) {
+	if ctx.Dialer == nil && proxy.ConnectWithReqDial != nil && proxy.Config.GetConfig().RouteEnable {
+		return proxy.ConnectWithReqDial(ctx.Req, "udp", addr)
+	}
+	return proxy.dial(ctx, "udp", addr)
+}
+
+// readLoop 读取目标的回包，加上数据报头转发给客户端。超过空闲时间没有收发时关闭会话
+func (s *udpSession) readLoop() {
+	defer s.close()
+	buf := make([]byte, socks5MaxUDPPacket)
+	copy(buf, s.header)
+	for {
+		idle := time.Unix(0, s.active.Load()).Add(s.assoc.idle)
+		_ = s.conn.SetReadDeadline(idle)
+		n, err := s.conn.Read(buf[len(s.header):])
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() &&
+				time.Until(time.Unix(0, s.active.Load()).Add(s.assoc.idle)) > 0 {
+				continue // 期间有上行数据，顺延
+			}
+			return
+		}
+		s.active.Store(time.Now().UnixNano())
+		client := s.assoc.client.Load()
+		if client == nil {
+			continue
+		}
+		if _, err := s.assoc.pc.WriteTo(buf[:len(s.header)+n], client); err != nil {
+			return
+		}
+		atomic.AddInt64(&s.download, int64(n))
+		atomic.AddInt64(&s.assoc.download, int64(n))
+	}
+}
+
+// close 关闭会话并从关联中移除，可重复调用
+func (s *udpSession) close() {
+	s.closeOnce.Do(func() {
+		a := s.assoc
+		a.mu.Lock()
+		if a.sessions[s.target] == s {
+			delete(a.sessions, s.target)
+		}
+		a.mu.Unlock()
+		s.mu.Lock()
+		s.closed = true
+		conn := s.conn
+		s.mu.Unlock()
+		if conn != nil {
+			_ = conn.Close()
+		}
+		a.proxy.MarkConnectionClosed(s.session)
+	})
+}
+
+// close 结束关联，关闭所有 NAT 会话
+func (a *udpAssociation) close() {
+	a.mu.Lock()
+	if a.closed {
+		a.mu.Unlock()
+		return
+	}
+	a.closed = true
+	sessions := make([]*udpSession, 0, len(a.sessions))
+	for _, s := range a.sessions {
+		sessions = append(sessions, s)
+	}
+	a.mu.Unlock()
+
+	_ = a.pc.Close()
+	_ = a.ctrl.Close()
+	for _, s := range sessions {
+		s.close()
+	}
+	a.proxy.MarkConnectionClosed(a.session)
+}
+
+// parseSocks5UDPHeader 解析 SOCKS5 UDP 数据报头（RFC 1928 第 7 节），不支持分片
+func parseSocks5UDPHeader(b []byte) (string, []byte, error) {
+	if len(b) < 4 {
+		return "", nil, errors.New("数据报过短")
+	}
+	if b[2] != 0 {
+		return "", nil, fmt.Errorf("不支持分片 FRAG=%d", b[2])
+	}
+	r := bytes.NewReader(b[3:])
+	addr, err := readSocks5Addr(r)
+	if err != nil {
+		return "", nil, err
+	}
+	return addr, b[len(b)-r.Len():], nil
+}
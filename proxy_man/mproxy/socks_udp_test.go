@@ -0,0 +1,218 @@
+package mproxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startUDPEchoServer 启动 UDP 回显服务器
+func startUDPEchoServer(t *testing.T) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(buf[:n], from)
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+// socksUDPClient 极简的 SOCKS5 UDP 客户端
+type socksUDPClient struct {
+	ctrl  net.Conn
+	relay net.Conn
+}
+
+func newSocksUDPClient(t *testing.T, proxyAddr string) *socksUDPClient {
+	ctrl, err := net.Dial("tcp", proxyAddr)
+	require.NoError(t, err)
+	t.Cleanup(func() { ctrl.Close() })
+	_, err = ctrl.Write([]byte{socks5Version, 1, socks5AuthNone})
+	require.NoError(t, err)
+	var choice [2]byte
+	_, err = io.ReadFull(ctrl, choice[:])
+	require.NoError(t, err)
+	_, err = ctrl.Write([]byte{socks5Version, socks5CmdUDPAssociate, 0, socks5AddrIPv4, 0, 0, 0, 0, 0, 0})
+	require.NoError(t, err)
+	var reply [3]byte
+	_, err = io.ReadFull(ctrl, reply[:])
+	require.NoError(t, err)
+	require.EqualValues(t, socks5ReplySucceeded, reply[1])
+	bind, err := readSocks5Addr(ctrl)
+	require.NoError(t, err)
+
+	relay, err := net.Dial("udp", bind)
+	require.NoError(t, err)
+	t.Cleanup(func() { relay.Close() })
+	return &socksUDPClient{ctrl: ctrl, relay: relay}
+}
+
+func (c *socksUDPClient) send(t *testing.T, target string, payload []byte) {
+	host, port, _ := net.SplitHostPort(target)
+	p, _ := strconv.Atoi(port)
+	header, err := appendSocks5Addr([]byte{0, 0, 0}, host, p)
+	require.NoError(t, err)
+	_, err = c.relay.Write(append(header, payload...))
+	require.NoError(t, err)
+}
+
+// recv 读取一个数据报，超时返回错误
+func (c *socksUDPClient) recv(timeout time.Duration) (string, []byte, error) {
+	buf := make([]byte, 2048)
+	c.relay.SetReadDeadline(time.Now().Add(timeout))
+	n, err := c.relay.Read(buf)
+	if err != nil {
+		return "", nil, err
+	}
+	return parseSocks5UDPHeader(buf[:n])
+}
+
+func TestSocksUDP_DirectRelay(t *testing.T) {
+	echo := startUDPEchoServer(t)
+	addr, proxy := startSocksProxy(t, &ServerConfig{})
+	client := newSocksUDPClient(t, addr)
+
+	for _, msg := range []string{"ping", "pong!"} {
+		client.send(t, echo, []byte(msg))
+		from, payload, err := client.recv(2 * time.Second)
+		require.NoError(t, err)
+		assert.Equal(t, echo, from)
+		assert.Equal(t, msg, string(payload))
+	}
+
+	assoc := findConnections(proxy, "SOCKS5-UDP")
+	require.Len(t, assoc, 1)
+	sessions := findConnections(proxy, "UDP")
+	require.Len(t, sessions, 1, "同一目标复用一个 NAT 会话")
+	assert.Equal(t, assoc[0].Session, sessions[0].ParentSess)
+	assert.Equal(t, "udp://"+echo, sessions[0].URL)
+	assert.EqualValues(t, 9, atomic.LoadInt64(sessions[0].UploadRef))
+	assert.EqualValues(t, 9, atomic.LoadInt64(sessions[0].DownloadRef))
+	assert.EqualValues(t, 9, atomic.LoadInt64(assoc[0].UploadRef))
+
+	// 其他来源的数据报被忽略
+	stranger, err := net.Dial("udp", client.relay.RemoteAddr().String())
+	require.NoError(t, err)
+	defer stranger.Close()
+	stranger.Write([]byte("spoof"))
+	stranger.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	_, err = stranger.Read(make([]byte, 64))
+	assert.Error(t, err)
+}
+
+func TestSocksUDP_IdleTimeout(t *testing.T) {
+	old := udpSessionIdleTimeout
+	udpSessionIdleTimeout = 200 * time.Millisecond
+	t.Cleanup(func() { udpSessionIdleTimeout = old })
+
+	echo := startUDPEchoServer(t)
+	addr, proxy := startSocksProxy(t, &ServerConfig{})
+	client := newSocksUDPClient(t, addr)
+
+	client.send(t, echo, []byte("a"))
+	_, _, err := client.recv(2 * time.Second)
+	require.NoError(t, err)
+	time.Sleep(500 * time.Millisecond)
+
+	// 会话已超时关闭，再次发送建立新的会话
+	client.send(t, echo, []byte("b"))
+	_, payload, err := client.recv(2 * time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "b", string(payload))
+	assert.Len(t, findConnections(proxy, "UDP"), 2)
+
+	// 控制连接关闭后关联结束
+	client.ctrl.Close()
+	time.Sleep(100 * time.Millisecond)
+	client.send(t, echo, []byte("c"))
+	_, _, err = client.recv(200 * time.Millisecond)
+	assert.Error(t, err)
+}
+
+func TestSocksUDP_Routing(t *testing.T) {
+	echo := startUDPEchoServer(t)
+	blocked := startUDPEchoServer(t)
+	viaHTTP := startUDPEchoServer(t)
+	_, blockedPort, _ := net.SplitHostPort(blocked)
+	_, httpPort, _ := net.SplitHostPort(viaHTTP)
+
+	addr, proxy := startSocksProxy(t, &ServerConfig{
+		ProxyNodes: []ProxyNode{{Name: "http-node", URL: "http://127.0.0.1:1"}},
+		Routes: []RouteRule{
+			{Id: 1, Type: "DST-PORT", Value: blockedPort, Action: RejectTarget, Enable: true},
+			{Id: 2, Type: "DST-PORT", Value: httpPort, Action: "http-node", Enable: true},
+		},
+	})
+	client := newSocksUDPClient(t, addr)
+
+	for _, target := range []string{blocked, viaHTTP} {
+		client.send(t, target, []byte("x"))
+		_, _, err := client.recv(200 * time.Millisecond)
+		assert.Error(t, err, "被拒绝或出站不支持 UDP 时丢弃数据报")
+	}
+	client.send(t, echo, []byte("ok"))
+	_, payload, err := client.recv(2 * time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(payload))
+
+	errs := map[string]string{}
+	for _, info := range findConnections(proxy, "UDP") {
+		errs[info.Host] = info.Error
+	}
+	assert.Contains(t, errs[blocked], "规则拒绝")
+	assert.Contains(t, errs[viaHTTP], "不支持 UDP")
+	assert.Empty(t, errs[echo])
+}
+
+func TestSocksUDP_SlowDialDoesNotBlock(t *testing.T) {
+	echo := startUDPEchoServer(t)
+	slow := startUDPEchoServer(t)
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+
+	addr, _ := startInbound(t, &ServerConfig{}, func(p *CoreHttpServer, ln net.Listener) error {
+		routeDial := p.ConnectWithReqDial
+		p.ConnectWithReqDial = func(req *http.Request, network, addr string) (net.Conn, error) {
+			if addr == slow {
+				<-release // 模拟不可达目标，拨号直到超时才返回
+			}
+			return routeDial(req, network, addr)
+		}
+		return p.ServeSocks5(ln)
+	})
+	client := newSocksUDPClient(t, addr)
+
+	// 先建立到正常目标的会话
+	client.send(t, echo, []byte("a"))
+	_, payload, err := client.recv(2 * time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "a", string(payload))
+
+	// 慢速目标拨号期间，已有会话和新的正常目标都不受影响
+	client.send(t, slow, []byte("queued"))
+	client.send(t, echo, []byte("b"))
+	_, payload, err = client.recv(2 * time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "b", string(payload))
+
+	// 拨号完成后发出拨号期间缓存的数据报
+	release <- struct{}{}
+	from, payload, err := client.recv(2 * time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, slow, from)
+	assert.Equal(t, "queued", string(payload))
+}
@@ -19,6 +19,8 @@ func (h *WebSocketHub) updateSubscription(sub *Subscription, msg map[string]any)
 		sub.Connections = contains(topics, "connections")
 		sub.Logs = contains(topics, "logs")
 		sub.MitmDetail = contains(topics, "mitm_detail")
+		sub.Health = contains(topics, "health")
+		sub.Routing = contains(topics, "routing")
 	}
 	if logLevel, ok := msg["logLevel"].(string); ok {
 		sub.LogLevel = logLevel
@@ -67,6 +69,10 @@ func (h *WebSocketHub) broadcastToTopic(topic string, msg any) {
 			shouldSend = sub.Connections
 		case "mitm_detail":
 			shouldSend = sub.MitmDetail
+		case "health":
+			shouldSend = sub.Health
+		case "routing":
+			shouldSend = sub.Routing
 		}
 
 		if shouldSend {
@@ -179,6 +185,9 @@ func (h *WebSocketHub) StartConnectionPusher() {
 				if info.DownloadRef != nil {
 					connData["down"] = *info.DownloadRef
 				}
+				if info.Error != "" {
+					connData["error"] = info.Error
+				}
 				connections = append(connections, connData)
 			}
 
@@ -320,3 +329,39 @@ func (h *WebSocketHub) sendMitmBatch(batch []*mproxy.HttpExchange) {
 	}
 	h.broadcastToTopic("mitm_detail", msg)
 }
+
+// 节点健康检查推送器（每 3 秒推送一次，未启用健康检查时不推送）
+func (h *WebSocketHub) StartHealthPusher() {
+	go func() {
+		ticker := time.NewTicker(3 * time.Second)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if h.router == nil || !h.router.Health.Enabled() {
+				continue
+			}
+			h.broadcastToTopic("health", map[string]any{
+				"type": "health",
+				"data": h.router.Health.Snapshot(),
+			})
+		}
+	}()
+}
+
+// 路由规则命中统计推送器（每 2 秒推送一次）
+func (h *WebSocketHub) StartRoutingPusher() {
+	go func() {
+		ticker := time.NewTicker(2 * time.Second)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if h.router == nil {
+				continue
+			}
+			h.broadcastToTopic("routing", map[string]any{
+				"type": "routing",
+				"data": h.router.Stats(),
+			})
+		}
+	}()
+}
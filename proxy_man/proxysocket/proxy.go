@@ -56,6 +56,8 @@ type Subscription struct {
 	Logs        bool
 	LogLevel    string
 	MitmDetail  bool       // MITM Exchange 详细信息
+	Health      bool       // 节点健康检查结果
+	Routing     bool       // 路由规则命中统计
 	writeMu     sync.Mutex // 保护 WebSocket 写操作
 }
 
@@ -63,6 +65,7 @@ type Subscription struct {
 type WebSocketHub struct {
 	clients sync.Map // *websocket.Conn -> *Subscription
 	proxy   *mproxy.CoreHttpServer
+	router  *mproxy.Router
 }
 
 var hub *WebSocketHub
@@ -75,12 +78,17 @@ type WebsocketServer struct {
 
 // 启动控制服务器
 func (ws *WebsocketServer) StartControlServer(cm *mproxy.ConfigManager, router *mproxy.Router) bool {
-	hub = &WebSocketHub{proxy: ws.Proxy}
+	hub = &WebSocketHub{proxy: ws.Proxy, router: router}
 	mux := http.NewServeMux()
 	mux.HandleFunc("/start", ws.loginHandler(ws.handleWebSocket))
-	mux.HandleFunc("/api/storage/download", myminio.HandleDownload) // MinIO 下载 API
-	mux.HandleFunc("/api/config", ws.handleConfig(cm, router))      // 配置管理 API
-	mux.HandleFunc("/", handleStaticFiles)                          // 静态文件服务 + SPA fallback
+	mux.HandleFunc("/api/storage/download", myminio.HandleDownload)    // MinIO 下载 API
+	mux.HandleFunc("/api/config", ws.handleConfig(cm, router))         // 配置管理 API
+	mux.HandleFunc("/api/health", ws.handleHealth(router))             // 节点健康检查 API
+	mux.HandleFunc("/api/route/explain", ws.handleExplain(cm, router)) // 路由试运行 / 规则检查 API
+	mux.HandleFunc("/api/route/stats", ws.handleRouteStats(router))    // 路由规则命中统计 API
+	mux.HandleFunc("/api/dns/log", ws.handleDNSLog)                    // DNS 查询日志 API
+	mux.HandleFunc("/api/dns/query", ws.handleDNSQuery)                // DNS 手动解析 API
+	mux.HandleFunc("/", handleStaticFiles)                             // 静态文件服务 + SPA fallback
 
 	corsMiddleware := cors.New(cors.Options{
 		AllowedOrigins:   []string{"*"},
@@ -94,6 +102,8 @@ func (ws *WebsocketServer) StartControlServer(cm *mproxy.ConfigManager, router *
 	hub.StartConnectionPusher()
 	hub.StartLogPusher()
 	hub.StartMitmDetailPusher()
+	hub.StartHealthPusher()
+	hub.StartRoutingPusher()
 
 	var err error
 	go func() {
@@ -199,6 +209,11 @@ func (ws *WebsocketServer) handleConfig(cm *mproxy.ConfigManager, router *mproxy
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
+			// DNS 与全局拨号参数与路由开关无关，始终热重载
+			ws.Proxy.Resolver.Reload(updated.DNS)
+			if err := ws.Proxy.Resolver.ReloadDial(updated.Dial); err != nil {
+				ws.Proxy.Logger.Printf("WARN: 全局拨号参数无效，保留原参数: %v", err)
+			}
 			// 热重载路由
 			if updated.RouteEnable {
 				router.ReloadFromConfig(&updated)
@@ -211,6 +226,132 @@ func (ws *WebsocketServer) handleConfig(cm *mproxy.ConfigManager, router *mproxy
 	}
 }
 
+// handleHealth 返回节点健康检查结果，POST 时先立即执行一轮探测
+func (ws *WebsocketServer) handleHealth(router *mproxy.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case "GET":
+		case "POST":
+			router.Health.CheckNow()
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{
+			"enabled": router.Health.Enabled(),
+			"nodes":   router.Health.Snapshot(),
+		})
+	}
+}
+
+// handleExplain 路由试运行：POST 一个假想请求，返回命中的规则和逐条评估过程，不发起任何连接。
+// ?mode=lint 时检查规则配置（POST 配置体则检查该配置，否则检查当前配置）
+func (ws *WebsocketServer) handleExplain(cm *mproxy.ConfigManager, router *mproxy.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("mode") == "lint" {
+			var cfg mproxy.ServerConfig
+			if r.Method == "POST" {
+				// 解码到新对象，避免覆盖当前配置共享的切片
+				if err := json.NewDecoder(r.Body).Decode(&cfg); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			} else {
+				cfg = cm.GetConfig()
+			}
+			json.NewEncoder(w).Encode(map[string]any{"issues": router.Lint(&cfg)})
+			return
+		}
+
+		if r.Method != "POST" {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		var er mproxy.ExplainRequest
+		if err := json.NewDecoder(r.Body).Decode(&er); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req, err := mproxy.NewExplainRequest(er)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !cm.GetConfig().RouteEnable {
+			// 路由关闭时所有流量直连
+			json.NewEncoder(w).Encode(map[string]any{"routeEnable": false, "target": "Direct", "ruleId": 0, "rules": []any{}})
+			return
+		}
+		result := router.Explain(req)
+		json.NewEncoder(w).Encode(map[string]any{
+			"routeEnable": true,
+			"target":      result.Target,
+			"ruleId":      result.RuleId,
+			"rules":       result.Rules,
+		})
+	}
+}
+
+// handleRouteStats 返回路由规则命中统计，POST 时清零后返回
+func (ws *WebsocketServer) handleRouteStats(router *mproxy.Router) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case "GET":
+		case "POST":
+			router.ResetStats()
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		json.NewEncoder(w).Encode(router.Stats())
+	}
+}
+
+// handleDNSLog 返回最近的 DNS 查询记录
+func (ws *WebsocketServer) handleDNSLog(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"logs": ws.Proxy.Resolver.QueryLog()})
+}
+
+// handleDNSQuery 经内置解析器解析请求体中的域名并返回结果（用于排查解析问题），
+// 该次解析同样会记入查询日志
+func (ws *WebsocketServer) handleDNSQuery(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var body struct {
+		Host string `json:"host"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if body.Host == "" {
+		http.Error(w, "host 不能为空", http.StatusBadRequest)
+		return
+	}
+	result := map[string]any{"host": body.Host}
+	ips, err := ws.Proxy.Resolver.LookupNetIP(r.Context(), body.Host)
+	if err != nil {
+		result["error"] = err.Error()
+	}
+	answers := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		answers = append(answers, ip.String())
+	}
+	result["answers"] = answers
+	json.NewEncoder(w).Encode(result)
+}
+
 // handleStaticFiles 提供嵌入的前端静态文件，支持 Vue Router History 模式
 func handleStaticFiles(w http.ResponseWriter, r *http.Request) {
 	path := strings.TrimPrefix(r.URL.Path, "/")